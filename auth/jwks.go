@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksRefreshInterval is how long loaded keys are used before the JWKS is
+	// loaded again, so rotated keys of the identity provider are picked up.
+	jwksRefreshInterval = 10 * time.Minute
+	// jwksMinRefreshInterval limits reloads on tokens with an unknown kid, so
+	// such tokens cannot hammer the identity provider.
+	jwksMinRefreshInterval = time.Minute
+	// maxJWKSSize caps the JWKS document read from a file or URL.
+	maxJWKSSize = 1 << 20
+)
+
+var errUnknownKey = errors.New("no key in the JWKS matches the token")
+
+// JWKS holds the public keys of a JSON Web Key Set loaded from a file or an
+// URL, by kid.
+type JWKS struct {
+	source string
+	load   func() ([]byte, error)
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	loadedAt    time.Time
+	attemptedAt time.Time
+}
+
+// NewJWKS loads the key set at source, which is a file path unless it starts
+// with http:// or https://.
+func NewJWKS(source string) (*JWKS, error) {
+	s := &JWKS{source: source, load: jwksLoader(source)}
+	if err := s.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+func jwksLoader(source string) func() ([]byte, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := &http.Client{Timeout: 5 * time.Second}
+		return func() ([]byte, error) {
+			resp, err := client.Get(source)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("unexpected status %s", resp.Status)
+			}
+			return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+		}
+	}
+	return func() ([]byte, error) {
+		return os.ReadFile(source)
+	}
+}
+
+func (s *JWKS) reload(now time.Time) error {
+	s.attemptedAt = now
+	data, err := s.load()
+	if err != nil {
+		return fmt.Errorf("could not load JWKS from %s: %w", s.source, err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("could not parse JWKS from %s: %w", s.source, err)
+	}
+	s.keys = keys
+	s.loadedAt = now
+	return nil
+}
+
+// key returns the key with kid. A token without kid may use the key of a set
+// that holds only one. Keys are reloaded when they are stale or kid is
+// unknown; if that fails the keys already loaded stay in use.
+func (s *JWKS) key(kid string, now time.Time) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.lookup(kid)
+	stale := now.Sub(s.loadedAt) >= jwksRefreshInterval
+	if (stale || !ok) && now.Sub(s.attemptedAt) >= jwksMinRefreshInterval {
+		if err := s.reload(now); err != nil {
+			log.Printf("%v", err)
+		} else {
+			key, ok = s.lookup(kid)
+		}
+	}
+	if !ok {
+		return nil, errUnknownKey
+	}
+	return key, nil
+}
+func (s *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS reads the RSA and EC signing keys of a key set. Keys of other
+// types or for encryption are skipped.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			key, err = rsaKey(jwk)
+		case "EC":
+			key, err = ecKey(jwk)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA or EC signing keys")
+	}
+	return keys, nil
+}
+func rsaKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid n: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid e: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid e")
+	}
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+	if key.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must have at least 2048 bits")
+	}
+	return key, nil
+}
+func ecKey(jwk jsonWebKey) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y: %w", err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(x) != size || len(y) != size {
+		return nil, errors.New("x and y must have the size of the curve")
+	}
+	// checks that the point is on the curve
+	return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+}
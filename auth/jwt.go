@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is the clock skew allowed between us and the identity provider
+// when checking exp and nbf.
+const jwtLeeway = time.Minute
+
+// JWTConfig configures which JWTs are accepted and how their claims are read.
+type JWTConfig struct {
+	// JWKS is the file path or URL of the key set the tokens are signed with.
+	JWKS     string
+	Issuer   string
+	Audience string
+	// TenantClaim names the claim holding the caller's organization id.
+	TenantClaim string
+	// ScopeClaim names the claim holding the scopes, either a space
+	// separated string or a list.
+	ScopeClaim string
+}
+
+// JWTConfigFromEnv reads JWT_JWKS, JWT_ISSUER, JWT_AUDIENCE, JWT_TENANT_CLAIM
+// and JWT_SCOPE_CLAIM. It returns false when JWT_JWKS is unset, which leaves
+// JWTs disabled.
+func JWTConfigFromEnv() (JWTConfig, bool, error) {
+	cfg := JWTConfig{
+		JWKS:        os.Getenv("JWT_JWKS"),
+		Issuer:      os.Getenv("JWT_ISSUER"),
+		Audience:    os.Getenv("JWT_AUDIENCE"),
+		TenantClaim: os.Getenv("JWT_TENANT_CLAIM"),
+		ScopeClaim:  os.Getenv("JWT_SCOPE_CLAIM"),
+	}
+	if cfg.JWKS == "" {
+		return JWTConfig{}, false, nil
+	}
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return JWTConfig{}, false, errors.New("JWT_ISSUER and JWT_AUDIENCE must be set with JWT_JWKS")
+	}
+	if cfg.TenantClaim == "" {
+		cfg.TenantClaim = "org_id"
+	}
+	if cfg.ScopeClaim == "" {
+		cfg.ScopeClaim = "scope"
+	}
+	return cfg, true, nil
+}
+
+// JWTClaims is what a verified token says about its caller.
+type JWTClaims struct {
+	Subject string
+	// Tenant is the organization id from the tenant claim, if any.
+	Tenant string
+	Scopes []string
+}
+
+// JWTVerifier checks the signature, issuer, audience and lifetime of JWTs.
+type JWTVerifier struct {
+	cfg  JWTConfig
+	keys *JWKS
+	now  func() time.Time
+}
+
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	keys, err := NewJWKS(cfg.JWKS)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTVerifier{cfg: cfg, keys: keys, now: time.Now}, nil
+}
+
+// jwtAlgorithms are the accepted signature algorithms. HMAC and none are left
+// out on purpose: the tokens must be signed by the identity provider's
+// private key.
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// esCurveBits is the curve each ECDSA algorithm is defined for.
+var esCurveBits = map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}
+
+// LooksLikeJWT tells a JWT from an opaque bearer token.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Verify checks token and returns its claims.
+func (v *JWTVerifier) Verify(token string) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return JWTClaims{}, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return JWTClaims{}, fmt.Errorf("invalid header: %w", err)
+	}
+	hash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return JWTClaims{}, fmt.Errorf("unsupported alg %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return JWTClaims{}, fmt.Errorf("invalid signature: %w", err)
+	}
+	now := v.now()
+	key, err := v.keys.key(header.Kid, now)
+	if err != nil {
+		return JWTClaims{}, err
+	}
+	if err := verifySignature(header.Alg, hash, key, parts[0]+"."+parts[1], signature); err != nil {
+		return JWTClaims{}, err
+	}
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return JWTClaims{}, fmt.Errorf("invalid claims: %w", err)
+	}
+	return v.checkClaims(claims, now)
+}
+
+func (v *JWTVerifier) checkClaims(claims map[string]any, now time.Time) (JWTClaims, error) {
+	if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+		return JWTClaims{}, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if !hasAudience(claims["aud"], v.cfg.Audience) {
+		return JWTClaims{}, errors.New("token is not meant for this audience")
+	}
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return JWTClaims{}, errors.New("token has no exp")
+	}
+	if !now.Before(exp.Add(jwtLeeway)) {
+		return JWTClaims{}, errors.New("token has expired")
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(jwtLeeway).Before(nbf) {
+		return JWTClaims{}, errors.New("token is not valid yet")
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return JWTClaims{}, errors.New("token has no sub")
+	}
+	tenant, _ := claims[v.cfg.TenantClaim].(string)
+	scopes, err := scopeList(claims[v.cfg.ScopeClaim])
+	if err != nil {
+		return JWTClaims{}, err
+	}
+	return JWTClaims{Subject: subject, Tenant: tenant, Scopes: scopes}, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+func verifySignature(alg string, hash crypto.Hash, key crypto.PublicKey, signed string, signature []byte) error {
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	invalid := errors.New("invalid signature")
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s needs an RSA key", alg)
+		}
+		var err error
+		if alg[:2] == "RS" {
+			err = rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return invalid
+		}
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s needs an EC key", alg)
+		}
+		// JWS signatures are r and s, each padded to the size of the curve
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if ecKey.Curve.Params().BitSize != esCurveBits[alg] {
+			return fmt.Errorf("%s does not match the curve of the key", alg)
+		}
+		if len(signature) != 2*size {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return invalid
+		}
+	}
+	return nil
+}
+
+func hasAudience(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+func numericDate(value any) (time.Time, bool) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*float64(time.Second))), true
+}
+func scopeList(value any) ([]string, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.Fields(value), nil
+	case []any:
+		scopes := make([]string, 0, len(value))
+		for _, scope := range value {
+			s, ok := scope.(string)
+			if !ok {
+				return nil, errors.New("scopes must be strings")
+			}
+			scopes = append(scopes, s)
+		}
+		return scopes, nil
+	}
+	return nil, errors.New("scopes must be a string or a list")
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "task-queue"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": b64(key.N.Bytes()),
+		"e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	point, err := key.PublicKey.Bytes()
+	if err != nil {
+		panic(err)
+	}
+	size := (len(point) - 1) / 2
+	return map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(point[1 : 1+size]),
+		"y": b64(point[1+size:]),
+	}
+}
+
+// writeJWKS writes a key set to a temporary file and returns its path.
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) string {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "jwks.json")
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// signJWT signs claims with key, PKCS #1 v1.5 for RSA keys and P-256 for EC
+// keys, whatever alg the header claims.
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64(signature)
+}
+
+func TestJWTVerifier_Verify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := writeJWKS(t, "", rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+	v, err := NewJWTVerifier(JWTConfig{JWKS: jwks, Issuer: testIssuer, Audience: testAudience, TenantClaim: "org_id", ScopeClaim: "scope"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Now()
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{
+			"iss": testIssuer, "aud": testAudience, "sub": "billing-service",
+			"exp": now.Add(time.Hour).Unix(), "org_id": "acme", "scope": "openid jobs:write",
+		}
+		for k, value := range changes {
+			if value == nil {
+				delete(c, k)
+			} else {
+				c[k] = value
+			}
+		}
+		return c
+	}
+	cases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"rs256", signJWT(t, "RS256", "rsa-1", rsaKey, claims(nil)), true},
+		{"es256", signJWT(t, "ES256", "ec-1", ecKey, claims(nil)), true},
+		{"audience list", signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]any{"aud": []string{"other", testAudience}})), true},
+		{"expired within leeway", signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]any{"exp": now.Add(-30 * time.Second).Unix()})), true},
+		{"expired", signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]any{"exp": now.Add(-time.Hour).Unix()})), false},
+		{"no exp", signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]any{"exp": nil})), false},
+		{"not yet valid", signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]any{"nbf": now.Add(time.Hour).Unix()})), false},
+		{"wrong issuer", signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]any{"iss": "https://evil.example.com"})), false},
+		{"wrong audience", signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]any{"aud": "other"})), false},
+		{"no subject", signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]any{"sub": nil})), false},
+		{"signed by another key", signJWT(t, "RS256", "rsa-1", otherKey, claims(nil)), false},
+		{"unknown kid", signJWT(t, "RS256", "rsa-2", rsaKey, claims(nil)), false},
+		{"alg of another key type", signJWT(t, "ES256", "rsa-1", rsaKey, claims(nil)), false},
+		{"unsupported alg", signJWT(t, "HS256", "rsa-1", rsaKey, claims(nil)), false},
+		{"malformed", "not-a-jwt", false},
+	}
+	for _, tc := range cases {
+		_, err := v.Verify(tc.token)
+		if (err == nil) != tc.valid {
+			t.Fatalf("%s: got %v, want valid=%v", tc.name, err, tc.valid)
+		}
+	}
+
+	got, err := v.Verify(signJWT(t, "RS256", "rsa-1", rsaKey, claims(map[string]any{"scope": []string{"jobs:read", "admin:viewer"}})))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Subject != "billing-service" || got.Tenant != "acme" || !slices.Equal(got.Scopes, []string{"jobs:read", "admin:viewer"}) {
+		t.Fatalf("unexpected claims %+v", got)
+	}
+}
+
+func TestJWKS_ReloadsOnUnknownKid(t *testing.T) {
+	first, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeJWKS(t, "", rsaJWK("k1", first))
+	v, err := NewJWTVerifier(JWTConfig{JWKS: path, Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Now()
+	v.now = func() time.Time { return now }
+	token := signJWT(t, "ES256", "k2", second, map[string]any{
+		"iss": testIssuer, "aud": testAudience, "sub": "svc", "exp": now.Add(time.Hour).Unix(),
+	})
+
+	// the identity provider rotates its keys
+	writeJWKS(t, path, rsaJWK("k1", first), ecJWK("k2", second))
+	if _, err := v.Verify(token); err == nil {
+		t.Fatal("expected the key set to be reloaded no sooner than a minute after loading")
+	}
+	now = now.Add(jwksMinRefreshInterval)
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("expected the rotated key to be picked up, got %v", err)
+	}
+}
+
+func TestJWTConfigFromEnv(t *testing.T) {
+	t.Setenv("JWT_JWKS", "")
+	if _, enabled, err := JWTConfigFromEnv(); enabled || err != nil {
+		t.Fatalf("expected JWTs to be disabled, got %v, %v", enabled, err)
+	}
+	t.Setenv("JWT_JWKS", "https://idp.example.com/jwks.json")
+	if _, _, err := JWTConfigFromEnv(); err == nil {
+		t.Fatal("expected a missing issuer and audience to be rejected")
+	}
+	t.Setenv("JWT_ISSUER", testIssuer)
+	t.Setenv("JWT_AUDIENCE", testAudience)
+	cfg, enabled, err := JWTConfigFromEnv()
+	if err != nil || !enabled || cfg.TenantClaim != "org_id" || cfg.ScopeClaim != "scope" {
+		t.Fatalf("expected the default claims, got %+v, %v, %v", cfg, enabled, err)
+	}
+}
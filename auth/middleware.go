@@ -1,21 +1,54 @@
 package auth
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/franzego/distributed_task_queue/authutil"
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
 	"github.com/franzego/distributed_task_queue/internal"
 	"github.com/franzego/distributed_task_queue/internal/ratelimit"
 	"github.com/franzego/distributed_task_queue/models"
 	"github.com/gin-gonic/gin"
 )
 
+// maxRateLimitDeferral is how far ahead a key in defer mode may book its
+// rate limit before its requests are rejected after all.
+const maxRateLimitDeferral = 10 * time.Minute
+
+// signatureWindow is how far the timestamp of a signed request may be from
+// the server's clock.
+const signatureWindow = 5 * time.Minute
+
+// maxSignedBodySize caps the body read to check a request signature.
+const maxSignedBodySize = 1 << 20
+
+// minNonceLength keeps nonces long enough not to repeat by chance.
+const minNonceLength = 16
+
+// bootstrapPrincipal is the principal behind ADMIN_TOKEN. It is an owner, so
+// it can create the first admin principals, and is only accepted until an
+// active owner exists.
+const bootstrapPrincipal = "ADMIN_TOKEN"
+
 type Middleware struct {
 	q           *internal.Repository
 	rateLimiter *ratelimit.RateLimiter
+	// jwt is nil unless JWT_JWKS is set.
+	jwt    *JWTVerifier
+	nonces *NonceCache
 }
 
 func NewMiddlewareService(q *internal.Repository) *Middleware {
@@ -24,13 +57,29 @@ func NewMiddlewareService(q *internal.Repository) *Middleware {
 	}
 	// Initialize rate limiter with capacity of 100 requests and refill rate of 10 tokens per second
 	rateLimiter := ratelimit.NewRateLimiterService(100, 10)
-	return &Middleware{
+	m := &Middleware{
 		q:           q,
 		rateLimiter: rateLimiter,
+		// a nonce must be remembered until its timestamp is out of the
+		// window on both sides
+		nonces: NewNonceCache(2 * signatureWindow),
+	}
+	cfg, enabled, err := JWTConfigFromEnv()
+	if err == nil && enabled {
+		m.jwt, err = NewJWTVerifier(cfg)
 	}
+	if err != nil {
+		log.Printf("JWTs are not accepted: %v", err)
+	}
+	return m
 }
 
-// RateLimit middleware applies rate limiting based on api key
+// RateLimit middleware applies rate limiting based on api key, or on the
+// organization of the key, whose keys then share one bucket sized by the
+// organization's settings. Keys with defer_over_limit set get over-limit
+// POST /jobs requests accepted; the time their token comes due is passed on
+// to the handler as "defer_until". The token is given back if the handler
+// then rejects the job, e.g. as invalid or because the queue is overloaded.
 func (a *Middleware) RateLimit() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
@@ -42,92 +91,519 @@ func (a *Middleware) RateLimit() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		if !a.rateLimiter.Allow(keyID.(string)) {
-			c.JSON(http.StatusTooManyRequests, models.ErrorResponse{
-				Message: "Rate limit exceeded. Please try again later.",
-			})
-			c.Abort()
+		bucket := keyID.(string)
+		if org, ok := c.Get("organization"); ok {
+			org := org.(db.Organization)
+			bucket = "org:" + org.ID
+			a.rateLimiter.SetLimit(bucket, int(org.Burst.Int32), org.RatePerSecond.Float64)
+		}
+		if a.rateLimiter.Allow(bucket) {
+			c.Next()
 			return
 		}
-		c.Next()
+		if c.GetBool("defer_over_limit") && c.Request.Method == http.MethodPost && c.FullPath() == "/jobs" {
+			if wait, ok := a.rateLimiter.Reserve(bucket, maxRateLimitDeferral); ok {
+				c.Set("defer_until", time.Now().Add(wait))
+				c.Next()
+				if c.Writer.Status() >= http.StatusBadRequest {
+					a.rateLimiter.Cancel(bucket)
+				}
+				return
+			}
+		}
+		c.JSON(http.StatusTooManyRequests, models.ErrorResponse{
+			Message: "Rate limit exceeded. Please try again later.",
+		})
+		c.Abort()
 	}
 }
 
-// Admin Authentication
+// Admin Authentication. Viewers may only read; changes need an operator.
+// Changes, and requests denied for the principal's role, are recorded in the
+// audit log under the principal that made them.
 func (a *Middleware) AdminAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Message: "Missing Authorization Header",
-			})
-			c.Abort()
-			return
-		}
-		bearerToken := strings.Split(authHeader, " ")
-		if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Message: "Invalid Authorization format",
-			})
-			c.Abort()
-			return
+		if a.authenticateAdmin(c) {
+			c.Next()
 		}
-		adminToken := os.Getenv("ADMIN_TOKEN")
-		token := bearerToken[1]
-		if token != adminToken {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Message: "Invalid Access Token",
+		a.audit(c)
+	}
+}
+
+// RequireAdminRole rejects admins below role with 403. It runs after
+// AdminAuth.
+func (a *Middleware) RequireAdminRole(role internal.AdminRole) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !internal.AdminRole(c.GetString("admin_role")).Allows(role) {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Message: fmt.Sprintf("This needs the %s role", role),
 			})
 			c.Abort()
 			return
 		}
-		c.Set("is_admin", true)
 		c.Next()
 	}
 }
 
+// authenticateAdmin checks the bearer token against the admin tokens,
+// ADMIN_TOKEN if set, or as a JWT with an admin role scope, and that the principal's role allows the request
+// method. On failure it answers, aborts and returns false.
+func (a *Middleware) authenticateAdmin(c *gin.Context) bool {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: "Missing Authorization Header",
+		})
+		c.Abort()
+		return false
+	}
+	bearerToken := strings.Split(authHeader, " ")
+	if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: "Invalid Authorization format",
+		})
+		c.Abort()
+		return false
+	}
+	token := bearerToken[1]
+	principal, role, ok := a.adminPrincipal(token)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: "Invalid Access Token",
+		})
+		c.Abort()
+		return false
+	}
+	c.Set("is_admin", true)
+	c.Set("admin_principal", principal)
+	c.Set("admin_role", string(role))
+	if required := internal.RequiredAdminRole(c.Request.Method); !role.Allows(required) {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Message: fmt.Sprintf("This needs the %s role", required),
+		})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// adminPrincipal returns the name and role of the principal holding token.
+// A JWT's principal is its subject, and its role the highest admin:<role>
+// scope it carries.
+func (a *Middleware) adminPrincipal(token string) (string, internal.AdminRole, bool) {
+	if a.jwt != nil && LooksLikeJWT(token) {
+		claims, err := a.jwt.Verify(token)
+		if err != nil {
+			return "", "", false
+		}
+		role, ok := internal.AdminRoleFromScopes(claims.Scopes)
+		return "jwt:" + claims.Subject, role, ok
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+		owners, err := a.q.CountActiveAdminOwners(ctx)
+		if err != nil {
+			log.Printf("could not count admin owners: %v", err)
+			return "", "", false
+		}
+		if owners > 0 {
+			log.Print("ADMIN_TOKEN refused: an active owner exists, unset it")
+			return "", "", false
+		}
+		return bootstrapPrincipal, internal.RoleOwner, true
+	}
+	// the lookup is by hash, so it does not leak the token through timing
+	row, err := a.q.AuthenticateAdminToken(ctx, HashApiKeys(token))
+	if err != nil {
+		return "", "", false
+	}
+	if row.ExpiresAt.Valid && row.ExpiresAt.Time.Before(time.Now()) {
+		return "", "", false
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.q.TouchAdminToken(ctx, row.TokenID); err != nil {
+			log.Printf("could not update last use of admin token %s: %v", row.TokenID, err)
+		}
+	}()
+	return row.Name, internal.AdminRole(row.Role), true
+}
+
+// audit records an admin request that may have changed something, or was
+// denied for the principal's role, once it has been handled. Requests that
+// did not authenticate have no principal and are not recorded.
+func (a *Middleware) audit(c *gin.Context) {
+	if c.GetString("admin_principal") == "" {
+		return
+	}
+	if internal.RequiredAdminRole(c.Request.Method) == internal.RoleViewer && c.Writer.Status() != http.StatusForbidden {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := a.q.RecordAdminAction(ctx, db.RecordAdminActionParams{
+		Principal: c.GetString("admin_principal"),
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Status:    int32(c.Writer.Status()),
+	})
+	if err != nil {
+		log.Printf("could not record admin action %s %s of %s: %v", c.Request.Method, c.Request.URL.Path, c.GetString("admin_principal"), err)
+	}
+}
+
 func (a *Middleware) AuthMiddlerWare() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		apiKey := c.GetHeader("X-API-Key")
+		if a.authenticateKey(c) {
+			c.Next()
+		}
+	}
+}
 
-		if apiKey == "" {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Message: "Missing API key",
+// authenticateKey checks the X-API-Key header, or in its place a request
+// signature, a JWT bearer token or a verified client certificate, and puts the
+// key, its organization and its permissions in the context. On failure it
+// answers, aborts and returns false.
+func (a *Middleware) authenticateKey(c *gin.Context) bool {
+	apiKey := c.GetHeader("X-API-Key")
+	if apiKey == "" && c.GetHeader(authutil.HeaderSignature) != "" {
+		return a.authenticateSignature(c)
+	}
+	if token, ok := bearerToken(c); apiKey == "" && ok && a.jwt != nil && LooksLikeJWT(token) {
+		return a.authenticateJWT(c, token)
+	}
+	if apiKey == "" && c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+		return a.authenticateCert(c)
+	}
+
+	if apiKey == "" {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: "Missing API key",
+		})
+		c.Abort()
+		return false
+	}
+	// hash the apikey
+	hash := HashApiKeys(apiKey)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	hashKey, err := a.q.GetAPIKeyByHash(ctx, hash)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: "Invalid Api key",
+			Error:   err.Error(),
+		})
+		c.Abort()
+		return false
+	}
+	return a.acceptKey(c, hashKey)
+}
+
+// authenticateSignature checks a request signed with the key's signing
+// secret, see authutil.StringToSign. The timestamp must be within
+// signatureWindow and the nonce unused, so a captured request cannot be
+// replayed. The body is put back for the handler.
+func (a *Middleware) authenticateSignature(c *gin.Context) bool {
+	keyID := c.GetHeader(authutil.HeaderKeyID)
+	timestamp := c.GetHeader(authutil.HeaderTimestamp)
+	nonce := c.GetHeader(authutil.HeaderNonce)
+	signature, err := hex.DecodeString(c.GetHeader(authutil.HeaderSignature))
+	if keyID == "" || timestamp == "" || err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: fmt.Sprintf("Signed requests need the %s and %s headers and a hex %s", authutil.HeaderKeyID, authutil.HeaderTimestamp, authutil.HeaderSignature),
+		})
+		c.Abort()
+		return false
+	}
+	if len(nonce) < minNonceLength {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: fmt.Sprintf("%s must be at least %d characters", authutil.HeaderNonce, minNonceLength),
+		})
+		c.Abort()
+		return false
+	}
+	now := time.Now()
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Sub(time.Unix(seconds, 0)).Abs() > signatureWindow {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: fmt.Sprintf("%s must be the unix time within %s of the server's clock", authutil.HeaderTimestamp, signatureWindow),
+		})
+		c.Abort()
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBodySize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Could not read the request body",
+			Error:   err.Error(),
+		})
+		c.Abort()
+		return false
+	}
+	if len(body) > maxSignedBodySize {
+		c.JSON(http.StatusRequestEntityTooLarge, models.ErrorResponse{
+			Message: fmt.Sprintf("Signed request bodies may be at most %d bytes", maxSignedBodySize),
+		})
+		c.Abort()
+		return false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	key, err := a.q.GetAPIKey(ctx, keyID)
+	if err != nil && !errors.Is(err, internal.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not load the Api key",
+			Error:   err.Error(),
+		})
+		c.Abort()
+		return false
+	}
+	// unknown keys, keys without a secret and wrong signatures look alike
+	valid := err == nil && key.IsActive && key.SigningSecret.Valid
+	if valid {
+		stringToSign := authutil.StringToSign(c.Request.Method, c.Request.URL.RequestURI(), timestamp, nonce, body)
+		expected, _ := hex.DecodeString(authutil.SignRequest(key.SigningSecret.String, stringToSign))
+		valid = hmac.Equal(signature, expected)
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: "Invalid request signature",
+		})
+		c.Abort()
+		return false
+	}
+	// only checked once the signature holds, so nobody else can burn the
+	// key's nonces
+	if !a.nonces.Use(key.ID, nonce, now) {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: "Request was already used; sign every request with a new nonce",
+		})
+		c.Abort()
+		return false
+	}
+	return a.acceptKey(c, key)
+}
+
+// authenticateCert finds the key whose client identities include one of the
+// client certificate's names. The certificate was verified against the client
+// CA bundle during the TLS handshake.
+func (a *Middleware) authenticateCert(c *gin.Context) bool {
+	cert := c.Request.TLS.VerifiedChains[0][0]
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	keys, err := a.q.GetAPIKeysByClientIdentities(ctx, internal.CertificateIdentities(cert))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not look up the client certificate",
+			Error:   err.Error(),
+		})
+		c.Abort()
+		return false
+	}
+	switch len(keys) {
+	case 0:
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: "Client certificate does not belong to an Api key",
+		})
+		c.Abort()
+		return false
+	case 1:
+		return a.acceptKey(c, keys[0])
+	}
+	// names of one certificate mapped to different keys; picking one would
+	// depend on which the certificate lists first
+	c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+		Message: "Client certificate belongs to more than one Api key",
+	})
+	c.Abort()
+	return false
+}
+
+// acceptKey checks that the authenticated key has not expired and is used
+// from one of its allowed networks, and puts it in the context.
+func (a *Middleware) acceptKey(c *gin.Context, hashKey db.ApiKey) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if hashKey.ExpiresAt.Valid && hashKey.ExpiresAt.Time.Before(time.Now()) {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: "Api Key Has Expired",
+		})
+		c.Abort()
+		return false
+	}
+	// ClientIP only follows X-Forwarded-For through TRUSTED_PROXIES
+	if ip := c.ClientIP(); !internal.CIDRsAllow(hashKey.AllowedCidrs, ip) {
+		log.Printf("api key %s used from %s, outside its allowed networks, for %s %s", hashKey.ID, ip, c.Request.Method, c.Request.URL.Path)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := a.q.RecordAPIKeyIPDenial(ctx, hashKey.ID); err != nil {
+				log.Printf("could not count ip denial of api key %s: %v", hashKey.ID, err)
+			}
+		}()
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Message: "Api key may not be used from this network",
+		})
+		c.Abort()
+		return false
+	}
+	deferOverLimit := hashKey.DeferOverLimit
+	if hashKey.OrganizationID.Valid {
+		org, err := a.q.GetOrganization(ctx, hashKey.OrganizationID.String)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+				Message: "Could not load the organization of the Api key",
+				Error:   err.Error(),
 			})
 			c.Abort()
-			return
+			return false
 		}
-		// hash the apikey
-		hash := HashApiKeys(apiKey)
+		c.Set("organization", org)
+		c.Set("organization_id", org.ID)
+		deferOverLimit = deferOverLimit || org.DeferOverLimit
+	}
+	c.Set("api_key_id", hashKey.ID)
+	c.Set("api_key_name", hashKey.Name)
+	c.Set("defer_over_limit", deferOverLimit)
+	c.Set("api_key_permissions", internal.KeyPermissions{
+		Scopes:        hashKey.Scopes,
+		AllowedTypes:  hashKey.AllowedTypes,
+		AllowedQueues: hashKey.AllowedQueues,
+		AllowedCIDRs:  hashKey.AllowedCidrs,
+	})
+	go func() {
+		// the lookup's context is cancelled as soon as authentication is done
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		hashKey, err := a.q.GetAPIKeyByHash(ctx, hash)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Message: "Invalid Api key",
-				Error:   err.Error(),
+		if err := a.q.UpdateLastUsed(ctx, hashKey.ID); err != nil {
+			log.Printf("could not update last use of api key %s: %v", hashKey.ID, err)
+		}
+	}()
+	return true
+}
+
+// authenticateJWT stands in for authenticateKey when the caller presents a
+// JWT. The token's tenant claim must name an organization, which the caller
+// then acts for like one of its keys, with the scopes of the token that this
+// server knows. Jobs it submits belong to the organization and to no key.
+func (a *Middleware) authenticateJWT(c *gin.Context, token string) bool {
+	claims, err := a.jwt.Verify(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Message: "Invalid token",
+			Error:   err.Error(),
+		})
+		c.Abort()
+		return false
+	}
+	if claims.Tenant == "" {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Message: "Token has no tenant",
+		})
+		c.Abort()
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	org, err := a.q.GetOrganization(ctx, claims.Tenant)
+	if errors.Is(err, internal.ErrOrganizationNotFound) {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Message: "Token's tenant is not a known organization",
+		})
+		c.Abort()
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not load the organization of the token",
+			Error:   err.Error(),
+		})
+		c.Abort()
+		return false
+	}
+	c.Set("organization", org)
+	c.Set("organization_id", org.ID)
+	c.Set("api_key_id", "")
+	c.Set("jwt_subject", claims.Subject)
+	c.Set("defer_over_limit", org.DeferOverLimit)
+	c.Set("api_key_permissions", internal.KeyPermissions{
+		Scopes: internal.KnownScopes(claims.Scopes),
+	})
+	return true
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(c *gin.Context) (string, bool) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+// RequireScope rejects requests whose API key lacks scope with 403. It runs
+// after AuthMiddlerWare.
+func (a *Middleware) RequireScope(scope internal.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permissions, _ := c.Get("api_key_permissions")
+		if p, ok := permissions.(internal.KeyPermissions); !ok || !p.Has(scope) {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Message: fmt.Sprintf("Api key lacks the %s scope", scope),
 			})
 			c.Abort()
 			return
 		}
-		if hashKey.ExpiresAt.Valid && hashKey.ExpiresAt.Time.Before(time.Now()) {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Message: "Api Key Has Expired",
+		c.Next()
+	}
+}
+
+// KeyAdminAuth lets through admins, or an API key or JWT of an organization
+// that has the admin:keys scope. Such a caller may only manage the keys of
+// its organization, which is passed on as "key_admin_organization_id".
+func (a *Middleware) KeyAdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" && !a.tenantJWT(c) {
+			if a.authenticateAdmin(c) {
+				c.Next()
+			}
+			a.audit(c)
+			return
+		}
+		if !a.authenticateKey(c) {
+			return
+		}
+		permissions := c.MustGet("api_key_permissions").(internal.KeyPermissions)
+		org := c.GetString("organization_id")
+		if !permissions.Has(internal.ScopeAdminKeys) || org == "" {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Message: "Managing keys needs an admin token, or an organization's Api key or token with the admin:keys scope",
 			})
 			c.Abort()
 			return
 		}
-		c.Set("api_key_id", hashKey.ID)
-		c.Set("api_key_name", hashKey.Name)
-		go func() {
-			if err := a.q.UpdateLastUsed(ctx, hashKey.ID); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "failed to update time",
-				})
-			}
-		}()
-
+		c.Set("key_admin_organization_id", org)
+		if subject := c.GetString("jwt_subject"); subject != "" {
+			c.Set("admin_principal", "jwt:"+subject)
+		} else {
+			c.Set("admin_principal", "api_key:"+c.GetString("api_key_id"))
+		}
 		c.Next()
+		a.audit(c)
+	}
+}
 
+// tenantJWT reports whether the bearer token is a JWT without an admin role,
+// which authenticates like an API key of its tenant.
+func (a *Middleware) tenantJWT(c *gin.Context) bool {
+	token, ok := bearerToken(c)
+	if !ok || a.jwt == nil || !LooksLikeJWT(token) {
+		return false
+	}
+	claims, err := a.jwt.Verify(token)
+	if err != nil {
+		return false
 	}
+	_, admin := internal.AdminRoleFromScopes(claims.Scopes)
+	return !admin
 }
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// NonceCache remembers the nonces of signed requests for as long as their
+// timestamps are accepted, so each signed request is only accepted once. It
+// is kept in memory, like the rate limiter, so it covers one server.
+type NonceCache struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// Use records the nonce of a key and reports false if it was already used.
+func (n *NonceCache) Use(keyID, nonce string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if now.Sub(n.lastPrune) >= n.ttl {
+		for k, expires := range n.seen {
+			if !now.Before(expires) {
+				delete(n.seen, k)
+			}
+		}
+		n.lastPrune = now
+	}
+	k := keyID + "\x00" + nonce
+	if expires, ok := n.seen[k]; ok && now.Before(expires) {
+		return false
+	}
+	n.seen[k] = now.Add(n.ttl)
+	return true
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNonceCache_Use(t *testing.T) {
+	cache := NewNonceCache(10 * time.Minute)
+	now := time.Now()
+	if !cache.Use("key-1", "3f9d2c7a1b8e4f60", now) {
+		t.Fatal("expected a new nonce to be accepted")
+	}
+	if cache.Use("key-1", "3f9d2c7a1b8e4f60", now.Add(time.Minute)) {
+		t.Fatal("expected a replayed nonce to be rejected")
+	}
+	if !cache.Use("key-2", "3f9d2c7a1b8e4f60", now) {
+		t.Fatal("expected nonces to be tracked per key")
+	}
+	later := now.Add(10 * time.Minute)
+	if !cache.Use("key-1", "3f9d2c7a1b8e4f60", later) {
+		t.Fatal("expected the nonce to be forgotten once its timestamp is out of the window")
+	}
+	if len(cache.seen) != 1 {
+		t.Fatalf("expected expired nonces to be pruned, %d left", len(cache.seen))
+	}
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSReloadInterval is how often the certificate files are checked for
+// changes.
+const TLSReloadInterval = 10 * time.Second
+
+// TLSReloader serves the server certificate and the client CA bundle from
+// files, and loads them again when they change, so they can be renewed
+// without a restart.
+type TLSReloader struct {
+	certFile string
+	keyFile  string
+	// caFile is empty when client certificates are not asked for.
+	caFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	// stamp is the size and modification time of the files last loaded, or
+	// last failed to load, so a broken file is only reported once.
+	stamp string
+}
+
+// TLSReloaderFromEnv reads TLS_CERT_FILE, TLS_KEY_FILE and
+// TLS_CLIENT_CA_FILE. It returns nil when TLS_CERT_FILE is unset, in which
+// case the server speaks plain HTTP and leaves TLS to a proxy.
+func TLSReloaderFromEnv() (*TLSReloader, error) {
+	certFile := os.Getenv("TLS_CERT_FILE")
+	keyFile := os.Getenv("TLS_KEY_FILE")
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if certFile == "" {
+		if keyFile != "" || caFile != "" {
+			return nil, errors.New("TLS_KEY_FILE and TLS_CLIENT_CA_FILE need TLS_CERT_FILE")
+		}
+		return nil, nil
+	}
+	if keyFile == "" {
+		return nil, errors.New("TLS_KEY_FILE must be set with TLS_CERT_FILE")
+	}
+	return NewTLSReloader(certFile, keyFile, caFile)
+}
+
+func NewTLSReloader(certFile, keyFile, caFile string) (*TLSReloader, error) {
+	r := &TLSReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the files again if they changed since they were last loaded,
+// and reports whether it did. On error the files loaded before stay in use.
+func (r *TLSReloader) Reload() (bool, error) {
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := stamp == r.stamp
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	cert, clientCAs, err := r.load()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stamp = stamp
+	if err != nil {
+		return false, err
+	}
+	r.cert = cert
+	r.clientCAs = clientCAs
+	return true, nil
+}
+func (r *TLSReloader) fileStamp() (string, error) {
+	stamp := ""
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%d/%d;", info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp, nil
+}
+func (r *TLSReloader) load() (*tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load server certificate: %w", err)
+	}
+	if r.caFile == "" {
+		return &cert, nil, nil
+	}
+	bundle, err := os.ReadFile(r.caFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read client CA bundle: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(bundle) {
+		return nil, nil, fmt.Errorf("client CA bundle %s holds no certificates", r.caFile)
+	}
+	return &cert, clientCAs, nil
+}
+
+// Watch calls Reload every interval until ctx is done.
+func (r *TLSReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				log.Printf("could not reload TLS files, keeping the ones loaded before: %v", err)
+			} else if reloaded {
+				log.Printf("reloaded TLS certificate and client CA bundle")
+			}
+		}
+	}
+}
+
+// TLSConfig returns a config that picks up reloaded files on every new
+// connection. Client certificates are verified against the CA bundle when one
+// is given, but not required, so callers may still use X-API-Key.
+func (r *TLSReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if r.clientCAs != nil {
+				config.ClientCAs = r.clientCAs
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return config, nil
+		},
+	}
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of a leaf signed by ca.
+func (ca testCA) issue(t *testing.T, serial int64, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile writes data and moves its modification time on, so a rewrite
+// within the file system's timestamp resolution is still noticed.
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem")
+	serverCA, clientCA, otherCA := newTestCA(t, "server ca"), newTestCA(t, "client ca"), newTestCA(t, "other ca")
+	modTime := time.Now()
+	serverCert, serverKey := serverCA.issue(t, 10, "queue.internal", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, serverCert, modTime)
+	writeFile(t, keyFile, serverKey, modTime)
+	writeFile(t, caFile, clientCA.pem, modTime)
+
+	reloader, err := NewTLSReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.TLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.VerifiedChains) == 0 {
+			io.WriteString(w, "anonymous")
+			return
+		}
+		io.WriteString(w, r.TLS.VerifiedChains[0][0].Subject.CommonName)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	clientCert, clientKey := clientCA.issue(t, 20, "billing-service", x509.ExtKeyUsageClientAuth)
+	pair, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	// get connects anew each time, so every call sees the files loaded then
+	get := func(withCert bool) (string, *x509.Certificate, error) {
+		config := &tls.Config{RootCAs: roots, ServerName: "queue.internal"}
+		if withCert {
+			config.Certificates = []tls.Certificate{pair}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+		resp, err := client.Get("https://" + listener.Addr().String())
+		if err != nil {
+			return "", nil, err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.TLS.PeerCertificates[0], nil
+	}
+
+	if who, _, err := get(true); err != nil || who != "billing-service" {
+		t.Fatalf("expected the client certificate to be verified, got %q, %v", who, err)
+	}
+	if who, _, err := get(false); err != nil || who != "anonymous" {
+		t.Fatalf("expected callers without a certificate to be let through, got %q, %v", who, err)
+	}
+
+	// renew the server certificate and trust another client CA
+	modTime = modTime.Add(time.Minute)
+	serverCert, serverKey = serverCA.issue(t, 11, "queue.internal", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, serverCert, modTime)
+	writeFile(t, keyFile, serverKey, modTime)
+	writeFile(t, caFile, otherCA.pem, modTime)
+	if reloaded, err := reloader.Reload(); err != nil || !reloaded {
+		t.Fatalf("expected the files to be reloaded, got %v, %v", reloaded, err)
+	}
+	// the client leaves out a certificate the server's CAs did not issue
+	if who, _, err := get(true); err == nil && who == "billing-service" {
+		t.Fatal("expected the client certificate to be rejected once its CA is no longer trusted")
+	}
+	_, served, err := get(false)
+	if err != nil || served.SerialNumber.Int64() != 11 {
+		t.Fatalf("expected the renewed server certificate, got %v", err)
+	}
+
+	// a broken file keeps the files loaded before in use
+	modTime = modTime.Add(time.Minute)
+	writeFile(t, caFile, []byte("not a certificate"), modTime)
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("expected a broken CA bundle to be reported")
+	}
+	if reloaded, err := reloader.Reload(); err != nil || reloaded {
+		t.Fatalf("expected an unchanged broken file to be skipped, got %v, %v", reloaded, err)
+	}
+	if _, _, err := get(false); err != nil {
+		t.Fatalf("expected the server to keep serving, got %v", err)
+	}
+}
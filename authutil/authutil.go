@@ -1,10 +1,12 @@
 package authutil
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strings"
 )
 
 // KeyGenerator generates a random API key with the provided prefix.
@@ -23,3 +25,44 @@ func HashApiKeys(key string) string {
 	hash := sha256.Sum256([]byte(key))
 	return hex.EncodeToString(hash[:])
 }
+
+// keyPrefixLength is how many random characters KeyPrefix keeps.
+const keyPrefixLength = 8
+
+// KeyPrefix returns the prefix of a key made by KeyGenerator and the first
+// few of its random characters. That is enough to tell keys apart but useless
+// for authenticating.
+func KeyPrefix(key string) string {
+	random := strings.LastIndex(key, "-") + 1
+	return key[:min(len(key), random+keyPrefixLength)]
+}
+
+// PrefixOf returns the prefix KeyGenerator was given for the key that
+// KeyPrefix returned keyPrefix for.
+func PrefixOf(keyPrefix string) string {
+	return keyPrefix[:max(strings.LastIndex(keyPrefix, "-"), 0)]
+}
+
+// Headers of a request signed with SignRequest instead of carrying the key.
+const (
+	HeaderKeyID     = "X-Key-Id"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature"
+)
+
+// StringToSign is what a request signature covers: the method, the path with
+// its query, the unix timestamp and nonce sent in the headers, and the
+// SHA-256 of the body, one per line.
+func StringToSign(method, requestURI, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{method, requestURI, timestamp, nonce, hex.EncodeToString(bodyHash[:])}, "\n")
+}
+
+// SignRequest returns the hex HMAC-SHA256 of stringToSign under a key's
+// signing secret.
+func SignRequest(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,40 @@
+package authutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	key, err := KeyGenerator("app")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	prefix := KeyPrefix(key)
+	if len(prefix) != len("app-")+keyPrefixLength || !strings.HasPrefix(key, prefix) {
+		t.Fatalf("expected app- and %d characters of %s, got %s", keyPrefixLength, key, prefix)
+	}
+	if got := PrefixOf(prefix); got != "app" {
+		t.Fatalf("expected prefix app, got %q", got)
+	}
+	if got := KeyPrefix("my-app-abc"); got != "my-app-abc" {
+		t.Fatalf("expected short keys to be kept whole, got %s", got)
+	}
+	if got := PrefixOf(""); got != "" {
+		t.Fatalf("expected no prefix for keys without one, got %q", got)
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	body := []byte(`{"type":"send_email"}`)
+	stringToSign := StringToSign("POST", "/jobs?dry_run=1", "1700000000", "3f9d2c7a1b8e4f60", body)
+	// computed independently, so clients in other languages can check theirs
+	want := "db2b811af9ba94bb6ac8ac18e953e6e65f4a860f77270316a4d60a1962d090e3"
+	if got := SignRequest("sig-secret", stringToSign); got != want {
+		t.Fatalf("expected signature %s, got %s", want, got)
+	}
+	tampered := StringToSign("POST", "/jobs?dry_run=1", "1700000000", "3f9d2c7a1b8e4f60", []byte(`{"type":"resize_image"}`))
+	if SignRequest("sig-secret", tampered) == want {
+		t.Fatal("expected a different body to change the signature")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/franzego/distributed_task_queue/auth"
@@ -34,6 +35,15 @@ func main() {
 	handler := internal.NewHandlerService(service)
 
 	r := gin.Default()
+	// X-Forwarded-For is only believed from these proxies, so clients cannot
+	// choose the IP that API key allowlists are checked against
+	trustedProxies, err := internal.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
 	// This is a public path
 	r.GET("/health", func(c *gin.Context) {
 		// Return JSON response
@@ -46,8 +56,69 @@ func main() {
 	admin := r.Group("/admin")
 	admin.Use(middlewareAuth.AdminAuth())
 	{
-		admin.POST("/api-keys", handler.PostAdminApiKey)
-		admin.GET("/api-keys", handler.GetApiKeys)
+		admin.PUT("/api-keys/:id/rate-limit", handler.PutApiKeyRateLimitMode)
+		admin.PUT("/api-keys/:id/organization", handler.PutApiKeyOrganization)
+		admin.GET("/organizations", handler.GetOrganizations)
+		admin.PUT("/organizations/:id", handler.PutOrganization)
+		admin.DELETE("/organizations/:id", handler.DeleteOrganization)
+		admin.GET("/stats", handler.GetStats)
+		admin.GET("/jobs", handler.GetAdminJobs)
+		admin.GET("/jobs/:id", handler.GetAdminJob)
+		admin.POST("/jobs/:id/cancel", handler.PostAdminCancelJob)
+		admin.GET("/job-types/limits", handler.GetJobTypeLimits)
+		admin.PUT("/job-types/:type/limits", handler.PutJobTypeLimit)
+		admin.DELETE("/job-types/:type/limits", handler.DeleteJobTypeLimit)
+		admin.GET("/breakers", handler.GetCircuitBreakers)
+		admin.PUT("/breakers/:type", handler.PutCircuitBreaker)
+		admin.POST("/breakers/:type/reset", handler.PostResetCircuitBreaker)
+		admin.GET("/quarantine", handler.GetQuarantinedJobs)
+		admin.POST("/quarantine/:id/release", handler.PostReleaseQuarantinedJob)
+		admin.GET("/tenants", handler.GetTenants)
+		admin.PUT("/tenants/:id/weight", handler.PutTenantWeight)
+		admin.GET("/backlog-limits", handler.GetBacklogLimits)
+		admin.PUT("/backlog-limits", handler.PutBacklogLimit)
+		admin.DELETE("/backlog-limits", handler.DeleteBacklogLimit)
+		admin.GET("/windows", handler.GetExecutionWindows)
+		admin.POST("/windows", handler.PostExecutionWindow)
+		admin.DELETE("/windows/:id", handler.DeleteExecutionWindow)
+		admin.GET("/workers", handler.GetWorkers)
+		admin.GET("/rollouts", handler.GetRollouts)
+		admin.PUT("/rollouts/:type", handler.PutRollout)
+		admin.POST("/rollouts/:type/rollback", handler.PostRollbackRollout)
+		admin.DELETE("/rollouts/:type", handler.DeleteRollout)
+	}
+
+	// Managing admins themselves is left to owners
+	owners := admin.Group("")
+	owners.Use(middlewareAuth.RequireAdminRole(internal.RoleOwner))
+	{
+		owners.GET("/principals", handler.GetAdminPrincipals)
+		owners.POST("/principals", handler.PostAdminPrincipal)
+		owners.PATCH("/principals/:id", handler.PatchAdminPrincipal)
+		owners.GET("/principals/:id/tokens", handler.GetAdminTokens)
+		owners.POST("/principals/:id/tokens", handler.PostAdminToken)
+		owners.DELETE("/principals/:id/tokens/:token_id", handler.DeleteAdminToken)
+		owners.GET("/audit", handler.GetAdminAudit)
+	}
+
+	// Keys can be managed with ADMIN_TOKEN, or by an organization's own key
+	// with the admin:keys scope
+	keys := r.Group("/admin/api-keys")
+	keys.Use(middlewareAuth.KeyAdminAuth())
+	{
+		keys.POST("", handler.PostAdminApiKey)
+		keys.GET("", handler.GetApiKeys)
+		keys.PATCH("/:id", handler.PatchApiKey)
+		keys.PUT("/:id/expiry", handler.PutApiKeyExpiry)
+		keys.PUT("/:id/scopes", handler.PutApiKeyScopes)
+		keys.PUT("/:id/allowed-cidrs", handler.PutApiKeyAllowedCIDRs)
+		keys.POST("/:id/revoke", handler.PostRevokeApiKey)
+		keys.POST("/:id/rotate", handler.PostRotateApiKey)
+		keys.POST("/:id/signing-secret", handler.PostApiKeySigningSecret)
+		keys.DELETE("/:id/signing-secret", handler.DeleteApiKeySigningSecret)
+		keys.GET("/:id/client-identities", handler.GetApiKeyClientIdentities)
+		keys.POST("/:id/client-identities", handler.PostApiKeyClientIdentity)
+		keys.DELETE("/:id/client-identities/:identity_id", handler.DeleteApiKeyClientIdentity)
 	}
 
 	// This is a protected path for jobs endpint
@@ -55,9 +126,31 @@ func main() {
 	api.Use(middlewareAuth.AuthMiddlerWare())
 	api.Use(middlewareAuth.RateLimit())
 	{
-		api.POST("/jobs", handler.PostJob)
-		api.GET("/jobs/:id", handler.GetStatus)
+		api.POST("/jobs", middlewareAuth.RequireScope(internal.ScopeJobsWrite), handler.PostJob)
+		api.GET("/jobs", middlewareAuth.RequireScope(internal.ScopeJobsRead), handler.GetJobs)
+		api.GET("/jobs/:id", middlewareAuth.RequireScope(internal.ScopeJobsRead), handler.GetStatus)
+		api.POST("/jobs/:id/cancel", middlewareAuth.RequireScope(internal.ScopeJobsCancel), handler.PostCancelJob)
 	}
 
-	r.Run()
+	// With TLS_CERT_FILE set the server terminates TLS itself, so callers can
+	// authenticate with client certificates
+	reloader, err := auth.TLSReloaderFromEnv()
+	if err != nil {
+		log.Fatalf("invalid TLS settings: %v", err)
+	}
+	if reloader == nil {
+		r.Run()
+		return
+	}
+	go reloader.Watch(context.Background(), auth.TLSReloadInterval)
+	addr := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   r,
+		TLSConfig: reloader.TLSConfig(),
+	}
+	log.Fatal(server.ListenAndServeTLS("", ""))
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
+	"os"
 	"time"
 
 	"github.com/franzego/distributed_task_queue/internal"
@@ -22,8 +24,18 @@ func main() {
 		return
 	}
 	repository := internal.NewRepositoryService(dbConn)
-	emailHandler := handler.NewEmailHandlerService()
-	worker := worker.NewWorkerService(repository, emailHandler)
+	emailHandler := handler.NewEmailHandlerService().WithIdempotency(repository)
+	worker, err := worker.NewWorkerService(repository, emailHandler)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if addr := os.Getenv("WORKER_METRICS_ADDR"); addr != "" {
+		go func() {
+			if err := http.ListenAndServe(addr, worker.MetricsHandler()); err != nil {
+				log.Printf("Metrics server stopped: %v", err)
+			}
+		}()
+	}
 	log.Println("Starting worker...")
 	if err := worker.WorkerFunction(); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_jobs_lease_expires;
+ALTER TABLE jobs DROP CONSTRAINT IF EXISTS jobs_status_check;
+ALTER TABLE jobs
+    DROP COLUMN IF EXISTS lease_expires_at,
+    DROP COLUMN IF EXISTS lease_token;
@@ -0,0 +1,10 @@
+ALTER TABLE jobs
+    ADD COLUMN lease_token TEXT,
+    ADD COLUMN lease_expires_at TIMESTAMPTZ;
+
+ALTER TABLE jobs
+    ADD CONSTRAINT jobs_status_check
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed'));
+
+CREATE INDEX idx_jobs_lease_expires ON jobs(lease_expires_at)
+    WHERE status = 'processing';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_job_side_effects_job;
+DROP TABLE IF EXISTS job_side_effects;
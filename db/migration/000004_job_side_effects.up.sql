@@ -0,0 +1,8 @@
+CREATE TABLE job_side_effects (
+    key TEXT PRIMARY KEY,
+    job_id TEXT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    outcome JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_job_side_effects_job ON job_side_effects(job_id);
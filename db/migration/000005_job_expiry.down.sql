@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_jobs_expires;
+
+ALTER TABLE jobs DROP CONSTRAINT jobs_status_check;
+ALTER TABLE jobs
+    ADD CONSTRAINT jobs_status_check
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed'));
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,9 @@
+ALTER TABLE jobs ADD COLUMN expires_at TIMESTAMPTZ;
+
+ALTER TABLE jobs DROP CONSTRAINT jobs_status_check;
+ALTER TABLE jobs
+    ADD CONSTRAINT jobs_status_check
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'expired'));
+
+CREATE INDEX idx_jobs_expires ON jobs(expires_at)
+    WHERE status IN ('pending', 'processing') AND expires_at IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_jobs_group;
+ALTER TABLE jobs
+    DROP COLUMN IF EXISTS enqueue_seq,
+    DROP COLUMN IF EXISTS group_key;
@@ -0,0 +1,6 @@
+ALTER TABLE jobs
+    ADD COLUMN group_key TEXT,
+    ADD COLUMN enqueue_seq BIGINT GENERATED ALWAYS AS IDENTITY;
+
+CREATE INDEX idx_jobs_group ON jobs(group_key, enqueue_seq)
+    WHERE group_key IS NOT NULL AND status IN ('pending', 'processing');
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_jobs_coalesce_pending;
+ALTER TABLE jobs DROP CONSTRAINT IF EXISTS jobs_coalesce_mode_check;
+ALTER TABLE jobs
+    DROP COLUMN IF EXISTS coalesce_until,
+    DROP COLUMN IF EXISTS coalesce_mode,
+    DROP COLUMN IF EXISTS coalesce_key;
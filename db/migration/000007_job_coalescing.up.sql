@@ -0,0 +1,13 @@
+ALTER TABLE jobs
+    ADD COLUMN coalesce_key TEXT,
+    ADD COLUMN coalesce_mode TEXT,
+    ADD COLUMN coalesce_until TIMESTAMPTZ;
+
+ALTER TABLE jobs
+    ADD CONSTRAINT jobs_coalesce_mode_check
+    CHECK (coalesce_mode IN ('replace', 'merge'));
+
+-- at most one pending job per type and coalesce key; later submissions are
+-- folded into it
+CREATE UNIQUE INDEX idx_jobs_coalesce_pending ON jobs(type, coalesce_key)
+    WHERE status = 'pending' AND coalesce_key IS NOT NULL;
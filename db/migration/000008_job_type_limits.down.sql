@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_jobs_type_processing;
+DROP TABLE IF EXISTS job_type_limits;
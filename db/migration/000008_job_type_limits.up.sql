@@ -0,0 +1,18 @@
+CREATE TABLE job_type_limits (
+    job_type TEXT PRIMARY KEY,
+    max_concurrent INTEGER,
+    rate_per_second DOUBLE PRECISION,
+    burst INTEGER NOT NULL DEFAULT 1,
+    tokens DOUBLE PRECISION NOT NULL DEFAULT 0,
+    refilled_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT job_type_limits_max_concurrent_check
+        CHECK (max_concurrent IS NULL OR max_concurrent > 0),
+    CONSTRAINT job_type_limits_rate_check
+        CHECK (rate_per_second IS NULL OR rate_per_second > 0),
+    CONSTRAINT job_type_limits_burst_check
+        CHECK (burst > 0)
+);
+
+CREATE INDEX idx_jobs_type_processing ON jobs(type)
+    WHERE status = 'processing';
@@ -0,0 +1,19 @@
+CREATE TABLE circuit_breakers (
+    job_type TEXT PRIMARY KEY,
+    state TEXT NOT NULL DEFAULT 'closed',
+    failures INTEGER NOT NULL DEFAULT 0,
+    successes INTEGER NOT NULL DEFAULT 0,
+    window_started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    opened_at TIMESTAMPTZ,
+    open_until TIMESTAMPTZ,
+    probe_until TIMESTAMPTZ,
+    failure_rate_threshold DOUBLE PRECISION NOT NULL DEFAULT 0.5,
+    min_requests INTEGER NOT NULL DEFAULT 10,
+    window_seconds INTEGER NOT NULL DEFAULT 60,
+    open_seconds INTEGER NOT NULL DEFAULT 30,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT circuit_breakers_state_check
+        CHECK (state IN ('closed', 'open', 'half_open')),
+    CONSTRAINT circuit_breakers_threshold_check
+        CHECK (failure_rate_threshold > 0 AND failure_rate_threshold <= 1)
+);
@@ -0,0 +1,8 @@
+UPDATE jobs SET status = 'failed' WHERE status = 'quarantined';
+
+ALTER TABLE jobs DROP CONSTRAINT jobs_status_check;
+ALTER TABLE jobs
+    ADD CONSTRAINT jobs_status_check
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'expired'));
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS lost_attempts;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_jobs_coalesce_pending;
+CREATE UNIQUE INDEX idx_jobs_coalesce_pending ON jobs(type, coalesce_key)
+    WHERE status = 'pending' AND coalesce_key IS NOT NULL;
+
+DROP TABLE IF EXISTS fair_queue_clock;
+DROP TABLE IF EXISTS tenant_schedule;
+DROP INDEX IF EXISTS idx_jobs_api_key;
+ALTER TABLE jobs DROP COLUMN IF EXISTS api_key_id;
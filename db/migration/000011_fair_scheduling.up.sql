@@ -0,0 +1,31 @@
+ALTER TABLE jobs
+    ADD COLUMN api_key_id TEXT REFERENCES api_keys(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_jobs_api_key ON jobs(api_key_id, created_at);
+
+-- tenant_id is the submitting api key id, '' for jobs submitted without one.
+-- pass is the tenant's virtual finish time: every job a tenant runs moves it
+-- forward by 1 / weight, and the tenant with the lowest pass goes next.
+CREATE TABLE tenant_schedule (
+    tenant_id TEXT PRIMARY KEY,
+    weight INTEGER NOT NULL DEFAULT 1,
+    pass DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT tenant_schedule_weight_check CHECK (weight > 0)
+);
+
+-- virtual time of the scheduler: the start tag of the last job handed out.
+-- Tenants that were idle are brought forward to it, so they cannot bank
+-- credit while they have nothing queued.
+CREATE TABLE fair_queue_clock (
+    id BOOLEAN PRIMARY KEY DEFAULT true,
+    vtime DOUBLE PRECISION NOT NULL DEFAULT 0,
+    CONSTRAINT fair_queue_clock_single_row CHECK (id)
+);
+
+INSERT INTO fair_queue_clock DEFAULT VALUES;
+
+-- coalescing must never fold one tenant's job into another tenant's
+DROP INDEX IF EXISTS idx_jobs_coalesce_pending;
+CREATE UNIQUE INDEX idx_jobs_coalesce_pending ON jobs(type, coalesce_key, api_key_id)
+    WHERE status = 'pending' AND coalesce_key IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS backlog_limits;
+DROP INDEX IF EXISTS idx_jobs_pending_type;
+DROP INDEX IF EXISTS idx_jobs_pending_queue;
+ALTER TABLE jobs DROP COLUMN IF EXISTS queue;
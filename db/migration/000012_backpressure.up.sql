@@ -0,0 +1,17 @@
+ALTER TABLE jobs ADD COLUMN queue TEXT NOT NULL DEFAULT 'default';
+
+CREATE INDEX idx_jobs_pending_queue ON jobs(queue) WHERE status = 'pending';
+CREATE INDEX idx_jobs_pending_type ON jobs(type) WHERE status = 'pending';
+
+-- scope is 'global' (with an empty name), 'queue', 'type' or 'api_key'
+CREATE TABLE backlog_limits (
+    scope TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    max_pending INTEGER NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (scope, name),
+    CONSTRAINT backlog_limits_scope_check
+        CHECK (scope IN ('global', 'queue', 'type', 'api_key')),
+    CONSTRAINT backlog_limits_max_pending_check
+        CHECK (max_pending > 0)
+);
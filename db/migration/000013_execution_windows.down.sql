@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS execution_windows;
+DROP INDEX IF EXISTS idx_jobs_window_held;
+ALTER TABLE jobs DROP COLUMN IF EXISTS window_held;
@@ -0,0 +1,34 @@
+-- window_held marks pending jobs whose scheduled_at was pushed out to the
+-- next opening of an execution window, so they can be rescheduled when the
+-- windows change.
+ALTER TABLE jobs ADD COLUMN window_held BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX idx_jobs_window_held ON jobs(type, queue)
+    WHERE status = 'pending' AND window_held;
+
+-- An 'allow' window repeats weekly: jobs may run on its weekdays (0 is
+-- Sunday, empty means every day) between start_time and end_time in its
+-- timezone. An end_time at or before start_time runs past midnight.
+-- A 'blackout' window is a one-off period, from starts_at to ends_at, in
+-- which jobs may not run.
+CREATE TABLE execution_windows (
+    id BIGSERIAL PRIMARY KEY,
+    scope TEXT NOT NULL,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    weekdays INTEGER[] NOT NULL DEFAULT '{}',
+    start_time TIME,
+    end_time TIME,
+    starts_at TIMESTAMPTZ,
+    ends_at TIMESTAMPTZ,
+    description TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT execution_windows_scope_check
+        CHECK (scope IN ('type', 'queue')),
+    CONSTRAINT execution_windows_kind_check
+        CHECK ((kind = 'allow' AND start_time IS NOT NULL AND end_time IS NOT NULL)
+            OR (kind = 'blackout' AND starts_at IS NOT NULL AND ends_at > starts_at))
+);
+
+CREATE INDEX idx_execution_windows_scope ON execution_windows(scope, name);
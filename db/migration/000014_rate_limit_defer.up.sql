@@ -0,0 +1,3 @@
+-- Keys with defer_over_limit set have over-limit POST /jobs requests accepted
+-- and scheduled for when their rate limit allows, instead of rejected.
+ALTER TABLE api_keys ADD COLUMN defer_over_limit BOOLEAN NOT NULL DEFAULT FALSE;
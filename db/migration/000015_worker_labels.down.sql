@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS workers;
+ALTER TABLE jobs DROP COLUMN IF EXISTS requires;
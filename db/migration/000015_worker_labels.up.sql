@@ -0,0 +1,11 @@
+-- A job is only handed to a worker whose labels include all of requires.
+ALTER TABLE jobs ADD COLUMN requires TEXT[] NOT NULL DEFAULT '{}';
+
+-- Workers register their labels at startup and heartbeat while running.
+CREATE TABLE workers (
+    id TEXT PRIMARY KEY,
+    hostname TEXT NOT NULL,
+    labels TEXT[] NOT NULL DEFAULT '{}',
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
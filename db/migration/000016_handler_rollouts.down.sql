@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS handler_version_stats;
+DROP TABLE IF EXISTS handler_rollouts;
+ALTER TABLE workers DROP COLUMN IF EXISTS handler_versions;
+ALTER TABLE jobs DROP COLUMN IF EXISTS handler_version;
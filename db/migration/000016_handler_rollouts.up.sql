@@ -0,0 +1,36 @@
+-- handler_version is the version of the handler that ran the job's latest
+-- attempt, as reported by the worker that claimed it.
+ALTER TABLE jobs ADD COLUMN handler_version TEXT;
+
+ALTER TABLE workers ADD COLUMN handler_versions JSONB NOT NULL DEFAULT '{}';
+
+-- A rollout sends canary_percent of a job type's jobs to workers running
+-- canary_version and the rest to workers running stable_version. It is
+-- rolled back, sending everything to stable_version, once the canary's error
+-- rate exceeds the stable one by more than max_error_rate_increase.
+CREATE TABLE handler_rollouts (
+    job_type TEXT PRIMARY KEY,
+    stable_version TEXT NOT NULL,
+    canary_version TEXT NOT NULL,
+    canary_percent INTEGER NOT NULL,
+    min_jobs INTEGER NOT NULL DEFAULT 50,
+    max_error_rate_increase DOUBLE PRECISION NOT NULL DEFAULT 0.05,
+    state TEXT NOT NULL DEFAULT 'active',
+    reason TEXT,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT handler_rollouts_state_check
+        CHECK (state IN ('active', 'rolled_back')),
+    CONSTRAINT handler_rollouts_canary_percent_check
+        CHECK (canary_percent BETWEEN 0 AND 100)
+);
+
+-- Outcomes per handler version since the job type's rollout started.
+CREATE TABLE handler_version_stats (
+    job_type TEXT NOT NULL,
+    version TEXT NOT NULL,
+    successes BIGINT NOT NULL DEFAULT 0,
+    failures BIGINT NOT NULL DEFAULT 0,
+    total_latency_ms BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (job_type, version)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_jobs_created;
+DROP INDEX IF EXISTS idx_jobs_tags;
+ALTER TABLE jobs DROP COLUMN IF EXISTS tags;
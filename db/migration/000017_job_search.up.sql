@@ -0,0 +1,4 @@
+ALTER TABLE jobs ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_jobs_tags ON jobs USING GIN (tags);
+CREATE INDEX idx_jobs_created ON jobs(created_at DESC, id DESC);
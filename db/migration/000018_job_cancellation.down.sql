@@ -0,0 +1,6 @@
+UPDATE jobs SET status = 'failed' WHERE status = 'cancelled';
+
+ALTER TABLE jobs DROP CONSTRAINT jobs_status_check;
+ALTER TABLE jobs
+    ADD CONSTRAINT jobs_status_check
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'expired', 'quarantined'));
@@ -0,0 +1,11 @@
+DELETE FROM backlog_limits WHERE scope = 'organization';
+ALTER TABLE backlog_limits DROP CONSTRAINT backlog_limits_scope_check;
+ALTER TABLE backlog_limits
+    ADD CONSTRAINT backlog_limits_scope_check
+    CHECK (scope IN ('global', 'queue', 'type', 'api_key'));
+
+DROP INDEX IF EXISTS idx_jobs_organization;
+ALTER TABLE jobs DROP COLUMN IF EXISTS organization_id;
+DROP INDEX IF EXISTS idx_api_keys_organization;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS organization_id;
+DROP TABLE IF EXISTS organizations;
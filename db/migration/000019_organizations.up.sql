@@ -0,0 +1,31 @@
+-- An organization owns API keys. Jobs are visible to every key of the
+-- organization that submitted them, and the rate limit is shared by its keys.
+CREATE TABLE organizations (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    -- NULL uses the server's default rate limit
+    rate_per_second DOUBLE PRECISION,
+    burst INTEGER,
+    defer_over_limit BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT organizations_rate_check
+        CHECK ((rate_per_second IS NULL AND burst IS NULL)
+            OR (rate_per_second > 0 AND burst > 0))
+);
+
+ALTER TABLE api_keys
+    ADD COLUMN organization_id TEXT REFERENCES organizations(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_api_keys_organization ON api_keys(organization_id);
+
+-- the organization of the submitting key at the time the job was submitted
+ALTER TABLE jobs
+    ADD COLUMN organization_id TEXT REFERENCES organizations(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_jobs_organization ON jobs(organization_id, created_at);
+
+ALTER TABLE backlog_limits DROP CONSTRAINT backlog_limits_scope_check;
+ALTER TABLE backlog_limits
+    ADD CONSTRAINT backlog_limits_scope_check
+    CHECK (scope IN ('global', 'queue', 'type', 'api_key', 'organization'));
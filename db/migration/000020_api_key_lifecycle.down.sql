@@ -0,0 +1,5 @@
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS replaced_by,
+    DROP COLUMN IF EXISTS revoked_at,
+    DROP COLUMN IF EXISTS key_prefix,
+    DROP COLUMN IF EXISTS description;
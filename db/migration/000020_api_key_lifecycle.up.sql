@@ -0,0 +1,7 @@
+ALTER TABLE api_keys
+    ADD COLUMN description TEXT NOT NULL DEFAULT '',
+    -- the start of the plaintext key, safe to show to identify it
+    ADD COLUMN key_prefix TEXT NOT NULL DEFAULT '',
+    ADD COLUMN revoked_at TIMESTAMPTZ,
+    -- the key that replaced this one when it was rotated
+    ADD COLUMN replaced_by TEXT REFERENCES api_keys(id) ON DELETE SET NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS allowed_queues,
+    DROP COLUMN IF EXISTS allowed_types,
+    DROP COLUMN IF EXISTS scopes;
@@ -0,0 +1,6 @@
+-- Existing keys keep full access to jobs.
+ALTER TABLE api_keys
+    ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{jobs:cancel,jobs:read,jobs:write}',
+    -- job types and queues the key may submit to; empty allows all
+    ADD COLUMN allowed_types TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN allowed_queues TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,39 @@
+-- People and services allowed to use the admin API.
+CREATE TABLE admin_principals (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    role TEXT NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT admin_principals_role_check
+        CHECK (role IN ('viewer', 'operator', 'owner'))
+);
+
+-- A principal may hold several tokens at once, so a token can be replaced
+-- before the old one is revoked.
+CREATE TABLE admin_tokens (
+    id TEXT PRIMARY KEY,
+    principal_id TEXT NOT NULL REFERENCES admin_principals(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    token_prefix TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_admin_tokens_principal ON admin_tokens(principal_id);
+
+-- Every admin request that changes something, and who made it.
+CREATE TABLE admin_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    principal TEXT NOT NULL,
+    method TEXT NOT NULL,
+    path TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_admin_audit_log_created ON admin_audit_log(created_at DESC);
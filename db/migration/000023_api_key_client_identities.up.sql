@@ -0,0 +1,10 @@
+-- Client certificate identities, such as dns:billing.internal, that
+-- authenticate as an API key over mutual TLS.
+CREATE TABLE api_key_client_identities (
+    id TEXT PRIMARY KEY,
+    api_key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    identity TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_api_key_client_identities_key ON api_key_client_identities(api_key_id);
@@ -0,0 +1,3 @@
+-- The secret requests signed with HMAC are checked against. Unlike the key
+-- itself it cannot be hashed, as the server needs it to compute signatures.
+ALTER TABLE api_keys ADD COLUMN signing_secret TEXT;
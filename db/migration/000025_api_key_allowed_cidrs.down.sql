@@ -0,0 +1,4 @@
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS last_ip_denial_at,
+    DROP COLUMN IF EXISTS ip_denials,
+    DROP COLUMN IF EXISTS allowed_cidrs;
@@ -0,0 +1,6 @@
+-- Networks a key may be used from; empty allows all. Requests from
+-- elsewhere are denied and counted.
+ALTER TABLE api_keys
+    ADD COLUMN allowed_cidrs TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN ip_denials BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN last_ip_denial_at TIMESTAMPTZ;
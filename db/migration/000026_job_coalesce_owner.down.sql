@@ -0,0 +1,6 @@
+-- The unique (type, coalesce_key, api_key_id) index is not restored: jobs
+-- that went back to pending may by now share a key with a newer pending job,
+-- which it would reject.
+DROP INDEX IF EXISTS idx_jobs_coalesce_pending;
+CREATE INDEX idx_jobs_coalesce_pending ON jobs(type, coalesce_key, api_key_id)
+    WHERE status = 'pending' AND coalesce_key IS NOT NULL;
@@ -0,0 +1,8 @@
+-- Coalescing is serialized with an advisory lock instead of a unique index,
+-- so a job going back to pending (a retry, deferral, window hold or release)
+-- never collides with a newer pending job of the same key. The owner is the
+-- submitting key, or the organization for callers without one.
+DROP INDEX IF EXISTS idx_jobs_coalesce_pending;
+CREATE INDEX idx_jobs_coalesce_pending
+    ON jobs(type, coalesce_key, (COALESCE('key:' || api_key_id, 'org:' || organization_id, '')))
+    WHERE status = 'pending' AND coalesce_key IS NOT NULL;
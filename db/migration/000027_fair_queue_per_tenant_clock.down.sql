@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS idx_jobs_tenant_runnable;
+DROP INDEX IF EXISTS idx_tenant_schedule_last_start;
+DROP INDEX IF EXISTS idx_tenant_schedule_pass;
+
+CREATE TABLE fair_queue_clock (
+    id BOOLEAN PRIMARY KEY DEFAULT true,
+    vtime DOUBLE PRECISION NOT NULL DEFAULT 0,
+    CONSTRAINT fair_queue_clock_single_row CHECK (id)
+);
+
+INSERT INTO fair_queue_clock (vtime)
+SELECT COALESCE(MAX(last_start), 0) FROM tenant_schedule;
+
+ALTER TABLE tenant_schedule DROP COLUMN IF EXISTS last_start;
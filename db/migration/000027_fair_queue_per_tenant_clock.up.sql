@@ -0,0 +1,27 @@
+-- The scheduler clock is derived from the start tag each tenant was last
+-- charged, so dequeues of different tenants no longer all update one row.
+ALTER TABLE tenant_schedule
+    ADD COLUMN last_start DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+UPDATE tenant_schedule SET last_start = (SELECT vtime FROM fair_queue_clock);
+
+-- dequeues walk the tenants by pass, so every tenant with jobs needs a row,
+-- including the one without a key that jobs of deleted keys fall to
+INSERT INTO tenant_schedule (tenant_id, pass)
+SELECT t.tenant_id, c.vtime
+FROM (
+    SELECT COALESCE(api_key_id, '') AS tenant_id
+    FROM jobs
+    WHERE status IN ('pending', 'processing')
+    UNION
+    SELECT ''
+) t
+CROSS JOIN fair_queue_clock c
+ON CONFLICT (tenant_id) DO NOTHING;
+
+DROP TABLE IF EXISTS fair_queue_clock;
+
+CREATE INDEX idx_tenant_schedule_pass ON tenant_schedule(pass, tenant_id);
+CREATE INDEX idx_tenant_schedule_last_start ON tenant_schedule(last_start);
+CREATE INDEX idx_jobs_tenant_runnable ON jobs((COALESCE(api_key_id, '')), scheduled_at)
+    WHERE status IN ('pending', 'processing');
@@ -4,48 +4,298 @@ INSERT INTO jobs (
     type,
     payload,
     status,
-    max_attempts
+    max_attempts,
+    expires_at,
+    group_key,
+    api_key_id,
+    queue,
+    scheduled_at,
+    window_held,
+    requires,
+    tags,
+    organization_id
 ) VALUES (
-    $1, $2, $3, $4, $5
+    @id,
+    @type,
+    @payload,
+    @status,
+    @max_attempts,
+    @expires_at,
+    @group_key,
+    @api_key_id,
+    @queue,
+    COALESCE(sqlc.narg(scheduled_at)::timestamptz, NOW()),
+    @window_held,
+    @requires,
+    @tags,
+    @organization_id
+)
+RETURNING *;
+
+-- Serializes the coalescing submissions of one type, key and owner, so two
+-- of them cannot both miss the pending job and create one each.
+-- name: LockCoalesceKey :exec
+SELECT pg_advisory_xact_lock(hashtextextended(@lock_key::text, 0));
+
+-- The owner is the submitting key, or the organization for callers without
+-- one, so coalescing never folds one tenant's job into another's. A retried
+-- job may be pending next to a newer one; the newest takes submissions.
+-- name: GetPendingCoalescedJobForUpdate :one
+SELECT * FROM jobs
+WHERE status = 'pending'
+    AND type = @type
+    AND coalesce_key = @coalesce_key
+    AND COALESCE('key:' || api_key_id, 'org:' || organization_id, '') = @coalesce_owner::text
+ORDER BY enqueue_seq DESC
+LIMIT 1
+FOR UPDATE;
+
+-- name: CreateCoalescedJob :one
+INSERT INTO jobs (
+    id,
+    type,
+    payload,
+    status,
+    max_attempts,
+    expires_at,
+    group_key,
+    scheduled_at,
+    coalesce_key,
+    coalesce_mode,
+    coalesce_until,
+    api_key_id,
+    queue,
+    requires,
+    tags,
+    organization_id
+) VALUES (
+    $1, $2, $3, 'pending', $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
 )
 RETURNING *;
 
+-- Folds a submission into a pending job. The job keeps its id, takes the
+-- newest payload (or the merged payload in 'merge' mode) and has its run
+-- time pushed out, but never past the coalesce_until of the first
+-- submission. A submission the rate limiter deferred pushes both out to
+-- deferred_until, so the deferral is not cut short by the first one's cap.
+-- name: CoalesceJob :one
+UPDATE jobs
+SET 
+    payload = CASE
+        WHEN @coalesce_mode::text = 'merge' THEN payload || @payload::jsonb
+        ELSE @payload::jsonb
+    END,
+    expires_at = @expires_at,
+    requires = @requires,
+    tags = @tags,
+    scheduled_at = GREATEST(
+        LEAST(@scheduled_at::timestamptz, coalesce_until),
+        sqlc.narg(deferred_until)::timestamptz
+    ),
+    coalesce_until = GREATEST(coalesce_until, sqlc.narg(deferred_until)::timestamptz),
+    updated_at = NOW()
+WHERE id = @id
+RETURNING *;
+
 -- name: GetJob :one
 SELECT * FROM jobs
 WHERE id = $1;
 
+-- name: GetJobForUpdate :one
+SELECT * FROM jobs
+WHERE id = $1
+FOR UPDATE;
+
+-- name: CancelJob :one
+UPDATE jobs
+SET 
+    status = 'cancelled',
+    error_message = $2,
+    window_held = FALSE,
+    updated_at = NOW()
+WHERE id = $1
+    AND status IN ('pending', 'quarantined')
+RETURNING *;
+
+-- A job with a group_key is only eligible while no other job in its group
+-- is processing or queued ahead of it, so each group runs one job at a time
+-- in enqueue order. Job types whose circuit breaker is open, or half open
+-- with a probe in flight, are skipped, as are the excluded_types the worker
+-- has no free capacity for. Tenants are served in order of their pass in
+-- tenant_schedule (see ChargeTenant), oldest job first within a tenant.
+-- Reclaiming a job whose lease ran out counts as a
+-- lost attempt; jobs about to reach the quarantine threshold are left for
+-- QuarantineLostJobs.
 -- name: DequeueJob :one
 UPDATE jobs
 SET 
     status = 'processing',
+    lost_attempts = CASE
+        WHEN status = 'processing' THEN lost_attempts + 1
+        ELSE lost_attempts
+    END,
+    lease_token = @lease_token,
+    lease_expires_at = @lease_expires_at,
+    window_held = FALSE,
+    handler_version = @handler_versions::jsonb ->> type,
     updated_at = NOW()
 WHERE id = (
-    SELECT id
-    FROM jobs
-    WHERE status = 'pending'
-        AND scheduled_at <= NOW()
-    ORDER BY scheduled_at ASC
+    SELECT runnable.id
+    -- tenants in fair queue order, each offering its oldest runnable job,
+    -- so both sides are walked by index and the walk stops at the first job
+    FROM (
+        SELECT tenant_id
+        FROM tenant_schedule
+        ORDER BY pass ASC, tenant_id ASC
+    ) t
+    CROSS JOIN LATERAL (
+        SELECT j.id
+        FROM jobs j
+        WHERE COALESCE(j.api_key_id, '') = t.tenant_id
+            AND j.status IN ('pending', 'processing')
+            AND ((j.status = 'pending' AND j.scheduled_at <= NOW())
+                OR (j.status = 'processing' AND j.lease_expires_at < NOW()
+                    AND j.lost_attempts + 1 < @quarantine_threshold::integer))
+            AND (j.expires_at IS NULL OR j.expires_at > NOW())
+            AND j.type <> ALL(@excluded_types::text[])
+            AND j.requires <@ @labels::text[]
+            -- jobs of a type under rollout go to the version they are routed to
+            AND NOT EXISTS (
+                SELECT 1
+                FROM handler_rollouts r
+                WHERE r.job_type = j.type
+                    AND (@handler_versions::jsonb ->> j.type) IS DISTINCT FROM CASE
+                        WHEN r.state = 'active'
+                            AND (hashtext(j.id) & 2147483647) % 100 < r.canary_percent
+                            THEN r.canary_version
+                        ELSE r.stable_version
+                    END
+            )
+            AND (j.group_key IS NULL OR NOT EXISTS (
+                SELECT 1
+                FROM jobs g
+                WHERE g.group_key = j.group_key
+                    AND g.id <> j.id
+                    AND (g.status = 'processing'
+                        OR (g.status = 'pending' AND g.enqueue_seq < j.enqueue_seq))
+            ))
+            AND NOT EXISTS (
+                SELECT 1
+                FROM circuit_breakers b
+                WHERE b.job_type = j.type
+                    AND ((b.state = 'open' AND b.open_until > NOW())
+                        OR (b.state = 'half_open' AND b.probe_until > NOW()))
+            )
+        ORDER BY j.scheduled_at ASC
+        LIMIT 1
+        FOR UPDATE SKIP LOCKED
+    ) runnable
     LIMIT 1
-    FOR UPDATE SKIP LOCKED
 )
 RETURNING *;
 
--- name: CompleteJob :exec
+-- name: ExpireJobs :execrows
+UPDATE jobs
+SET 
+    status = 'expired',
+    error_message = 'job expired before a worker could run it',
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE expires_at <= NOW()
+    AND (status = 'pending'
+        OR (status = 'processing' AND lease_expires_at < NOW()));
+
+-- Jobs whose lease ran out without an outcome being recorded most likely
+-- crashed or hung the worker running them. Once that has happened
+-- quarantine_threshold times they are quarantined instead of reclaimed.
+-- name: QuarantineLostJobs :many
+UPDATE jobs
+SET 
+    status = 'quarantined',
+    lost_attempts = lost_attempts + 1,
+    error_message = 'quarantined: lease expired without an outcome too many times',
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE status = 'processing'
+    AND lease_expires_at < NOW()
+    AND lost_attempts + 1 >= @quarantine_threshold::integer
+RETURNING *;
+
+-- A job whose handler crashed the worker goroutine. It goes back to pending,
+-- or to quarantined once it has crashed quarantine_threshold times.
+-- name: CrashJob :one
+UPDATE jobs
+SET 
+    lost_attempts = lost_attempts + 1,
+    status = CASE
+        WHEN lost_attempts + 1 >= @quarantine_threshold::integer THEN 'quarantined'
+        ELSE 'pending'
+    END,
+    error_message = @error_message,
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE id = @id
+    AND status = 'processing'
+    AND lease_token = @lease_token
+RETURNING *;
+
+-- name: ReleaseQuarantinedJob :one
+UPDATE jobs
+SET 
+    status = 'pending',
+    lost_attempts = 0,
+    scheduled_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+    AND status = 'quarantined'
+RETURNING *;
+
+-- name: DeferJob :execrows
+UPDATE jobs
+SET 
+    status = 'pending',
+    scheduled_at = $3,
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE id = $1
+    AND status = 'processing'
+    AND lease_token = $2;
+
+-- name: CountRunningJobsOfType :one
+SELECT COUNT(*) FROM jobs
+WHERE type = $1
+    AND id <> $2
+    AND status = 'processing'
+    AND lease_expires_at > NOW();
+
+-- name: CompleteJob :execrows
 UPDATE jobs
 SET 
     status = 'completed',
+    lease_token = NULL,
+    lease_expires_at = NULL,
     updated_at = NOW()
-WHERE id = $1;
+WHERE id = $1
+    AND status = 'processing'
+    AND lease_token = $2;
 
--- name: FailJob :exec
+-- name: FailJob :execrows
 UPDATE jobs
 SET 
     status = $2,
     attempts = $3,
     error_message = $4,
     scheduled_at = $5,
+    lease_token = NULL,
+    lease_expires_at = NULL,
     updated_at = NOW()
-WHERE id = $1;
+WHERE id = $1
+    AND status = 'processing'
+    AND lease_token = $6;
 
 -- name: ListJobs :many
 SELECT * FROM jobs
@@ -53,13 +303,150 @@ WHERE status = $1
 ORDER BY created_at DESC
 LIMIT $2 OFFSET $3;
 
+-- Lists jobs newest first. Every filter is optional; the page starts after
+-- the (cursor_created_at, cursor_id) of the last job of the previous page.
+-- With owner_api_key_id set only the jobs of that key, or of
+-- owner_organization_id, are listed, as for Owner.Owns.
+-- name: SearchJobs :many
+SELECT * FROM jobs
+WHERE (sqlc.narg(owner_api_key_id)::text IS NULL
+        OR api_key_id = sqlc.narg(owner_api_key_id)::text
+        OR organization_id = sqlc.narg(owner_organization_id)::text)
+    AND (sqlc.narg(api_key_id)::text IS NULL OR api_key_id = sqlc.narg(api_key_id)::text)
+    AND (sqlc.narg(organization_id)::text IS NULL OR organization_id = sqlc.narg(organization_id)::text)
+    AND (cardinality(@statuses::text[]) = 0 OR status = ANY(@statuses::text[]))
+    AND (sqlc.narg(type)::text IS NULL OR type = sqlc.narg(type)::text)
+    AND (sqlc.narg(queue)::text IS NULL OR queue = sqlc.narg(queue)::text)
+    AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after)::timestamptz)
+    AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before)::timestamptz)
+    AND (sqlc.narg(updated_after)::timestamptz IS NULL OR updated_at >= sqlc.narg(updated_after)::timestamptz)
+    AND (sqlc.narg(updated_before)::timestamptz IS NULL OR updated_at < sqlc.narg(updated_before)::timestamptz)
+    AND (sqlc.narg(error_pattern)::text IS NULL OR error_message ILIKE sqlc.narg(error_pattern)::text)
+    AND tags @> @tags::text[]
+    AND (sqlc.narg(cursor_created_at)::timestamptz IS NULL
+        OR (created_at, id) < (sqlc.narg(cursor_created_at)::timestamptz, @cursor_id::text))
+ORDER BY created_at DESC, id DESC
+LIMIT @page_size;
+
 -- name: CountJobsByStatus :one
 SELECT COUNT(*) FROM jobs
 WHERE status = $1;
 
+-- name: CountPendingJobsByQueue :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'pending'
+    AND queue = $1;
+
+-- name: CountPendingJobsByType :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'pending'
+    AND type = $1;
+
+-- name: CountPendingJobsByAPIKey :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'pending'
+    AND api_key_id = $1;
+
+-- name: CountPendingJobsByOrganization :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'pending'
+    AND organization_id = $1;
+
+-- name: CountJobsGroupByStatus :many
+SELECT status, COUNT(*) AS count FROM jobs
+GROUP BY status;
+
+-- name: CountExpiredJobsSince :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'expired'
+    AND updated_at >= $1;
+
 -- name: CreateAPIKey :one
-INSERT INTO api_keys (id, name, key_hash, created_by)
-VALUES ($1, $2, $3, $4)
+INSERT INTO api_keys (
+    id, name, key_hash, created_by, defer_over_limit, organization_id,
+    description, key_prefix, expires_at, scopes, allowed_types, allowed_queues,
+    allowed_cidrs
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+RETURNING *;
+
+-- name: GetAPIKey :one
+SELECT * FROM api_keys
+WHERE id = $1;
+
+-- name: GetAPIKeyForUpdate :one
+SELECT * FROM api_keys
+WHERE id = $1
+FOR UPDATE;
+
+-- Edits the metadata of a key; a NULL leaves the field as it is.
+-- name: UpdateAPIKeyMetadata :one
+UPDATE api_keys
+SET name = COALESCE(sqlc.narg(name)::text, name),
+    description = COALESCE(sqlc.narg(description)::text, description)
+WHERE id = @id
+RETURNING *;
+
+-- name: SetAPIKeyScopes :one
+UPDATE api_keys
+SET scopes = $2,
+    allowed_types = $3,
+    allowed_queues = $4
+WHERE id = $1
+RETURNING *;
+
+-- name: SetAPIKeyAllowedCIDRs :one
+UPDATE api_keys
+SET allowed_cidrs = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: RecordAPIKeyIPDenial :exec
+UPDATE api_keys
+SET ip_denials = ip_denials + 1,
+    last_ip_denial_at = NOW()
+WHERE id = $1;
+
+-- Sets, replaces or, with NULL, removes the signing secret of an active key.
+-- name: SetAPIKeySigningSecret :one
+UPDATE api_keys
+SET signing_secret = $2
+WHERE id = $1 AND is_active = true
+RETURNING *;
+
+-- name: SetAPIKeyExpiry :one
+UPDATE api_keys
+SET expires_at = $2
+WHERE id = $1 AND is_active = true
+RETURNING *;
+
+-- Lets a rotated key keep working until overlap_ends, or its own expiry if
+-- that comes first.
+-- name: RetireRotatedAPIKey :one
+UPDATE api_keys
+SET expires_at = LEAST(expires_at, @overlap_ends::timestamptz),
+    replaced_by = @replaced_by
+WHERE id = @id
+RETURNING *;
+
+-- name: SetAPIKeyOrganization :one
+UPDATE api_keys
+SET organization_id = $2
+WHERE id = $1
+RETURNING *;
+
+-- Jobs a key submitted outside any organization follow it into the one it
+-- joins, so they stay visible there after the key is rotated.
+-- name: AdoptAPIKeyJobs :exec
+UPDATE jobs
+SET organization_id = $2
+WHERE api_key_id = $1
+    AND organization_id IS NULL;
+
+-- name: SetAPIKeyDeferOverLimit :one
+UPDATE api_keys
+SET defer_over_limit = $2
+WHERE id = $1
 RETURNING *;
 
 -- name: GetAPIKeyByHash :one
@@ -68,6 +455,7 @@ WHERE key_hash = $1 AND is_active = true;
 
 -- name: ListAPIKeys :many
 SELECT * FROM api_keys
+WHERE sqlc.narg(organization_id)::text IS NULL OR organization_id = sqlc.narg(organization_id)::text
 ORDER BY created_at DESC;
 
 -- name: UpdateLastUsed :exec
@@ -75,7 +463,437 @@ UPDATE api_keys
 SET last_used_at = NOW()
 WHERE id = $1;
 
--- name: DeactivateAPIKey :exec
+-- name: DeactivateAPIKey :execrows
 UPDATE api_keys
-SET is_active = false
+SET is_active = false,
+    revoked_at = COALESCE(revoked_at, NOW())
+WHERE id = $1;
+
+-- name: UpsertOrganization :one
+INSERT INTO organizations (id, name, rate_per_second, burst, defer_over_limit)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE SET
+    name = EXCLUDED.name,
+    rate_per_second = EXCLUDED.rate_per_second,
+    burst = EXCLUDED.burst,
+    defer_over_limit = EXCLUDED.defer_over_limit,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: GetOrganization :one
+SELECT * FROM organizations
+WHERE id = $1;
+
+-- name: ListOrganizations :many
+SELECT * FROM organizations
+ORDER BY created_at;
+
+-- Keys of a deleted organization are left without one, and its backlog
+-- limit goes with it.
+-- name: DeleteOrganization :exec
+WITH deleted_limit AS (
+    DELETE FROM backlog_limits
+    WHERE scope = 'organization' AND name = $1
+)
+DELETE FROM organizations
 WHERE id = $1;
+
+
+-- name: GetSideEffect :one
+SELECT * FROM job_side_effects
+WHERE key = $1;
+
+-- name: RecordSideEffect :exec
+INSERT INTO job_side_effects (key, job_id, outcome)
+VALUES ($1, $2, $3)
+ON CONFLICT (key) DO NOTHING;
+
+
+-- name: GetJobTypeLimitForUpdate :one
+SELECT * FROM job_type_limits
+WHERE job_type = $1
+FOR UPDATE;
+
+-- name: ListJobTypeLimits :many
+SELECT * FROM job_type_limits
+ORDER BY job_type;
+
+-- name: UpsertJobTypeLimit :one
+INSERT INTO job_type_limits (job_type, max_concurrent, rate_per_second, burst, tokens)
+VALUES ($1, $2, $3, $4, $4)
+ON CONFLICT (job_type) DO UPDATE SET
+    max_concurrent = EXCLUDED.max_concurrent,
+    rate_per_second = EXCLUDED.rate_per_second,
+    burst = EXCLUDED.burst,
+    tokens = LEAST(job_type_limits.tokens, EXCLUDED.burst),
+    updated_at = NOW()
+RETURNING *;
+
+-- name: UpdateJobTypeTokens :exec
+UPDATE job_type_limits
+SET 
+    tokens = $2,
+    refilled_at = $3
+WHERE job_type = $1;
+
+-- name: DeleteJobTypeLimit :exec
+DELETE FROM job_type_limits
+WHERE job_type = $1;
+
+
+-- name: EnsureCircuitBreaker :exec
+INSERT INTO circuit_breakers (job_type)
+VALUES ($1)
+ON CONFLICT (job_type) DO NOTHING;
+
+-- name: GetCircuitBreakerForUpdate :one
+SELECT * FROM circuit_breakers
+WHERE job_type = $1
+FOR UPDATE;
+
+-- name: ListCircuitBreakers :many
+SELECT * FROM circuit_breakers
+ORDER BY job_type;
+
+-- name: UpdateCircuitBreaker :exec
+UPDATE circuit_breakers
+SET 
+    state = $2,
+    failures = $3,
+    successes = $4,
+    window_started_at = $5,
+    opened_at = $6,
+    open_until = $7,
+    probe_until = $8,
+    updated_at = NOW()
+WHERE job_type = $1;
+
+-- name: ConfigureCircuitBreaker :one
+INSERT INTO circuit_breakers (
+    job_type,
+    failure_rate_threshold,
+    min_requests,
+    window_seconds,
+    open_seconds
+) VALUES (
+    $1, $2, $3, $4, $5
+)
+ON CONFLICT (job_type) DO UPDATE SET
+    failure_rate_threshold = EXCLUDED.failure_rate_threshold,
+    min_requests = EXCLUDED.min_requests,
+    window_seconds = EXCLUDED.window_seconds,
+    open_seconds = EXCLUDED.open_seconds,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: ResetCircuitBreaker :one
+UPDATE circuit_breakers
+SET 
+    state = 'closed',
+    failures = 0,
+    successes = 0,
+    window_started_at = NOW(),
+    opened_at = NULL,
+    open_until = NULL,
+    probe_until = NULL,
+    updated_at = NOW()
+WHERE job_type = $1
+RETURNING *;
+
+-- Moves a tenant forward by one job: its pass becomes its start tag, the
+-- later of its old pass and the scheduler clock, plus 1 / weight.
+-- A new tenant starts at the scheduler clock, like one that was idle.
+-- name: EnsureTenant :exec
+INSERT INTO tenant_schedule (tenant_id, pass)
+VALUES ($1, (SELECT COALESCE(MAX(s.last_start), 0) FROM tenant_schedule s))
+ON CONFLICT (tenant_id) DO NOTHING;
+
+-- The job starts at the tenant's pass, or at the scheduler clock if the
+-- tenant fell behind it while idle, and finishes 1 / weight later.
+-- name: ChargeTenant :one
+UPDATE tenant_schedule
+SET last_start = GREATEST(pass, (SELECT COALESCE(MAX(s.last_start), 0) FROM tenant_schedule s)),
+    pass = GREATEST(pass, (SELECT COALESCE(MAX(s.last_start), 0) FROM tenant_schedule s)) + 1.0 / weight,
+    updated_at = NOW()
+WHERE tenant_id = @tenant_id::text
+RETURNING *;
+
+-- name: SetTenantWeight :one
+INSERT INTO tenant_schedule (tenant_id, weight, pass)
+VALUES ($1, $2, (SELECT COALESCE(MAX(s.last_start), 0) FROM tenant_schedule s))
+ON CONFLICT (tenant_id) DO UPDATE SET
+    weight = EXCLUDED.weight,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: ListTenantSchedule :many
+SELECT * FROM tenant_schedule
+ORDER BY pass ASC;
+
+-- name: ListBacklogLimits :many
+SELECT * FROM backlog_limits
+ORDER BY scope, name;
+
+-- name: UpsertBacklogLimit :one
+INSERT INTO backlog_limits (scope, name, max_pending)
+VALUES ($1, $2, $3)
+ON CONFLICT (scope, name) DO UPDATE SET
+    max_pending = EXCLUDED.max_pending,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteBacklogLimit :exec
+DELETE FROM backlog_limits
+WHERE scope = $1 AND name = $2;
+
+-- name: ListExecutionWindows :many
+SELECT * FROM execution_windows
+ORDER BY scope, name, id;
+
+-- name: ListExecutionWindowsForJob :many
+SELECT * FROM execution_windows
+WHERE (scope = 'type' AND name = @job_type)
+    OR (scope = 'queue' AND name = @queue);
+
+-- name: CreateExecutionWindow :one
+INSERT INTO execution_windows (
+    scope,
+    name,
+    kind,
+    timezone,
+    weekdays,
+    start_time,
+    end_time,
+    starts_at,
+    ends_at,
+    description
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+RETURNING *;
+
+-- name: DeleteExecutionWindow :one
+DELETE FROM execution_windows
+WHERE id = $1
+RETURNING *;
+
+-- Puts a job claimed outside its execution windows back to pending until
+-- the next window opens. Attempts are untouched.
+-- name: HoldJobForWindow :execrows
+UPDATE jobs
+SET 
+    status = 'pending',
+    scheduled_at = $3,
+    window_held = TRUE,
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE id = $1
+    AND status = 'processing'
+    AND lease_token = $2;
+
+-- name: ListWindowHeldJobGroups :many
+SELECT DISTINCT type, queue FROM jobs
+WHERE status = 'pending'
+    AND window_held
+    AND ((@scope::text = 'type' AND type = @name::text)
+        OR (@scope::text = 'queue' AND queue = @name::text));
+
+-- name: RescheduleWindowHeldJobs :execrows
+UPDATE jobs
+SET 
+    scheduled_at = @scheduled_at,
+    window_held = @window_held,
+    updated_at = NOW()
+WHERE status = 'pending'
+    AND window_held
+    AND type = @type
+    AND queue = @queue;
+
+-- name: RegisterWorker :one
+INSERT INTO workers (id, hostname, labels, handler_versions)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (id) DO UPDATE SET
+    labels = EXCLUDED.labels,
+    handler_versions = EXCLUDED.handler_versions,
+    last_seen_at = NOW()
+RETURNING *;
+
+-- name: ListWorkers :many
+SELECT * FROM workers
+WHERE last_seen_at > $1
+ORDER BY started_at;
+
+-- name: ListHandlerRollouts :many
+SELECT * FROM handler_rollouts
+ORDER BY job_type;
+
+-- name: GetHandlerRolloutForUpdate :one
+SELECT * FROM handler_rollouts
+WHERE job_type = $1
+FOR UPDATE;
+
+-- name: UpsertHandlerRollout :one
+INSERT INTO handler_rollouts (
+    job_type,
+    stable_version,
+    canary_version,
+    canary_percent,
+    min_jobs,
+    max_error_rate_increase
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+)
+ON CONFLICT (job_type) DO UPDATE SET
+    stable_version = EXCLUDED.stable_version,
+    canary_version = EXCLUDED.canary_version,
+    canary_percent = EXCLUDED.canary_percent,
+    min_jobs = EXCLUDED.min_jobs,
+    max_error_rate_increase = EXCLUDED.max_error_rate_increase,
+    state = 'active',
+    reason = NULL,
+    started_at = NOW(),
+    updated_at = NOW()
+RETURNING *;
+
+-- name: RollBackHandlerRollout :one
+UPDATE handler_rollouts
+SET 
+    state = 'rolled_back',
+    reason = $2,
+    updated_at = NOW()
+WHERE job_type = $1
+RETURNING *;
+
+-- name: DeleteHandlerRollout :exec
+DELETE FROM handler_rollouts
+WHERE job_type = $1;
+
+-- name: RecordHandlerVersionOutcome :one
+INSERT INTO handler_version_stats (job_type, version, successes, failures, total_latency_ms)
+VALUES (@job_type, @version, @successes, @failures, @latency_ms)
+ON CONFLICT (job_type, version) DO UPDATE SET
+    successes = handler_version_stats.successes + EXCLUDED.successes,
+    failures = handler_version_stats.failures + EXCLUDED.failures,
+    total_latency_ms = handler_version_stats.total_latency_ms + EXCLUDED.total_latency_ms
+RETURNING *;
+
+-- name: ListHandlerVersionStats :many
+SELECT * FROM handler_version_stats
+ORDER BY job_type, version;
+
+-- name: ResetHandlerVersionStats :exec
+DELETE FROM handler_version_stats
+WHERE job_type = $1;
+
+-- name: GetHandlerVersionStat :one
+SELECT * FROM handler_version_stats
+WHERE job_type = $1 AND version = $2;
+
+-- name: CreateAdminPrincipal :one
+INSERT INTO admin_principals (id, name, role, created_by)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetAdminPrincipalForUpdate :one
+SELECT * FROM admin_principals
+WHERE id = $1
+FOR UPDATE;
+
+-- name: ListAdminPrincipals :many
+SELECT * FROM admin_principals
+ORDER BY name;
+
+-- A NULL leaves the field as it is.
+-- name: UpdateAdminPrincipal :one
+UPDATE admin_principals
+SET role = COALESCE(sqlc.narg(role)::text, role),
+    is_active = COALESCE(sqlc.narg(is_active)::boolean, is_active),
+    updated_at = NOW()
+WHERE id = @id
+RETURNING *;
+
+-- Locks the active owners, so two requests cannot demote the last two
+-- owners at once.
+-- name: LockActiveAdminOwners :many
+SELECT id FROM admin_principals
+WHERE role = 'owner' AND is_active = true
+FOR UPDATE;
+
+-- name: CountActiveAdminOwners :one
+SELECT COUNT(*) FROM admin_principals
+WHERE role = 'owner' AND is_active = true;
+
+-- name: CreateAdminToken :one
+INSERT INTO admin_tokens (id, principal_id, token_hash, token_prefix, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetAdminTokenByHash :one
+SELECT
+    t.id AS token_id,
+    t.expires_at,
+    p.id AS principal_id,
+    p.name,
+    p.role
+FROM admin_tokens t
+JOIN admin_principals p ON p.id = t.principal_id
+WHERE t.token_hash = $1
+    AND t.revoked_at IS NULL
+    AND p.is_active = true;
+
+-- name: ListAdminTokens :many
+SELECT id, principal_id, token_prefix, created_at, expires_at, last_used_at, revoked_at
+FROM admin_tokens
+WHERE principal_id = $1
+ORDER BY created_at DESC;
+
+-- name: RevokeAdminToken :execrows
+UPDATE admin_tokens
+SET revoked_at = COALESCE(revoked_at, NOW())
+WHERE id = $1 AND principal_id = $2;
+
+-- name: TouchAdminToken :exec
+UPDATE admin_tokens
+SET last_used_at = NOW()
+WHERE id = $1;
+
+-- name: RecordAdminAction :exec
+INSERT INTO admin_audit_log (principal, method, path, status)
+VALUES ($1, $2, $3, $4);
+
+-- name: ListAdminActions :many
+SELECT * FROM admin_audit_log
+WHERE sqlc.narg(principal)::text IS NULL OR principal = sqlc.narg(principal)::text
+ORDER BY created_at DESC, id DESC
+LIMIT @page_size;
+
+-- Returns no rows if the identity already belongs to a key.
+-- name: AddClientIdentity :one
+INSERT INTO api_key_client_identities (id, api_key_id, identity)
+VALUES ($1, $2, $3)
+ON CONFLICT (identity) DO NOTHING
+RETURNING *;
+
+-- name: ListClientIdentities :many
+SELECT * FROM api_key_client_identities
+WHERE api_key_id = $1
+ORDER BY identity;
+
+-- name: DeleteClientIdentity :execrows
+DELETE FROM api_key_client_identities
+WHERE id = $1 AND api_key_id = $2;
+
+-- name: MoveClientIdentities :exec
+UPDATE api_key_client_identities
+SET api_key_id = @to_api_key_id
+WHERE api_key_id = @from_api_key_id;
+
+-- name: GetAPIKeysByClientIdentities :many
+SELECT * FROM api_keys
+WHERE is_active = true
+    AND id IN (
+        SELECT api_key_id FROM api_key_client_identities
+        WHERE identity = ANY(@identities::text[])
+    )
+ORDER BY id;
@@ -8,12 +8,61 @@ CREATE TABLE jobs (
     error_message TEXT,
     scheduled_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    lease_token TEXT,
+    lease_expires_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    group_key TEXT,
+    enqueue_seq BIGINT GENERATED ALWAYS AS IDENTITY,
+    coalesce_key TEXT,
+    coalesce_mode TEXT,
+    coalesce_until TIMESTAMPTZ,
+    lost_attempts INTEGER NOT NULL DEFAULT 0,
+    api_key_id TEXT,
+    queue TEXT NOT NULL DEFAULT 'default',
+    window_held BOOLEAN NOT NULL DEFAULT FALSE,
+    requires TEXT[] NOT NULL DEFAULT '{}',
+    handler_version TEXT,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    CONSTRAINT jobs_status_check
+        CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'expired', 'quarantined', 'cancelled')),
+    CONSTRAINT jobs_coalesce_mode_check
+        CHECK (coalesce_mode IN ('replace', 'merge'))
 );
 
 CREATE INDEX idx_jobs_status_scheduled ON jobs(status, scheduled_at) 
     WHERE status IN ('pending', 'processing');
+CREATE INDEX idx_jobs_lease_expires ON jobs(lease_expires_at)
+    WHERE status = 'processing';
+CREATE INDEX idx_jobs_expires ON jobs(expires_at)
+    WHERE status IN ('pending', 'processing') AND expires_at IS NOT NULL;
+CREATE INDEX idx_jobs_group ON jobs(group_key, enqueue_seq)
+    WHERE group_key IS NOT NULL AND status IN ('pending', 'processing');
+CREATE INDEX idx_jobs_type_processing ON jobs(type)
+    WHERE status = 'processing';
+CREATE INDEX idx_jobs_pending_queue ON jobs(queue) WHERE status = 'pending';
+CREATE INDEX idx_jobs_pending_type ON jobs(type) WHERE status = 'pending';
+CREATE INDEX idx_jobs_window_held ON jobs(type, queue)
+    WHERE status = 'pending' AND window_held;
+CREATE INDEX idx_jobs_tags ON jobs USING GIN (tags);
+CREATE INDEX idx_jobs_created ON jobs(created_at DESC, id DESC);
+
 
+-- An organization owns API keys. Jobs are visible to every key of the
+-- organization that submitted them, and the rate limit is shared by its keys.
+CREATE TABLE organizations (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    -- NULL uses the server's default rate limit
+    rate_per_second DOUBLE PRECISION,
+    burst INTEGER,
+    defer_over_limit BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT organizations_rate_check
+        CHECK ((rate_per_second IS NULL AND burst IS NULL)
+            OR (rate_per_second > 0 AND burst > 0))
+);
 
 CREATE TABLE api_keys (
     id TEXT PRIMARY KEY,
@@ -23,8 +72,250 @@ CREATE TABLE api_keys (
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     expires_at TIMESTAMPTZ,
     last_used_at TIMESTAMPTZ,
-    is_active BOOLEAN NOT NULL DEFAULT true
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    -- accept over-limit POST /jobs requests and schedule them for when the
+    -- rate limit allows instead of rejecting them
+    defer_over_limit BOOLEAN NOT NULL DEFAULT FALSE,
+    organization_id TEXT REFERENCES organizations(id) ON DELETE SET NULL,
+    description TEXT NOT NULL DEFAULT '',
+    -- the start of the plaintext key, safe to show to identify it
+    key_prefix TEXT NOT NULL DEFAULT '',
+    revoked_at TIMESTAMPTZ,
+    -- the key that replaced this one when it was rotated
+    replaced_by TEXT REFERENCES api_keys(id) ON DELETE SET NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{jobs:cancel,jobs:read,jobs:write}',
+    -- job types and queues the key may submit to; empty allows all
+    allowed_types TEXT[] NOT NULL DEFAULT '{}',
+    allowed_queues TEXT[] NOT NULL DEFAULT '{}',
+    -- the secret HMAC signed requests are checked against; it cannot be
+    -- hashed, as the server needs it to compute signatures
+    signing_secret TEXT,
+    -- networks the key may be used from; empty allows all
+    allowed_cidrs TEXT[] NOT NULL DEFAULT '{}',
+    -- requests from outside allowed_cidrs
+    ip_denials BIGINT NOT NULL DEFAULT 0,
+    last_ip_denial_at TIMESTAMPTZ
 );
 
 CREATE INDEX idx_api_keys_hash ON api_keys(key_hash);
-CREATE INDEX idx_api_keys_active ON api_keys(is_active) WHERE is_active = true;
\ No newline at end of file
+CREATE INDEX idx_api_keys_active ON api_keys(is_active) WHERE is_active = true;
+CREATE INDEX idx_api_keys_organization ON api_keys(organization_id);
+
+ALTER TABLE jobs
+    ADD CONSTRAINT jobs_api_key_id_fkey
+    FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_jobs_api_key ON jobs(api_key_id, created_at);
+CREATE INDEX idx_jobs_tenant_runnable ON jobs((COALESCE(api_key_id, '')), scheduled_at)
+    WHERE status IN ('pending', 'processing');
+
+-- the organization of the submitting key at the time the job was submitted
+ALTER TABLE jobs
+    ADD COLUMN organization_id TEXT REFERENCES organizations(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_jobs_organization ON jobs(organization_id, created_at);
+CREATE INDEX idx_jobs_coalesce_pending
+    ON jobs(type, coalesce_key, (COALESCE('key:' || api_key_id, 'org:' || organization_id, '')))
+    WHERE status = 'pending' AND coalesce_key IS NOT NULL;
+
+CREATE TABLE job_side_effects (
+    key TEXT PRIMARY KEY,
+    job_id TEXT NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    outcome JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_job_side_effects_job ON job_side_effects(job_id);
+
+
+
+CREATE TABLE job_type_limits (
+    job_type TEXT PRIMARY KEY,
+    max_concurrent INTEGER,
+    rate_per_second DOUBLE PRECISION,
+    burst INTEGER NOT NULL DEFAULT 1,
+    tokens DOUBLE PRECISION NOT NULL DEFAULT 0,
+    refilled_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT job_type_limits_max_concurrent_check
+        CHECK (max_concurrent IS NULL OR max_concurrent > 0),
+    CONSTRAINT job_type_limits_rate_check
+        CHECK (rate_per_second IS NULL OR rate_per_second > 0),
+    CONSTRAINT job_type_limits_burst_check
+        CHECK (burst > 0)
+);
+
+
+CREATE TABLE circuit_breakers (
+    job_type TEXT PRIMARY KEY,
+    state TEXT NOT NULL DEFAULT 'closed',
+    failures INTEGER NOT NULL DEFAULT 0,
+    successes INTEGER NOT NULL DEFAULT 0,
+    window_started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    opened_at TIMESTAMPTZ,
+    open_until TIMESTAMPTZ,
+    probe_until TIMESTAMPTZ,
+    failure_rate_threshold DOUBLE PRECISION NOT NULL DEFAULT 0.5,
+    min_requests INTEGER NOT NULL DEFAULT 10,
+    window_seconds INTEGER NOT NULL DEFAULT 60,
+    open_seconds INTEGER NOT NULL DEFAULT 30,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT circuit_breakers_state_check
+        CHECK (state IN ('closed', 'open', 'half_open')),
+    CONSTRAINT circuit_breakers_threshold_check
+        CHECK (failure_rate_threshold > 0 AND failure_rate_threshold <= 1)
+);
+
+
+-- tenant_id is the submitting api key id, '' for jobs submitted without one.
+-- pass is the tenant's virtual finish time: every job a tenant runs moves it
+-- forward by 1 / weight, and the tenant with the lowest pass goes next.
+-- last_start is the start tag of the tenant's last job; the highest one is
+-- the virtual time of the scheduler. Tenants that were idle are brought
+-- forward to it, so they cannot bank credit while they have nothing queued.
+CREATE TABLE tenant_schedule (
+    tenant_id TEXT PRIMARY KEY,
+    weight INTEGER NOT NULL DEFAULT 1,
+    pass DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_start DOUBLE PRECISION NOT NULL DEFAULT 0,
+    CONSTRAINT tenant_schedule_weight_check CHECK (weight > 0)
+);
+
+CREATE INDEX idx_tenant_schedule_pass ON tenant_schedule(pass, tenant_id);
+CREATE INDEX idx_tenant_schedule_last_start ON tenant_schedule(last_start);
+
+-- scope is 'global' (with an empty name), 'queue', 'type' or 'api_key'
+CREATE TABLE backlog_limits (
+    scope TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    max_pending INTEGER NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (scope, name),
+    CONSTRAINT backlog_limits_scope_check
+        CHECK (scope IN ('global', 'queue', 'type', 'api_key', 'organization')),
+    CONSTRAINT backlog_limits_max_pending_check
+        CHECK (max_pending > 0)
+);
+
+-- An 'allow' window repeats weekly: jobs may run on its weekdays (0 is
+-- Sunday, empty means every day) between start_time and end_time in its
+-- timezone. An end_time at or before start_time runs past midnight.
+-- A 'blackout' window is a one-off period, from starts_at to ends_at, in
+-- which jobs may not run.
+CREATE TABLE execution_windows (
+    id BIGSERIAL PRIMARY KEY,
+    scope TEXT NOT NULL,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    weekdays INTEGER[] NOT NULL DEFAULT '{}',
+    start_time TIME,
+    end_time TIME,
+    starts_at TIMESTAMPTZ,
+    ends_at TIMESTAMPTZ,
+    description TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT execution_windows_scope_check
+        CHECK (scope IN ('type', 'queue')),
+    CONSTRAINT execution_windows_kind_check
+        CHECK ((kind = 'allow' AND start_time IS NOT NULL AND end_time IS NOT NULL)
+            OR (kind = 'blackout' AND starts_at IS NOT NULL AND ends_at > starts_at))
+);
+
+CREATE INDEX idx_execution_windows_scope ON execution_windows(scope, name);
+
+-- Workers register their labels at startup and heartbeat while running.
+CREATE TABLE workers (
+    id TEXT PRIMARY KEY,
+    hostname TEXT NOT NULL,
+    labels TEXT[] NOT NULL DEFAULT '{}',
+    handler_versions JSONB NOT NULL DEFAULT '{}',
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- A rollout sends canary_percent of a job type's jobs to workers running
+-- canary_version and the rest to workers running stable_version. It is
+-- rolled back, sending everything to stable_version, once the canary's error
+-- rate exceeds the stable one by more than max_error_rate_increase.
+CREATE TABLE handler_rollouts (
+    job_type TEXT PRIMARY KEY,
+    stable_version TEXT NOT NULL,
+    canary_version TEXT NOT NULL,
+    canary_percent INTEGER NOT NULL,
+    min_jobs INTEGER NOT NULL DEFAULT 50,
+    max_error_rate_increase DOUBLE PRECISION NOT NULL DEFAULT 0.05,
+    state TEXT NOT NULL DEFAULT 'active',
+    reason TEXT,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT handler_rollouts_state_check
+        CHECK (state IN ('active', 'rolled_back')),
+    CONSTRAINT handler_rollouts_canary_percent_check
+        CHECK (canary_percent BETWEEN 0 AND 100)
+);
+
+-- Outcomes per handler version since the job type's rollout started.
+CREATE TABLE handler_version_stats (
+    job_type TEXT NOT NULL,
+    version TEXT NOT NULL,
+    successes BIGINT NOT NULL DEFAULT 0,
+    failures BIGINT NOT NULL DEFAULT 0,
+    total_latency_ms BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (job_type, version)
+);
+
+-- People and services allowed to use the admin API.
+CREATE TABLE admin_principals (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    role TEXT NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT admin_principals_role_check
+        CHECK (role IN ('viewer', 'operator', 'owner'))
+);
+
+-- A principal may hold several tokens at once, so a token can be replaced
+-- before the old one is revoked.
+CREATE TABLE admin_tokens (
+    id TEXT PRIMARY KEY,
+    principal_id TEXT NOT NULL REFERENCES admin_principals(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    token_prefix TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_admin_tokens_principal ON admin_tokens(principal_id);
+
+-- Every admin request that changes something, and who made it.
+CREATE TABLE admin_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    principal TEXT NOT NULL,
+    method TEXT NOT NULL,
+    path TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_admin_audit_log_created ON admin_audit_log(created_at DESC);
+
+-- Client certificate identities, such as dns:billing.internal, that
+-- authenticate as an API key over mutual TLS.
+CREATE TABLE api_key_client_identities (
+    id TEXT PRIMARY KEY,
+    api_key_id TEXT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    identity TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_api_key_client_identities_key ON api_key_client_identities(api_key_id);
+
+-- jobs whose key was deleted fall to the tenant without one
+INSERT INTO tenant_schedule (tenant_id) VALUES ('');
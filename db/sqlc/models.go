@@ -8,26 +8,195 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type AdminAuditLog struct {
+	ID        int64              `json:"id"`
+	Principal string             `json:"principal"`
+	Method    string             `json:"method"`
+	Path      string             `json:"path"`
+	Status    int32              `json:"status"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type AdminPrincipal struct {
+	ID        string             `json:"id"`
+	Name      string             `json:"name"`
+	Role      string             `json:"role"`
+	IsActive  bool               `json:"is_active"`
+	CreatedBy pgtype.Text        `json:"created_by"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt pgtype.Timestamptz `json:"updated_at"`
+}
+
+type AdminToken struct {
+	ID          string             `json:"id"`
+	PrincipalID string             `json:"principal_id"`
+	TokenHash   string             `json:"-"`
+	TokenPrefix string             `json:"token_prefix"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+	LastUsedAt  pgtype.Timestamptz `json:"last_used_at"`
+	RevokedAt   pgtype.Timestamptz `json:"revoked_at"`
+}
+
 type ApiKey struct {
-	ID         string             `json:"id"`
+	ID             string             `json:"id"`
+	Name           string             `json:"name"`
+	KeyHash        string             `json:"-"`
+	CreatedBy      pgtype.Text        `json:"created_by"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+	LastUsedAt     pgtype.Timestamptz `json:"last_used_at"`
+	IsActive       bool               `json:"is_active"`
+	DeferOverLimit bool               `json:"defer_over_limit"`
+	OrganizationID pgtype.Text        `json:"organization_id"`
+	Description    string             `json:"description"`
+	KeyPrefix      string             `json:"key_prefix"`
+	RevokedAt      pgtype.Timestamptz `json:"revoked_at"`
+	ReplacedBy     pgtype.Text        `json:"replaced_by"`
+	Scopes         []string           `json:"scopes"`
+	AllowedTypes   []string           `json:"allowed_types"`
+	AllowedQueues  []string           `json:"allowed_queues"`
+	SigningSecret  pgtype.Text        `json:"-"`
+	AllowedCidrs   []string           `json:"allowed_cidrs"`
+	IpDenials      int64              `json:"ip_denials"`
+	LastIpDenialAt pgtype.Timestamptz `json:"last_ip_denial_at"`
+}
+
+type ApiKeyClientIdentity struct {
+	ID        string             `json:"id"`
+	ApiKeyID  string             `json:"api_key_id"`
+	Identity  string             `json:"identity"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type BacklogLimit struct {
+	Scope      string             `json:"scope"`
 	Name       string             `json:"name"`
-	KeyHash    string             `json:"key_hash"`
-	CreatedBy  pgtype.Text        `json:"created_by"`
-	CreatedAt  pgtype.Timestamptz `json:"created_at"`
-	ExpiresAt  pgtype.Timestamptz `json:"expires_at"`
-	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
-	IsActive   bool               `json:"is_active"`
+	MaxPending int32              `json:"max_pending"`
+	UpdatedAt  pgtype.Timestamptz `json:"updated_at"`
+}
+
+type CircuitBreaker struct {
+	JobType              string             `json:"job_type"`
+	State                string             `json:"state"`
+	Failures             int32              `json:"failures"`
+	Successes            int32              `json:"successes"`
+	WindowStartedAt      pgtype.Timestamptz `json:"window_started_at"`
+	OpenedAt             pgtype.Timestamptz `json:"opened_at"`
+	OpenUntil            pgtype.Timestamptz `json:"open_until"`
+	ProbeUntil           pgtype.Timestamptz `json:"probe_until"`
+	FailureRateThreshold float64            `json:"failure_rate_threshold"`
+	MinRequests          int32              `json:"min_requests"`
+	WindowSeconds        int32              `json:"window_seconds"`
+	OpenSeconds          int32              `json:"open_seconds"`
+	UpdatedAt            pgtype.Timestamptz `json:"updated_at"`
+}
+
+type ExecutionWindow struct {
+	ID          int64              `json:"id"`
+	Scope       string             `json:"scope"`
+	Name        string             `json:"name"`
+	Kind        string             `json:"kind"`
+	Timezone    string             `json:"timezone"`
+	Weekdays    []int32            `json:"weekdays"`
+	StartTime   pgtype.Time        `json:"start_time"`
+	EndTime     pgtype.Time        `json:"end_time"`
+	StartsAt    pgtype.Timestamptz `json:"starts_at"`
+	EndsAt      pgtype.Timestamptz `json:"ends_at"`
+	Description pgtype.Text        `json:"description"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+}
+
+type HandlerRollout struct {
+	JobType              string             `json:"job_type"`
+	StableVersion        string             `json:"stable_version"`
+	CanaryVersion        string             `json:"canary_version"`
+	CanaryPercent        int32              `json:"canary_percent"`
+	MinJobs              int32              `json:"min_jobs"`
+	MaxErrorRateIncrease float64            `json:"max_error_rate_increase"`
+	State                string             `json:"state"`
+	Reason               pgtype.Text        `json:"reason"`
+	StartedAt            pgtype.Timestamptz `json:"started_at"`
+	UpdatedAt            pgtype.Timestamptz `json:"updated_at"`
+}
+
+type HandlerVersionStat struct {
+	JobType        string `json:"job_type"`
+	Version        string `json:"version"`
+	Successes      int64  `json:"successes"`
+	Failures       int64  `json:"failures"`
+	TotalLatencyMs int64  `json:"total_latency_ms"`
 }
 
 type Job struct {
-	ID           string             `json:"id"`
-	Type         string             `json:"type"`
-	Payload      []byte             `json:"payload"`
-	Status       string             `json:"status"`
-	Attempts     int32              `json:"attempts"`
-	MaxAttempts  int32              `json:"max_attempts"`
-	ErrorMessage pgtype.Text        `json:"error_message"`
-	ScheduledAt  pgtype.Timestamptz `json:"scheduled_at"`
-	CreatedAt    pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt    pgtype.Timestamptz `json:"updated_at"`
+	ID             string             `json:"id"`
+	Type           string             `json:"type"`
+	Payload        []byte             `json:"payload"`
+	Status         string             `json:"status"`
+	Attempts       int32              `json:"attempts"`
+	MaxAttempts    int32              `json:"max_attempts"`
+	ErrorMessage   pgtype.Text        `json:"error_message"`
+	ScheduledAt    pgtype.Timestamptz `json:"scheduled_at"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt      pgtype.Timestamptz `json:"updated_at"`
+	LeaseToken     pgtype.Text        `json:"lease_token"`
+	LeaseExpiresAt pgtype.Timestamptz `json:"lease_expires_at"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+	GroupKey       pgtype.Text        `json:"group_key"`
+	EnqueueSeq     pgtype.Int8        `json:"enqueue_seq"`
+	CoalesceKey    pgtype.Text        `json:"coalesce_key"`
+	CoalesceMode   pgtype.Text        `json:"coalesce_mode"`
+	CoalesceUntil  pgtype.Timestamptz `json:"coalesce_until"`
+	LostAttempts   int32              `json:"lost_attempts"`
+	ApiKeyID       pgtype.Text        `json:"api_key_id"`
+	Queue          string             `json:"queue"`
+	WindowHeld     bool               `json:"window_held"`
+	Requires       []string           `json:"requires"`
+	HandlerVersion pgtype.Text        `json:"handler_version"`
+	Tags           []string           `json:"tags"`
+	OrganizationID pgtype.Text        `json:"organization_id"`
+}
+
+type JobSideEffect struct {
+	Key       string             `json:"key"`
+	JobID     string             `json:"job_id"`
+	Outcome   []byte             `json:"outcome"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type JobTypeLimit struct {
+	JobType       string             `json:"job_type"`
+	MaxConcurrent pgtype.Int4        `json:"max_concurrent"`
+	RatePerSecond pgtype.Float8      `json:"rate_per_second"`
+	Burst         int32              `json:"burst"`
+	Tokens        float64            `json:"tokens"`
+	RefilledAt    pgtype.Timestamptz `json:"refilled_at"`
+	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
+}
+
+type Organization struct {
+	ID             string             `json:"id"`
+	Name           string             `json:"name"`
+	RatePerSecond  pgtype.Float8      `json:"rate_per_second"`
+	Burst          pgtype.Int4        `json:"burst"`
+	DeferOverLimit bool               `json:"defer_over_limit"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt      pgtype.Timestamptz `json:"updated_at"`
+}
+
+type TenantSchedule struct {
+	TenantID  string             `json:"tenant_id"`
+	Weight    int32              `json:"weight"`
+	Pass      float64            `json:"pass"`
+	UpdatedAt pgtype.Timestamptz `json:"updated_at"`
+	LastStart float64            `json:"last_start"`
+}
+
+type Worker struct {
+	ID              string             `json:"id"`
+	Hostname        string             `json:"hostname"`
+	Labels          []string           `json:"labels"`
+	HandlerVersions []byte             `json:"handler_versions"`
+	StartedAt       pgtype.Timestamptz `json:"started_at"`
+	LastSeenAt      pgtype.Timestamptz `json:"last_seen_at"`
 }
@@ -6,21 +6,161 @@ package db
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Querier interface {
-	CompleteJob(ctx context.Context, id string) error
+	// Returns no rows if the identity already belongs to a key.
+	AddClientIdentity(ctx context.Context, arg AddClientIdentityParams) (ApiKeyClientIdentity, error)
+	// Jobs a key submitted outside any organization follow it into the one it
+	// joins, so they stay visible there after the key is rotated.
+	AdoptAPIKeyJobs(ctx context.Context, arg AdoptAPIKeyJobsParams) error
+	CancelJob(ctx context.Context, arg CancelJobParams) (Job, error)
+	// The job starts at the tenant's pass, or at the scheduler clock if the
+	// tenant fell behind it while idle, and finishes 1 / weight later.
+	ChargeTenant(ctx context.Context, tenantID string) (TenantSchedule, error)
+	// Folds a submission into a pending job. The job keeps its id, takes the
+	// newest payload (or the merged payload in 'merge' mode) and has its run
+	// time pushed out, but never past the coalesce_until of the first
+	// submission. A submission the rate limiter deferred pushes both out to
+	// deferred_until, so the deferral is not cut short by the first one's cap.
+	CoalesceJob(ctx context.Context, arg CoalesceJobParams) (Job, error)
+	CompleteJob(ctx context.Context, arg CompleteJobParams) (int64, error)
+	ConfigureCircuitBreaker(ctx context.Context, arg ConfigureCircuitBreakerParams) (CircuitBreaker, error)
+	CountActiveAdminOwners(ctx context.Context) (int64, error)
+	CountExpiredJobsSince(ctx context.Context, updatedAt pgtype.Timestamptz) (int64, error)
 	CountJobsByStatus(ctx context.Context, status string) (int64, error)
+	CountJobsGroupByStatus(ctx context.Context) ([]CountJobsGroupByStatusRow, error)
+	CountPendingJobsByAPIKey(ctx context.Context, apiKeyID pgtype.Text) (int64, error)
+	CountPendingJobsByOrganization(ctx context.Context, organizationID pgtype.Text) (int64, error)
+	CountPendingJobsByQueue(ctx context.Context, queue string) (int64, error)
+	CountPendingJobsByType(ctx context.Context, type_ string) (int64, error)
+	CountRunningJobsOfType(ctx context.Context, arg CountRunningJobsOfTypeParams) (int64, error)
+	// A job whose handler crashed the worker goroutine. It goes back to pending,
+	// or to quarantined once it has crashed quarantine_threshold times.
+	CrashJob(ctx context.Context, arg CrashJobParams) (Job, error)
 	CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (ApiKey, error)
+	CreateAdminPrincipal(ctx context.Context, arg CreateAdminPrincipalParams) (AdminPrincipal, error)
+	CreateAdminToken(ctx context.Context, arg CreateAdminTokenParams) (AdminToken, error)
+	CreateCoalescedJob(ctx context.Context, arg CreateCoalescedJobParams) (Job, error)
+	CreateExecutionWindow(ctx context.Context, arg CreateExecutionWindowParams) (ExecutionWindow, error)
 	CreateJob(ctx context.Context, arg CreateJobParams) (Job, error)
-	DeactivateAPIKey(ctx context.Context, id string) error
-	DequeueJob(ctx context.Context) (Job, error)
-	FailJob(ctx context.Context, arg FailJobParams) error
+	DeactivateAPIKey(ctx context.Context, id string) (int64, error)
+	DeferJob(ctx context.Context, arg DeferJobParams) (int64, error)
+	DeleteBacklogLimit(ctx context.Context, arg DeleteBacklogLimitParams) error
+	DeleteClientIdentity(ctx context.Context, arg DeleteClientIdentityParams) (int64, error)
+	DeleteExecutionWindow(ctx context.Context, id int64) (ExecutionWindow, error)
+	DeleteHandlerRollout(ctx context.Context, jobType string) error
+	DeleteJobTypeLimit(ctx context.Context, jobType string) error
+	// Keys of a deleted organization are left without one, and its backlog
+	// limit goes with it.
+	DeleteOrganization(ctx context.Context, id string) error
+	// A job with a group_key is only eligible while no other job in its group
+	// is processing or queued ahead of it, so each group runs one job at a time
+	// in enqueue order. Job types whose circuit breaker is open, or half open
+	// with a probe in flight, are skipped, as are the excluded_types the worker
+	// has no free capacity for. Tenants are served in order of their pass in
+	// tenant_schedule (see ChargeTenant), oldest job first within a tenant.
+	// Reclaiming a job whose lease ran out counts as a
+	// lost attempt; jobs about to reach the quarantine threshold are left for
+	// QuarantineLostJobs.
+	DequeueJob(ctx context.Context, arg DequeueJobParams) (Job, error)
+	EnsureCircuitBreaker(ctx context.Context, jobType string) error
+	// Moves a tenant forward by one job: its pass becomes its start tag, the
+	// later of its old pass and the scheduler clock, plus 1 / weight.
+	// A new tenant starts at the scheduler clock, like one that was idle.
+	EnsureTenant(ctx context.Context, tenantID string) error
+	ExpireJobs(ctx context.Context) (int64, error)
+	FailJob(ctx context.Context, arg FailJobParams) (int64, error)
+	GetAPIKey(ctx context.Context, id string) (ApiKey, error)
 	GetAPIKeyByHash(ctx context.Context, keyHash string) (ApiKey, error)
+	GetAPIKeyForUpdate(ctx context.Context, id string) (ApiKey, error)
+	GetAPIKeysByClientIdentities(ctx context.Context, identities []string) ([]ApiKey, error)
+	GetAdminPrincipalForUpdate(ctx context.Context, id string) (AdminPrincipal, error)
+	GetAdminTokenByHash(ctx context.Context, tokenHash string) (GetAdminTokenByHashRow, error)
+	GetCircuitBreakerForUpdate(ctx context.Context, jobType string) (CircuitBreaker, error)
+	GetHandlerRolloutForUpdate(ctx context.Context, jobType string) (HandlerRollout, error)
+	GetHandlerVersionStat(ctx context.Context, arg GetHandlerVersionStatParams) (HandlerVersionStat, error)
 	GetJob(ctx context.Context, id string) (Job, error)
-	ListAPIKeys(ctx context.Context) ([]ApiKey, error)
+	GetJobForUpdate(ctx context.Context, id string) (Job, error)
+	GetJobTypeLimitForUpdate(ctx context.Context, jobType string) (JobTypeLimit, error)
+	GetOrganization(ctx context.Context, id string) (Organization, error)
+	// The owner is the submitting key, or the organization for callers without
+	// one, so coalescing never folds one tenant's job into another's. A retried
+	// job may be pending next to a newer one; the newest takes submissions.
+	GetPendingCoalescedJobForUpdate(ctx context.Context, arg GetPendingCoalescedJobForUpdateParams) (Job, error)
+	GetSideEffect(ctx context.Context, key string) (JobSideEffect, error)
+	// Puts a job claimed outside its execution windows back to pending until
+	// the next window opens. Attempts are untouched.
+	HoldJobForWindow(ctx context.Context, arg HoldJobForWindowParams) (int64, error)
+	ListAPIKeys(ctx context.Context, organizationID pgtype.Text) ([]ApiKey, error)
+	ListAdminActions(ctx context.Context, arg ListAdminActionsParams) ([]AdminAuditLog, error)
+	ListAdminPrincipals(ctx context.Context) ([]AdminPrincipal, error)
+	ListAdminTokens(ctx context.Context, principalID string) ([]ListAdminTokensRow, error)
+	ListBacklogLimits(ctx context.Context) ([]BacklogLimit, error)
+	ListCircuitBreakers(ctx context.Context) ([]CircuitBreaker, error)
+	ListClientIdentities(ctx context.Context, apiKeyID string) ([]ApiKeyClientIdentity, error)
+	ListExecutionWindows(ctx context.Context) ([]ExecutionWindow, error)
+	ListExecutionWindowsForJob(ctx context.Context, arg ListExecutionWindowsForJobParams) ([]ExecutionWindow, error)
+	ListHandlerRollouts(ctx context.Context) ([]HandlerRollout, error)
+	ListHandlerVersionStats(ctx context.Context) ([]HandlerVersionStat, error)
+	ListJobTypeLimits(ctx context.Context) ([]JobTypeLimit, error)
 	ListJobs(ctx context.Context, arg ListJobsParams) ([]Job, error)
+	ListOrganizations(ctx context.Context) ([]Organization, error)
+	ListTenantSchedule(ctx context.Context) ([]TenantSchedule, error)
+	ListWindowHeldJobGroups(ctx context.Context, arg ListWindowHeldJobGroupsParams) ([]ListWindowHeldJobGroupsRow, error)
+	ListWorkers(ctx context.Context, lastSeenAt pgtype.Timestamptz) ([]Worker, error)
+	// Locks the active owners, so two requests cannot demote the last two
+	// owners at once.
+	LockActiveAdminOwners(ctx context.Context) ([]string, error)
+	// Serializes the coalescing submissions of one type, key and owner, so two
+	// of them cannot both miss the pending job and create one each.
+	LockCoalesceKey(ctx context.Context, lockKey string) error
+	MoveClientIdentities(ctx context.Context, arg MoveClientIdentitiesParams) error
+	// Jobs whose lease ran out without an outcome being recorded most likely
+	// crashed or hung the worker running them. Once that has happened
+	// quarantine_threshold times they are quarantined instead of reclaimed.
+	QuarantineLostJobs(ctx context.Context, quarantineThreshold int32) ([]Job, error)
+	RecordAPIKeyIPDenial(ctx context.Context, id string) error
+	RecordAdminAction(ctx context.Context, arg RecordAdminActionParams) error
+	RecordHandlerVersionOutcome(ctx context.Context, arg RecordHandlerVersionOutcomeParams) (HandlerVersionStat, error)
+	RecordSideEffect(ctx context.Context, arg RecordSideEffectParams) error
+	RegisterWorker(ctx context.Context, arg RegisterWorkerParams) (Worker, error)
+	ReleaseQuarantinedJob(ctx context.Context, id string) (Job, error)
+	RescheduleWindowHeldJobs(ctx context.Context, arg RescheduleWindowHeldJobsParams) (int64, error)
+	ResetCircuitBreaker(ctx context.Context, jobType string) (CircuitBreaker, error)
+	ResetHandlerVersionStats(ctx context.Context, jobType string) error
+	// Lets a rotated key keep working until overlap_ends, or its own expiry if
+	// that comes first.
+	RetireRotatedAPIKey(ctx context.Context, arg RetireRotatedAPIKeyParams) (ApiKey, error)
+	RevokeAdminToken(ctx context.Context, arg RevokeAdminTokenParams) (int64, error)
+	RollBackHandlerRollout(ctx context.Context, arg RollBackHandlerRolloutParams) (HandlerRollout, error)
+	// Lists jobs newest first. Every filter is optional; the page starts after
+	// the (cursor_created_at, cursor_id) of the last job of the previous page.
+	// With owner_api_key_id set only the jobs of that key, or of
+	// owner_organization_id, are listed, as for Owner.Owns.
+	SearchJobs(ctx context.Context, arg SearchJobsParams) ([]Job, error)
+	SetAPIKeyAllowedCIDRs(ctx context.Context, arg SetAPIKeyAllowedCIDRsParams) (ApiKey, error)
+	SetAPIKeyDeferOverLimit(ctx context.Context, arg SetAPIKeyDeferOverLimitParams) (ApiKey, error)
+	SetAPIKeyExpiry(ctx context.Context, arg SetAPIKeyExpiryParams) (ApiKey, error)
+	SetAPIKeyOrganization(ctx context.Context, arg SetAPIKeyOrganizationParams) (ApiKey, error)
+	SetAPIKeyScopes(ctx context.Context, arg SetAPIKeyScopesParams) (ApiKey, error)
+	// Sets, replaces or, with NULL, removes the signing secret of an active key.
+	SetAPIKeySigningSecret(ctx context.Context, arg SetAPIKeySigningSecretParams) (ApiKey, error)
+	SetTenantWeight(ctx context.Context, arg SetTenantWeightParams) (TenantSchedule, error)
+	TouchAdminToken(ctx context.Context, id string) error
+	// Edits the metadata of a key; a NULL leaves the field as it is.
+	UpdateAPIKeyMetadata(ctx context.Context, arg UpdateAPIKeyMetadataParams) (ApiKey, error)
+	// A NULL leaves the field as it is.
+	UpdateAdminPrincipal(ctx context.Context, arg UpdateAdminPrincipalParams) (AdminPrincipal, error)
+	UpdateCircuitBreaker(ctx context.Context, arg UpdateCircuitBreakerParams) error
+	UpdateJobTypeTokens(ctx context.Context, arg UpdateJobTypeTokensParams) error
 	UpdateLastUsed(ctx context.Context, id string) error
+	UpsertBacklogLimit(ctx context.Context, arg UpsertBacklogLimitParams) (BacklogLimit, error)
+	UpsertHandlerRollout(ctx context.Context, arg UpsertHandlerRolloutParams) (HandlerRollout, error)
+	UpsertJobTypeLimit(ctx context.Context, arg UpsertJobTypeLimitParams) (JobTypeLimit, error)
+	UpsertOrganization(ctx context.Context, arg UpsertOrganizationParams) (Organization, error)
 }
 
 var _ Querier = (*Queries)(nil)
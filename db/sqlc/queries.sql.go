@@ -11,94 +11,70 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const completeJob = `-- name: CompleteJob :exec
-UPDATE jobs
-SET 
-    status = 'completed',
-    updated_at = NOW()
-WHERE id = $1
+const addClientIdentity = `-- name: AddClientIdentity :one
+INSERT INTO api_key_client_identities (id, api_key_id, identity)
+VALUES ($1, $2, $3)
+ON CONFLICT (identity) DO NOTHING
+RETURNING id, api_key_id, identity, created_at
 `
 
-func (q *Queries) CompleteJob(ctx context.Context, id string) error {
-	_, err := q.db.Exec(ctx, completeJob, id)
-	return err
+type AddClientIdentityParams struct {
+	ID       string `json:"id"`
+	ApiKeyID string `json:"api_key_id"`
+	Identity string `json:"identity"`
 }
 
-const countJobsByStatus = `-- name: CountJobsByStatus :one
-SELECT COUNT(*) FROM jobs
-WHERE status = $1
-`
-
-func (q *Queries) CountJobsByStatus(ctx context.Context, status string) (int64, error) {
-	row := q.db.QueryRow(ctx, countJobsByStatus, status)
-	var count int64
-	err := row.Scan(&count)
-	return count, err
+// Returns no rows if the identity already belongs to a key.
+func (q *Queries) AddClientIdentity(ctx context.Context, arg AddClientIdentityParams) (ApiKeyClientIdentity, error) {
+	row := q.db.QueryRow(ctx, addClientIdentity, arg.ID, arg.ApiKeyID, arg.Identity)
+	var i ApiKeyClientIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.ApiKeyID,
+		&i.Identity,
+		&i.CreatedAt,
+	)
+	return i, err
 }
 
-const createAPIKey = `-- name: CreateAPIKey :one
-INSERT INTO api_keys (id, name, key_hash, created_by)
-VALUES ($1, $2, $3, $4)
-RETURNING id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active
+const adoptAPIKeyJobs = `-- name: AdoptAPIKeyJobs :exec
+UPDATE jobs
+SET organization_id = $2
+WHERE api_key_id = $1
+    AND organization_id IS NULL
 `
 
-type CreateAPIKeyParams struct {
-	ID        string      `json:"id"`
-	Name      string      `json:"name"`
-	KeyHash   string      `json:"key_hash"`
-	CreatedBy pgtype.Text `json:"created_by"`
+type AdoptAPIKeyJobsParams struct {
+	ApiKeyID       pgtype.Text `json:"api_key_id"`
+	OrganizationID pgtype.Text `json:"organization_id"`
 }
 
-func (q *Queries) CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (ApiKey, error) {
-	row := q.db.QueryRow(ctx, createAPIKey,
-		arg.ID,
-		arg.Name,
-		arg.KeyHash,
-		arg.CreatedBy,
-	)
-	var i ApiKey
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.KeyHash,
-		&i.CreatedBy,
-		&i.CreatedAt,
-		&i.ExpiresAt,
-		&i.LastUsedAt,
-		&i.IsActive,
-	)
-	return i, err
+// Jobs a key submitted outside any organization follow it into the one it
+// joins, so they stay visible there after the key is rotated.
+func (q *Queries) AdoptAPIKeyJobs(ctx context.Context, arg AdoptAPIKeyJobsParams) error {
+	_, err := q.db.Exec(ctx, adoptAPIKeyJobs, arg.ApiKeyID, arg.OrganizationID)
+	return err
 }
 
-const createJob = `-- name: CreateJob :one
-INSERT INTO jobs (
-    id,
-    type,
-    payload,
-    status,
-    max_attempts
-) VALUES (
-    $1, $2, $3, $4, $5
-)
-RETURNING id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at
+const cancelJob = `-- name: CancelJob :one
+UPDATE jobs
+SET 
+    status = 'cancelled',
+    error_message = $2,
+    window_held = FALSE,
+    updated_at = NOW()
+WHERE id = $1
+    AND status IN ('pending', 'quarantined')
+RETURNING id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id
 `
 
-type CreateJobParams struct {
-	ID          string `json:"id"`
-	Type        string `json:"type"`
-	Payload     []byte `json:"payload"`
-	Status      string `json:"status"`
-	MaxAttempts int32  `json:"max_attempts"`
+type CancelJobParams struct {
+	ID           string      `json:"id"`
+	ErrorMessage pgtype.Text `json:"error_message"`
 }
 
-func (q *Queries) CreateJob(ctx context.Context, arg CreateJobParams) (Job, error) {
-	row := q.db.QueryRow(ctx, createJob,
-		arg.ID,
-		arg.Type,
-		arg.Payload,
-		arg.Status,
-		arg.MaxAttempts,
-	)
+func (q *Queries) CancelJob(ctx context.Context, arg CancelJobParams) (Job, error) {
+	row := q.db.QueryRow(ctx, cancelJob, arg.ID, arg.ErrorMessage)
 	var i Job
 	err := row.Scan(
 		&i.ID,
@@ -111,40 +87,97 @@ func (q *Queries) CreateJob(ctx context.Context, arg CreateJobParams) (Job, erro
 		&i.ScheduledAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.LeaseToken,
+		&i.LeaseExpiresAt,
+		&i.ExpiresAt,
+		&i.GroupKey,
+		&i.EnqueueSeq,
+		&i.CoalesceKey,
+		&i.CoalesceMode,
+		&i.CoalesceUntil,
+		&i.LostAttempts,
+		&i.ApiKeyID,
+		&i.Queue,
+		&i.WindowHeld,
+		&i.Requires,
+		&i.HandlerVersion,
+		&i.Tags,
+		&i.OrganizationID,
 	)
 	return i, err
 }
 
-const deactivateAPIKey = `-- name: DeactivateAPIKey :exec
-UPDATE api_keys
-SET is_active = false
-WHERE id = $1
+const chargeTenant = `-- name: ChargeTenant :one
+UPDATE tenant_schedule
+SET last_start = GREATEST(pass, (SELECT COALESCE(MAX(s.last_start), 0) FROM tenant_schedule s)),
+    pass = GREATEST(pass, (SELECT COALESCE(MAX(s.last_start), 0) FROM tenant_schedule s)) + 1.0 / weight,
+    updated_at = NOW()
+WHERE tenant_id = $1::text
+RETURNING tenant_id, weight, pass, updated_at, last_start
 `
 
-func (q *Queries) DeactivateAPIKey(ctx context.Context, id string) error {
-	_, err := q.db.Exec(ctx, deactivateAPIKey, id)
-	return err
+// The job starts at the tenant's pass, or at the scheduler clock if the
+// tenant fell behind it while idle, and finishes 1 / weight later.
+func (q *Queries) ChargeTenant(ctx context.Context, tenantID string) (TenantSchedule, error) {
+	row := q.db.QueryRow(ctx, chargeTenant, tenantID)
+	var i TenantSchedule
+	err := row.Scan(
+		&i.TenantID,
+		&i.Weight,
+		&i.Pass,
+		&i.UpdatedAt,
+		&i.LastStart,
+	)
+	return i, err
 }
 
-const dequeueJob = `-- name: DequeueJob :one
+const coalesceJob = `-- name: CoalesceJob :one
 UPDATE jobs
 SET 
-    status = 'processing',
+    payload = CASE
+        WHEN $1::text = 'merge' THEN payload || $2::jsonb
+        ELSE $2::jsonb
+    END,
+    expires_at = $3,
+    requires = $4,
+    tags = $5,
+    scheduled_at = GREATEST(
+        LEAST($6::timestamptz, coalesce_until),
+        $7::timestamptz
+    ),
+    coalesce_until = GREATEST(coalesce_until, $7::timestamptz),
     updated_at = NOW()
-WHERE id = (
-    SELECT id
-    FROM jobs
-    WHERE status = 'pending'
-        AND scheduled_at <= NOW()
-    ORDER BY scheduled_at ASC
-    LIMIT 1
-    FOR UPDATE SKIP LOCKED
-)
-RETURNING id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at
+WHERE id = $8
+RETURNING id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id
 `
 
-func (q *Queries) DequeueJob(ctx context.Context) (Job, error) {
-	row := q.db.QueryRow(ctx, dequeueJob)
+type CoalesceJobParams struct {
+	CoalesceMode  string             `json:"coalesce_mode"`
+	Payload       []byte             `json:"payload"`
+	ExpiresAt     pgtype.Timestamptz `json:"expires_at"`
+	Requires      []string           `json:"requires"`
+	Tags          []string           `json:"tags"`
+	ScheduledAt   pgtype.Timestamptz `json:"scheduled_at"`
+	DeferredUntil pgtype.Timestamptz `json:"deferred_until"`
+	ID            string             `json:"id"`
+}
+
+// Folds a submission into a pending job. The job keeps its id, takes the
+// newest payload (or the merged payload in 'merge' mode) and has its run
+// time pushed out, but never past the coalesce_until of the first
+// submission. A submission the rate limiter deferred pushes both out to
+// deferred_until, so the deferral is not cut short by the first one's cap.
+func (q *Queries) CoalesceJob(ctx context.Context, arg CoalesceJobParams) (Job, error) {
+	row := q.db.QueryRow(ctx, coalesceJob,
+		arg.CoalesceMode,
+		arg.Payload,
+		arg.ExpiresAt,
+		arg.Requires,
+		arg.Tags,
+		arg.ScheduledAt,
+		arg.DeferredUntil,
+		arg.ID,
+	)
 	var i Job
 	err := row.Scan(
 		&i.ID,
@@ -157,152 +190,162 @@ func (q *Queries) DequeueJob(ctx context.Context) (Job, error) {
 		&i.ScheduledAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.LeaseToken,
+		&i.LeaseExpiresAt,
+		&i.ExpiresAt,
+		&i.GroupKey,
+		&i.EnqueueSeq,
+		&i.CoalesceKey,
+		&i.CoalesceMode,
+		&i.CoalesceUntil,
+		&i.LostAttempts,
+		&i.ApiKeyID,
+		&i.Queue,
+		&i.WindowHeld,
+		&i.Requires,
+		&i.HandlerVersion,
+		&i.Tags,
+		&i.OrganizationID,
 	)
 	return i, err
 }
 
-const failJob = `-- name: FailJob :exec
+const completeJob = `-- name: CompleteJob :execrows
 UPDATE jobs
 SET 
-    status = $2,
-    attempts = $3,
-    error_message = $4,
-    scheduled_at = $5,
+    status = 'completed',
+    lease_token = NULL,
+    lease_expires_at = NULL,
     updated_at = NOW()
 WHERE id = $1
+    AND status = 'processing'
+    AND lease_token = $2
 `
 
-type FailJobParams struct {
-	ID           string             `json:"id"`
-	Status       string             `json:"status"`
-	Attempts     int32              `json:"attempts"`
-	ErrorMessage pgtype.Text        `json:"error_message"`
-	ScheduledAt  pgtype.Timestamptz `json:"scheduled_at"`
+type CompleteJobParams struct {
+	ID         string      `json:"id"`
+	LeaseToken pgtype.Text `json:"lease_token"`
 }
 
-func (q *Queries) FailJob(ctx context.Context, arg FailJobParams) error {
-	_, err := q.db.Exec(ctx, failJob,
-		arg.ID,
-		arg.Status,
-		arg.Attempts,
-		arg.ErrorMessage,
-		arg.ScheduledAt,
-	)
-	return err
+func (q *Queries) CompleteJob(ctx context.Context, arg CompleteJobParams) (int64, error) {
+	result, err := q.db.Exec(ctx, completeJob, arg.ID, arg.LeaseToken)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-const getAPIKeyByHash = `-- name: GetAPIKeyByHash :one
-SELECT id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active FROM api_keys
-WHERE key_hash = $1 AND is_active = true
+const configureCircuitBreaker = `-- name: ConfigureCircuitBreaker :one
+INSERT INTO circuit_breakers (
+    job_type,
+    failure_rate_threshold,
+    min_requests,
+    window_seconds,
+    open_seconds
+) VALUES (
+    $1, $2, $3, $4, $5
+)
+ON CONFLICT (job_type) DO UPDATE SET
+    failure_rate_threshold = EXCLUDED.failure_rate_threshold,
+    min_requests = EXCLUDED.min_requests,
+    window_seconds = EXCLUDED.window_seconds,
+    open_seconds = EXCLUDED.open_seconds,
+    updated_at = NOW()
+RETURNING job_type, state, failures, successes, window_started_at, opened_at, open_until, probe_until, failure_rate_threshold, min_requests, window_seconds, open_seconds, updated_at
 `
 
-func (q *Queries) GetAPIKeyByHash(ctx context.Context, keyHash string) (ApiKey, error) {
-	row := q.db.QueryRow(ctx, getAPIKeyByHash, keyHash)
-	var i ApiKey
+type ConfigureCircuitBreakerParams struct {
+	JobType              string  `json:"job_type"`
+	FailureRateThreshold float64 `json:"failure_rate_threshold"`
+	MinRequests          int32   `json:"min_requests"`
+	WindowSeconds        int32   `json:"window_seconds"`
+	OpenSeconds          int32   `json:"open_seconds"`
+}
+
+func (q *Queries) ConfigureCircuitBreaker(ctx context.Context, arg ConfigureCircuitBreakerParams) (CircuitBreaker, error) {
+	row := q.db.QueryRow(ctx, configureCircuitBreaker,
+		arg.JobType,
+		arg.FailureRateThreshold,
+		arg.MinRequests,
+		arg.WindowSeconds,
+		arg.OpenSeconds,
+	)
+	var i CircuitBreaker
 	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.KeyHash,
-		&i.CreatedBy,
-		&i.CreatedAt,
-		&i.ExpiresAt,
-		&i.LastUsedAt,
-		&i.IsActive,
+		&i.JobType,
+		&i.State,
+		&i.Failures,
+		&i.Successes,
+		&i.WindowStartedAt,
+		&i.OpenedAt,
+		&i.OpenUntil,
+		&i.ProbeUntil,
+		&i.FailureRateThreshold,
+		&i.MinRequests,
+		&i.WindowSeconds,
+		&i.OpenSeconds,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const getJob = `-- name: GetJob :one
-SELECT id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at FROM jobs
-WHERE id = $1
+const countActiveAdminOwners = `-- name: CountActiveAdminOwners :one
+SELECT COUNT(*) FROM admin_principals
+WHERE role = 'owner' AND is_active = true
 `
 
-func (q *Queries) GetJob(ctx context.Context, id string) (Job, error) {
-	row := q.db.QueryRow(ctx, getJob, id)
-	var i Job
-	err := row.Scan(
-		&i.ID,
-		&i.Type,
-		&i.Payload,
-		&i.Status,
-		&i.Attempts,
-		&i.MaxAttempts,
-		&i.ErrorMessage,
-		&i.ScheduledAt,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-	)
-	return i, err
+func (q *Queries) CountActiveAdminOwners(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countActiveAdminOwners)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
 }
 
-const listAPIKeys = `-- name: ListAPIKeys :many
-SELECT id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active FROM api_keys
-ORDER BY created_at DESC
+const countExpiredJobsSince = `-- name: CountExpiredJobsSince :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'expired'
+    AND updated_at >= $1
 `
 
-func (q *Queries) ListAPIKeys(ctx context.Context) ([]ApiKey, error) {
-	rows, err := q.db.Query(ctx, listAPIKeys)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	items := []ApiKey{}
-	for rows.Next() {
-		var i ApiKey
-		if err := rows.Scan(
-			&i.ID,
-			&i.Name,
-			&i.KeyHash,
-			&i.CreatedBy,
-			&i.CreatedAt,
-			&i.ExpiresAt,
-			&i.LastUsedAt,
-			&i.IsActive,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+func (q *Queries) CountExpiredJobsSince(ctx context.Context, updatedAt pgtype.Timestamptz) (int64, error) {
+	row := q.db.QueryRow(ctx, countExpiredJobsSince, updatedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
 }
 
-const listJobs = `-- name: ListJobs :many
-SELECT id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at FROM jobs
+const countJobsByStatus = `-- name: CountJobsByStatus :one
+SELECT COUNT(*) FROM jobs
 WHERE status = $1
-ORDER BY created_at DESC
-LIMIT $2 OFFSET $3
 `
 
-type ListJobsParams struct {
+func (q *Queries) CountJobsByStatus(ctx context.Context, status string) (int64, error) {
+	row := q.db.QueryRow(ctx, countJobsByStatus, status)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countJobsGroupByStatus = `-- name: CountJobsGroupByStatus :many
+SELECT status, COUNT(*) AS count FROM jobs
+GROUP BY status
+`
+
+type CountJobsGroupByStatusRow struct {
 	Status string `json:"status"`
-	Limit  int32  `json:"limit"`
-	Offset int32  `json:"offset"`
+	Count  int64  `json:"count"`
 }
 
-func (q *Queries) ListJobs(ctx context.Context, arg ListJobsParams) ([]Job, error) {
-	rows, err := q.db.Query(ctx, listJobs, arg.Status, arg.Limit, arg.Offset)
+func (q *Queries) CountJobsGroupByStatus(ctx context.Context) ([]CountJobsGroupByStatusRow, error) {
+	rows, err := q.db.Query(ctx, countJobsGroupByStatus)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Job{}
+	items := []CountJobsGroupByStatusRow{}
 	for rows.Next() {
-		var i Job
-		if err := rows.Scan(
-			&i.ID,
-			&i.Type,
-			&i.Payload,
-			&i.Status,
-			&i.Attempts,
-			&i.MaxAttempts,
-			&i.ErrorMessage,
-			&i.ScheduledAt,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-		); err != nil {
+		var i CountJobsGroupByStatusRow
+		if err := rows.Scan(&i.Status, &i.Count); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -313,13 +356,3208 @@ func (q *Queries) ListJobs(ctx context.Context, arg ListJobsParams) ([]Job, erro
 	return items, nil
 }
 
-const updateLastUsed = `-- name: UpdateLastUsed :exec
-UPDATE api_keys
-SET last_used_at = NOW()
-WHERE id = $1
+const countPendingJobsByAPIKey = `-- name: CountPendingJobsByAPIKey :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'pending'
+    AND api_key_id = $1
 `
 
-func (q *Queries) UpdateLastUsed(ctx context.Context, id string) error {
-	_, err := q.db.Exec(ctx, updateLastUsed, id)
-	return err
+func (q *Queries) CountPendingJobsByAPIKey(ctx context.Context, apiKeyID pgtype.Text) (int64, error) {
+	row := q.db.QueryRow(ctx, countPendingJobsByAPIKey, apiKeyID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPendingJobsByOrganization = `-- name: CountPendingJobsByOrganization :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'pending'
+    AND organization_id = $1
+`
+
+func (q *Queries) CountPendingJobsByOrganization(ctx context.Context, organizationID pgtype.Text) (int64, error) {
+	row := q.db.QueryRow(ctx, countPendingJobsByOrganization, organizationID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPendingJobsByQueue = `-- name: CountPendingJobsByQueue :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'pending'
+    AND queue = $1
+`
+
+func (q *Queries) CountPendingJobsByQueue(ctx context.Context, queue string) (int64, error) {
+	row := q.db.QueryRow(ctx, countPendingJobsByQueue, queue)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPendingJobsByType = `-- name: CountPendingJobsByType :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'pending'
+    AND type = $1
+`
+
+func (q *Queries) CountPendingJobsByType(ctx context.Context, type_ string) (int64, error) {
+	row := q.db.QueryRow(ctx, countPendingJobsByType, type_)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countRunningJobsOfType = `-- name: CountRunningJobsOfType :one
+SELECT COUNT(*) FROM jobs
+WHERE type = $1
+    AND id <> $2
+    AND status = 'processing'
+    AND lease_expires_at > NOW()
+`
+
+type CountRunningJobsOfTypeParams struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func (q *Queries) CountRunningJobsOfType(ctx context.Context, arg CountRunningJobsOfTypeParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countRunningJobsOfType, arg.Type, arg.ID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const crashJob = `-- name: CrashJob :one
+UPDATE jobs
+SET 
+    lost_attempts = lost_attempts + 1,
+    status = CASE
+        WHEN lost_attempts + 1 >= $1::integer THEN 'quarantined'
+        ELSE 'pending'
+    END,
+    error_message = $2,
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE id = $3
+    AND status = 'processing'
+    AND lease_token = $4
+RETURNING id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id
+`
+
+type CrashJobParams struct {
+	QuarantineThreshold int32       `json:"quarantine_threshold"`
+	ErrorMessage        pgtype.Text `json:"error_message"`
+	ID                  string      `json:"id"`
+	LeaseToken          pgtype.Text `json:"lease_token"`
+}
+
+// A job whose handler crashed the worker goroutine. It goes back to pending,
+// or to quarantined once it has crashed quarantine_threshold times.
+func (q *Queries) CrashJob(ctx context.Context, arg CrashJobParams) (Job, error) {
+	row := q.db.QueryRow(ctx, crashJob,
+		arg.QuarantineThreshold,
+		arg.ErrorMessage,
+		arg.ID,
+		arg.LeaseToken,
+	)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Type,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.ErrorMessage,
+		&i.ScheduledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LeaseToken,
+		&i.LeaseExpiresAt,
+		&i.ExpiresAt,
+		&i.GroupKey,
+		&i.EnqueueSeq,
+		&i.CoalesceKey,
+		&i.CoalesceMode,
+		&i.CoalesceUntil,
+		&i.LostAttempts,
+		&i.ApiKeyID,
+		&i.Queue,
+		&i.WindowHeld,
+		&i.Requires,
+		&i.HandlerVersion,
+		&i.Tags,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const createAPIKey = `-- name: CreateAPIKey :one
+INSERT INTO api_keys (
+    id, name, key_hash, created_by, defer_over_limit, organization_id,
+    description, key_prefix, expires_at, scopes, allowed_types, allowed_queues,
+    allowed_cidrs
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+RETURNING id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at
+`
+
+type CreateAPIKeyParams struct {
+	ID             string             `json:"id"`
+	Name           string             `json:"name"`
+	KeyHash        string             `json:"-"`
+	CreatedBy      pgtype.Text        `json:"created_by"`
+	DeferOverLimit bool               `json:"defer_over_limit"`
+	OrganizationID pgtype.Text        `json:"organization_id"`
+	Description    string             `json:"description"`
+	KeyPrefix      string             `json:"key_prefix"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+	Scopes         []string           `json:"scopes"`
+	AllowedTypes   []string           `json:"allowed_types"`
+	AllowedQueues  []string           `json:"allowed_queues"`
+	AllowedCidrs   []string           `json:"allowed_cidrs"`
+}
+
+func (q *Queries) CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, createAPIKey,
+		arg.ID,
+		arg.Name,
+		arg.KeyHash,
+		arg.CreatedBy,
+		arg.DeferOverLimit,
+		arg.OrganizationID,
+		arg.Description,
+		arg.KeyPrefix,
+		arg.ExpiresAt,
+		arg.Scopes,
+		arg.AllowedTypes,
+		arg.AllowedQueues,
+		arg.AllowedCidrs,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const createAdminPrincipal = `-- name: CreateAdminPrincipal :one
+INSERT INTO admin_principals (id, name, role, created_by)
+VALUES ($1, $2, $3, $4)
+RETURNING id, name, role, is_active, created_by, created_at, updated_at
+`
+
+type CreateAdminPrincipalParams struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	Role      string      `json:"role"`
+	CreatedBy pgtype.Text `json:"created_by"`
+}
+
+func (q *Queries) CreateAdminPrincipal(ctx context.Context, arg CreateAdminPrincipalParams) (AdminPrincipal, error) {
+	row := q.db.QueryRow(ctx, createAdminPrincipal,
+		arg.ID,
+		arg.Name,
+		arg.Role,
+		arg.CreatedBy,
+	)
+	var i AdminPrincipal
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Role,
+		&i.IsActive,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createAdminToken = `-- name: CreateAdminToken :one
+INSERT INTO admin_tokens (id, principal_id, token_hash, token_prefix, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, principal_id, token_hash, token_prefix, created_at, expires_at, last_used_at, revoked_at
+`
+
+type CreateAdminTokenParams struct {
+	ID          string             `json:"id"`
+	PrincipalID string             `json:"principal_id"`
+	TokenHash   string             `json:"-"`
+	TokenPrefix string             `json:"token_prefix"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) CreateAdminToken(ctx context.Context, arg CreateAdminTokenParams) (AdminToken, error) {
+	row := q.db.QueryRow(ctx, createAdminToken,
+		arg.ID,
+		arg.PrincipalID,
+		arg.TokenHash,
+		arg.TokenPrefix,
+		arg.ExpiresAt,
+	)
+	var i AdminToken
+	err := row.Scan(
+		&i.ID,
+		&i.PrincipalID,
+		&i.TokenHash,
+		&i.TokenPrefix,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const createCoalescedJob = `-- name: CreateCoalescedJob :one
+INSERT INTO jobs (
+    id,
+    type,
+    payload,
+    status,
+    max_attempts,
+    expires_at,
+    group_key,
+    scheduled_at,
+    coalesce_key,
+    coalesce_mode,
+    coalesce_until,
+    api_key_id,
+    queue,
+    requires,
+    tags,
+    organization_id
+) VALUES (
+    $1, $2, $3, 'pending', $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
+)
+RETURNING id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id
+`
+
+type CreateCoalescedJobParams struct {
+	ID             string             `json:"id"`
+	Type           string             `json:"type"`
+	Payload        []byte             `json:"payload"`
+	MaxAttempts    int32              `json:"max_attempts"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+	GroupKey       pgtype.Text        `json:"group_key"`
+	ScheduledAt    pgtype.Timestamptz `json:"scheduled_at"`
+	CoalesceKey    pgtype.Text        `json:"coalesce_key"`
+	CoalesceMode   pgtype.Text        `json:"coalesce_mode"`
+	CoalesceUntil  pgtype.Timestamptz `json:"coalesce_until"`
+	ApiKeyID       pgtype.Text        `json:"api_key_id"`
+	Queue          string             `json:"queue"`
+	Requires       []string           `json:"requires"`
+	Tags           []string           `json:"tags"`
+	OrganizationID pgtype.Text        `json:"organization_id"`
+}
+
+func (q *Queries) CreateCoalescedJob(ctx context.Context, arg CreateCoalescedJobParams) (Job, error) {
+	row := q.db.QueryRow(ctx, createCoalescedJob,
+		arg.ID,
+		arg.Type,
+		arg.Payload,
+		arg.MaxAttempts,
+		arg.ExpiresAt,
+		arg.GroupKey,
+		arg.ScheduledAt,
+		arg.CoalesceKey,
+		arg.CoalesceMode,
+		arg.CoalesceUntil,
+		arg.ApiKeyID,
+		arg.Queue,
+		arg.Requires,
+		arg.Tags,
+		arg.OrganizationID,
+	)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Type,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.ErrorMessage,
+		&i.ScheduledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LeaseToken,
+		&i.LeaseExpiresAt,
+		&i.ExpiresAt,
+		&i.GroupKey,
+		&i.EnqueueSeq,
+		&i.CoalesceKey,
+		&i.CoalesceMode,
+		&i.CoalesceUntil,
+		&i.LostAttempts,
+		&i.ApiKeyID,
+		&i.Queue,
+		&i.WindowHeld,
+		&i.Requires,
+		&i.HandlerVersion,
+		&i.Tags,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const createExecutionWindow = `-- name: CreateExecutionWindow :one
+INSERT INTO execution_windows (
+    scope,
+    name,
+    kind,
+    timezone,
+    weekdays,
+    start_time,
+    end_time,
+    starts_at,
+    ends_at,
+    description
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+RETURNING id, scope, name, kind, timezone, weekdays, start_time, end_time, starts_at, ends_at, description, created_at
+`
+
+type CreateExecutionWindowParams struct {
+	Scope       string             `json:"scope"`
+	Name        string             `json:"name"`
+	Kind        string             `json:"kind"`
+	Timezone    string             `json:"timezone"`
+	Weekdays    []int32            `json:"weekdays"`
+	StartTime   pgtype.Time        `json:"start_time"`
+	EndTime     pgtype.Time        `json:"end_time"`
+	StartsAt    pgtype.Timestamptz `json:"starts_at"`
+	EndsAt      pgtype.Timestamptz `json:"ends_at"`
+	Description pgtype.Text        `json:"description"`
+}
+
+func (q *Queries) CreateExecutionWindow(ctx context.Context, arg CreateExecutionWindowParams) (ExecutionWindow, error) {
+	row := q.db.QueryRow(ctx, createExecutionWindow,
+		arg.Scope,
+		arg.Name,
+		arg.Kind,
+		arg.Timezone,
+		arg.Weekdays,
+		arg.StartTime,
+		arg.EndTime,
+		arg.StartsAt,
+		arg.EndsAt,
+		arg.Description,
+	)
+	var i ExecutionWindow
+	err := row.Scan(
+		&i.ID,
+		&i.Scope,
+		&i.Name,
+		&i.Kind,
+		&i.Timezone,
+		&i.Weekdays,
+		&i.StartTime,
+		&i.EndTime,
+		&i.StartsAt,
+		&i.EndsAt,
+		&i.Description,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createJob = `-- name: CreateJob :one
+INSERT INTO jobs (
+    id,
+    type,
+    payload,
+    status,
+    max_attempts,
+    expires_at,
+    group_key,
+    api_key_id,
+    queue,
+    scheduled_at,
+    window_held,
+    requires,
+    tags,
+    organization_id
+) VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    COALESCE($10::timestamptz, NOW()),
+    $11,
+    $12,
+    $13,
+    $14
+)
+RETURNING id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id
+`
+
+type CreateJobParams struct {
+	ID             string             `json:"id"`
+	Type           string             `json:"type"`
+	Payload        []byte             `json:"payload"`
+	Status         string             `json:"status"`
+	MaxAttempts    int32              `json:"max_attempts"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+	GroupKey       pgtype.Text        `json:"group_key"`
+	ApiKeyID       pgtype.Text        `json:"api_key_id"`
+	Queue          string             `json:"queue"`
+	ScheduledAt    pgtype.Timestamptz `json:"scheduled_at"`
+	WindowHeld     bool               `json:"window_held"`
+	Requires       []string           `json:"requires"`
+	Tags           []string           `json:"tags"`
+	OrganizationID pgtype.Text        `json:"organization_id"`
+}
+
+func (q *Queries) CreateJob(ctx context.Context, arg CreateJobParams) (Job, error) {
+	row := q.db.QueryRow(ctx, createJob,
+		arg.ID,
+		arg.Type,
+		arg.Payload,
+		arg.Status,
+		arg.MaxAttempts,
+		arg.ExpiresAt,
+		arg.GroupKey,
+		arg.ApiKeyID,
+		arg.Queue,
+		arg.ScheduledAt,
+		arg.WindowHeld,
+		arg.Requires,
+		arg.Tags,
+		arg.OrganizationID,
+	)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Type,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.ErrorMessage,
+		&i.ScheduledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LeaseToken,
+		&i.LeaseExpiresAt,
+		&i.ExpiresAt,
+		&i.GroupKey,
+		&i.EnqueueSeq,
+		&i.CoalesceKey,
+		&i.CoalesceMode,
+		&i.CoalesceUntil,
+		&i.LostAttempts,
+		&i.ApiKeyID,
+		&i.Queue,
+		&i.WindowHeld,
+		&i.Requires,
+		&i.HandlerVersion,
+		&i.Tags,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const deactivateAPIKey = `-- name: DeactivateAPIKey :execrows
+UPDATE api_keys
+SET is_active = false,
+    revoked_at = COALESCE(revoked_at, NOW())
+WHERE id = $1
+`
+
+func (q *Queries) DeactivateAPIKey(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.Exec(ctx, deactivateAPIKey, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deferJob = `-- name: DeferJob :execrows
+UPDATE jobs
+SET 
+    status = 'pending',
+    scheduled_at = $3,
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE id = $1
+    AND status = 'processing'
+    AND lease_token = $2
+`
+
+type DeferJobParams struct {
+	ID          string             `json:"id"`
+	LeaseToken  pgtype.Text        `json:"lease_token"`
+	ScheduledAt pgtype.Timestamptz `json:"scheduled_at"`
+}
+
+func (q *Queries) DeferJob(ctx context.Context, arg DeferJobParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deferJob, arg.ID, arg.LeaseToken, arg.ScheduledAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteBacklogLimit = `-- name: DeleteBacklogLimit :exec
+DELETE FROM backlog_limits
+WHERE scope = $1 AND name = $2
+`
+
+type DeleteBacklogLimitParams struct {
+	Scope string `json:"scope"`
+	Name  string `json:"name"`
+}
+
+func (q *Queries) DeleteBacklogLimit(ctx context.Context, arg DeleteBacklogLimitParams) error {
+	_, err := q.db.Exec(ctx, deleteBacklogLimit, arg.Scope, arg.Name)
+	return err
+}
+
+const deleteClientIdentity = `-- name: DeleteClientIdentity :execrows
+DELETE FROM api_key_client_identities
+WHERE id = $1 AND api_key_id = $2
+`
+
+type DeleteClientIdentityParams struct {
+	ID       string `json:"id"`
+	ApiKeyID string `json:"api_key_id"`
+}
+
+func (q *Queries) DeleteClientIdentity(ctx context.Context, arg DeleteClientIdentityParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteClientIdentity, arg.ID, arg.ApiKeyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExecutionWindow = `-- name: DeleteExecutionWindow :one
+DELETE FROM execution_windows
+WHERE id = $1
+RETURNING id, scope, name, kind, timezone, weekdays, start_time, end_time, starts_at, ends_at, description, created_at
+`
+
+func (q *Queries) DeleteExecutionWindow(ctx context.Context, id int64) (ExecutionWindow, error) {
+	row := q.db.QueryRow(ctx, deleteExecutionWindow, id)
+	var i ExecutionWindow
+	err := row.Scan(
+		&i.ID,
+		&i.Scope,
+		&i.Name,
+		&i.Kind,
+		&i.Timezone,
+		&i.Weekdays,
+		&i.StartTime,
+		&i.EndTime,
+		&i.StartsAt,
+		&i.EndsAt,
+		&i.Description,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteHandlerRollout = `-- name: DeleteHandlerRollout :exec
+DELETE FROM handler_rollouts
+WHERE job_type = $1
+`
+
+func (q *Queries) DeleteHandlerRollout(ctx context.Context, jobType string) error {
+	_, err := q.db.Exec(ctx, deleteHandlerRollout, jobType)
+	return err
+}
+
+const deleteJobTypeLimit = `-- name: DeleteJobTypeLimit :exec
+DELETE FROM job_type_limits
+WHERE job_type = $1
+`
+
+func (q *Queries) DeleteJobTypeLimit(ctx context.Context, jobType string) error {
+	_, err := q.db.Exec(ctx, deleteJobTypeLimit, jobType)
+	return err
+}
+
+const deleteOrganization = `-- name: DeleteOrganization :exec
+WITH deleted_limit AS (
+    DELETE FROM backlog_limits
+    WHERE scope = 'organization' AND name = $1
+)
+DELETE FROM organizations
+WHERE id = $1
+`
+
+// Keys of a deleted organization are left without one, and its backlog
+// limit goes with it.
+func (q *Queries) DeleteOrganization(ctx context.Context, id string) error {
+	_, err := q.db.Exec(ctx, deleteOrganization, id)
+	return err
+}
+
+const dequeueJob = `-- name: DequeueJob :one
+UPDATE jobs
+SET 
+    status = 'processing',
+    lost_attempts = CASE
+        WHEN status = 'processing' THEN lost_attempts + 1
+        ELSE lost_attempts
+    END,
+    lease_token = $1,
+    lease_expires_at = $2,
+    window_held = FALSE,
+    handler_version = $3::jsonb ->> type,
+    updated_at = NOW()
+WHERE id = (
+    SELECT runnable.id
+    -- tenants in fair queue order, each offering its oldest runnable job,
+    -- so both sides are walked by index and the walk stops at the first job
+    FROM (
+        SELECT tenant_id
+        FROM tenant_schedule
+        ORDER BY pass ASC, tenant_id ASC
+    ) t
+    CROSS JOIN LATERAL (
+        SELECT j.id
+        FROM jobs j
+        WHERE COALESCE(j.api_key_id, '') = t.tenant_id
+            AND j.status IN ('pending', 'processing')
+            AND ((j.status = 'pending' AND j.scheduled_at <= NOW())
+                OR (j.status = 'processing' AND j.lease_expires_at < NOW()
+                    AND j.lost_attempts + 1 < $4::integer))
+            AND (j.expires_at IS NULL OR j.expires_at > NOW())
+            AND j.type <> ALL($5::text[])
+            AND j.requires <@ $6::text[]
+            -- jobs of a type under rollout go to the version they are routed to
+            AND NOT EXISTS (
+                SELECT 1
+                FROM handler_rollouts r
+                WHERE r.job_type = j.type
+                    AND ($3::jsonb ->> j.type) IS DISTINCT FROM CASE
+                        WHEN r.state = 'active'
+                            AND (hashtext(j.id) & 2147483647) % 100 < r.canary_percent
+                            THEN r.canary_version
+                        ELSE r.stable_version
+                    END
+            )
+            AND (j.group_key IS NULL OR NOT EXISTS (
+                SELECT 1
+                FROM jobs g
+                WHERE g.group_key = j.group_key
+                    AND g.id <> j.id
+                    AND (g.status = 'processing'
+                        OR (g.status = 'pending' AND g.enqueue_seq < j.enqueue_seq))
+            ))
+            AND NOT EXISTS (
+                SELECT 1
+                FROM circuit_breakers b
+                WHERE b.job_type = j.type
+                    AND ((b.state = 'open' AND b.open_until > NOW())
+                        OR (b.state = 'half_open' AND b.probe_until > NOW()))
+            )
+        ORDER BY j.scheduled_at ASC
+        LIMIT 1
+        FOR UPDATE SKIP LOCKED
+    ) runnable
+    LIMIT 1
+)
+RETURNING id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id
+`
+
+type DequeueJobParams struct {
+	LeaseToken          pgtype.Text        `json:"lease_token"`
+	LeaseExpiresAt      pgtype.Timestamptz `json:"lease_expires_at"`
+	HandlerVersions     []byte             `json:"handler_versions"`
+	QuarantineThreshold int32              `json:"quarantine_threshold"`
+	ExcludedTypes       []string           `json:"excluded_types"`
+	Labels              []string           `json:"labels"`
+}
+
+// A job with a group_key is only eligible while no other job in its group
+// is processing or queued ahead of it, so each group runs one job at a time
+// in enqueue order. Job types whose circuit breaker is open, or half open
+// with a probe in flight, are skipped, as are the excluded_types the worker
+// has no free capacity for. Tenants are served in order of their pass in
+// tenant_schedule (see ChargeTenant), oldest job first within a tenant.
+// Reclaiming a job whose lease ran out counts as a
+// lost attempt; jobs about to reach the quarantine threshold are left for
+// QuarantineLostJobs.
+func (q *Queries) DequeueJob(ctx context.Context, arg DequeueJobParams) (Job, error) {
+	row := q.db.QueryRow(ctx, dequeueJob,
+		arg.LeaseToken,
+		arg.LeaseExpiresAt,
+		arg.HandlerVersions,
+		arg.QuarantineThreshold,
+		arg.ExcludedTypes,
+		arg.Labels,
+	)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Type,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.ErrorMessage,
+		&i.ScheduledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LeaseToken,
+		&i.LeaseExpiresAt,
+		&i.ExpiresAt,
+		&i.GroupKey,
+		&i.EnqueueSeq,
+		&i.CoalesceKey,
+		&i.CoalesceMode,
+		&i.CoalesceUntil,
+		&i.LostAttempts,
+		&i.ApiKeyID,
+		&i.Queue,
+		&i.WindowHeld,
+		&i.Requires,
+		&i.HandlerVersion,
+		&i.Tags,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const ensureCircuitBreaker = `-- name: EnsureCircuitBreaker :exec
+INSERT INTO circuit_breakers (job_type)
+VALUES ($1)
+ON CONFLICT (job_type) DO NOTHING
+`
+
+func (q *Queries) EnsureCircuitBreaker(ctx context.Context, jobType string) error {
+	_, err := q.db.Exec(ctx, ensureCircuitBreaker, jobType)
+	return err
+}
+
+const ensureTenant = `-- name: EnsureTenant :exec
+INSERT INTO tenant_schedule (tenant_id, pass)
+VALUES ($1, (SELECT COALESCE(MAX(s.last_start), 0) FROM tenant_schedule s))
+ON CONFLICT (tenant_id) DO NOTHING
+`
+
+// Moves a tenant forward by one job: its pass becomes its start tag, the
+// later of its old pass and the scheduler clock, plus 1 / weight.
+// A new tenant starts at the scheduler clock, like one that was idle.
+func (q *Queries) EnsureTenant(ctx context.Context, tenantID string) error {
+	_, err := q.db.Exec(ctx, ensureTenant, tenantID)
+	return err
+}
+
+const expireJobs = `-- name: ExpireJobs :execrows
+UPDATE jobs
+SET 
+    status = 'expired',
+    error_message = 'job expired before a worker could run it',
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE expires_at <= NOW()
+    AND (status = 'pending'
+        OR (status = 'processing' AND lease_expires_at < NOW()))
+`
+
+func (q *Queries) ExpireJobs(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, expireJobs)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const failJob = `-- name: FailJob :execrows
+UPDATE jobs
+SET 
+    status = $2,
+    attempts = $3,
+    error_message = $4,
+    scheduled_at = $5,
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE id = $1
+    AND status = 'processing'
+    AND lease_token = $6
+`
+
+type FailJobParams struct {
+	ID           string             `json:"id"`
+	Status       string             `json:"status"`
+	Attempts     int32              `json:"attempts"`
+	ErrorMessage pgtype.Text        `json:"error_message"`
+	ScheduledAt  pgtype.Timestamptz `json:"scheduled_at"`
+	LeaseToken   pgtype.Text        `json:"lease_token"`
+}
+
+func (q *Queries) FailJob(ctx context.Context, arg FailJobParams) (int64, error) {
+	result, err := q.db.Exec(ctx, failJob,
+		arg.ID,
+		arg.Status,
+		arg.Attempts,
+		arg.ErrorMessage,
+		arg.ScheduledAt,
+		arg.LeaseToken,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getAPIKey = `-- name: GetAPIKey :one
+SELECT id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at FROM api_keys
+WHERE id = $1
+`
+
+func (q *Queries) GetAPIKey(ctx context.Context, id string) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getAPIKey, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const getAPIKeyByHash = `-- name: GetAPIKeyByHash :one
+SELECT id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at FROM api_keys
+WHERE key_hash = $1 AND is_active = true
+`
+
+func (q *Queries) GetAPIKeyByHash(ctx context.Context, keyHash string) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getAPIKeyByHash, keyHash)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const getAPIKeyForUpdate = `-- name: GetAPIKeyForUpdate :one
+SELECT id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at FROM api_keys
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetAPIKeyForUpdate(ctx context.Context, id string) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getAPIKeyForUpdate, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const getAPIKeysByClientIdentities = `-- name: GetAPIKeysByClientIdentities :many
+SELECT id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at FROM api_keys
+WHERE is_active = true
+    AND id IN (
+        SELECT api_key_id FROM api_key_client_identities
+        WHERE identity = ANY($1::text[])
+    )
+ORDER BY id
+`
+
+func (q *Queries) GetAPIKeysByClientIdentities(ctx context.Context, identities []string) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, getAPIKeysByClientIdentities, identities)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ApiKey{}
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.KeyHash,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.IsActive,
+			&i.DeferOverLimit,
+			&i.OrganizationID,
+			&i.Description,
+			&i.KeyPrefix,
+			&i.RevokedAt,
+			&i.ReplacedBy,
+			&i.Scopes,
+			&i.AllowedTypes,
+			&i.AllowedQueues,
+			&i.SigningSecret,
+			&i.AllowedCidrs,
+			&i.IpDenials,
+			&i.LastIpDenialAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAdminPrincipalForUpdate = `-- name: GetAdminPrincipalForUpdate :one
+SELECT id, name, role, is_active, created_by, created_at, updated_at FROM admin_principals
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetAdminPrincipalForUpdate(ctx context.Context, id string) (AdminPrincipal, error) {
+	row := q.db.QueryRow(ctx, getAdminPrincipalForUpdate, id)
+	var i AdminPrincipal
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Role,
+		&i.IsActive,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getAdminTokenByHash = `-- name: GetAdminTokenByHash :one
+SELECT
+    t.id AS token_id,
+    t.expires_at,
+    p.id AS principal_id,
+    p.name,
+    p.role
+FROM admin_tokens t
+JOIN admin_principals p ON p.id = t.principal_id
+WHERE t.token_hash = $1
+    AND t.revoked_at IS NULL
+    AND p.is_active = true
+`
+
+type GetAdminTokenByHashRow struct {
+	TokenID     string             `json:"token_id"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+	PrincipalID string             `json:"principal_id"`
+	Name        string             `json:"name"`
+	Role        string             `json:"role"`
+}
+
+func (q *Queries) GetAdminTokenByHash(ctx context.Context, tokenHash string) (GetAdminTokenByHashRow, error) {
+	row := q.db.QueryRow(ctx, getAdminTokenByHash, tokenHash)
+	var i GetAdminTokenByHashRow
+	err := row.Scan(
+		&i.TokenID,
+		&i.ExpiresAt,
+		&i.PrincipalID,
+		&i.Name,
+		&i.Role,
+	)
+	return i, err
+}
+
+const getCircuitBreakerForUpdate = `-- name: GetCircuitBreakerForUpdate :one
+SELECT job_type, state, failures, successes, window_started_at, opened_at, open_until, probe_until, failure_rate_threshold, min_requests, window_seconds, open_seconds, updated_at FROM circuit_breakers
+WHERE job_type = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetCircuitBreakerForUpdate(ctx context.Context, jobType string) (CircuitBreaker, error) {
+	row := q.db.QueryRow(ctx, getCircuitBreakerForUpdate, jobType)
+	var i CircuitBreaker
+	err := row.Scan(
+		&i.JobType,
+		&i.State,
+		&i.Failures,
+		&i.Successes,
+		&i.WindowStartedAt,
+		&i.OpenedAt,
+		&i.OpenUntil,
+		&i.ProbeUntil,
+		&i.FailureRateThreshold,
+		&i.MinRequests,
+		&i.WindowSeconds,
+		&i.OpenSeconds,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getHandlerRolloutForUpdate = `-- name: GetHandlerRolloutForUpdate :one
+SELECT job_type, stable_version, canary_version, canary_percent, min_jobs, max_error_rate_increase, state, reason, started_at, updated_at FROM handler_rollouts
+WHERE job_type = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetHandlerRolloutForUpdate(ctx context.Context, jobType string) (HandlerRollout, error) {
+	row := q.db.QueryRow(ctx, getHandlerRolloutForUpdate, jobType)
+	var i HandlerRollout
+	err := row.Scan(
+		&i.JobType,
+		&i.StableVersion,
+		&i.CanaryVersion,
+		&i.CanaryPercent,
+		&i.MinJobs,
+		&i.MaxErrorRateIncrease,
+		&i.State,
+		&i.Reason,
+		&i.StartedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getHandlerVersionStat = `-- name: GetHandlerVersionStat :one
+SELECT job_type, version, successes, failures, total_latency_ms FROM handler_version_stats
+WHERE job_type = $1 AND version = $2
+`
+
+type GetHandlerVersionStatParams struct {
+	JobType string `json:"job_type"`
+	Version string `json:"version"`
+}
+
+func (q *Queries) GetHandlerVersionStat(ctx context.Context, arg GetHandlerVersionStatParams) (HandlerVersionStat, error) {
+	row := q.db.QueryRow(ctx, getHandlerVersionStat, arg.JobType, arg.Version)
+	var i HandlerVersionStat
+	err := row.Scan(
+		&i.JobType,
+		&i.Version,
+		&i.Successes,
+		&i.Failures,
+		&i.TotalLatencyMs,
+	)
+	return i, err
+}
+
+const getJob = `-- name: GetJob :one
+SELECT id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id FROM jobs
+WHERE id = $1
+`
+
+func (q *Queries) GetJob(ctx context.Context, id string) (Job, error) {
+	row := q.db.QueryRow(ctx, getJob, id)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Type,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.ErrorMessage,
+		&i.ScheduledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LeaseToken,
+		&i.LeaseExpiresAt,
+		&i.ExpiresAt,
+		&i.GroupKey,
+		&i.EnqueueSeq,
+		&i.CoalesceKey,
+		&i.CoalesceMode,
+		&i.CoalesceUntil,
+		&i.LostAttempts,
+		&i.ApiKeyID,
+		&i.Queue,
+		&i.WindowHeld,
+		&i.Requires,
+		&i.HandlerVersion,
+		&i.Tags,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const getJobForUpdate = `-- name: GetJobForUpdate :one
+SELECT id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id FROM jobs
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetJobForUpdate(ctx context.Context, id string) (Job, error) {
+	row := q.db.QueryRow(ctx, getJobForUpdate, id)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Type,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.ErrorMessage,
+		&i.ScheduledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LeaseToken,
+		&i.LeaseExpiresAt,
+		&i.ExpiresAt,
+		&i.GroupKey,
+		&i.EnqueueSeq,
+		&i.CoalesceKey,
+		&i.CoalesceMode,
+		&i.CoalesceUntil,
+		&i.LostAttempts,
+		&i.ApiKeyID,
+		&i.Queue,
+		&i.WindowHeld,
+		&i.Requires,
+		&i.HandlerVersion,
+		&i.Tags,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const getJobTypeLimitForUpdate = `-- name: GetJobTypeLimitForUpdate :one
+SELECT job_type, max_concurrent, rate_per_second, burst, tokens, refilled_at, updated_at FROM job_type_limits
+WHERE job_type = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetJobTypeLimitForUpdate(ctx context.Context, jobType string) (JobTypeLimit, error) {
+	row := q.db.QueryRow(ctx, getJobTypeLimitForUpdate, jobType)
+	var i JobTypeLimit
+	err := row.Scan(
+		&i.JobType,
+		&i.MaxConcurrent,
+		&i.RatePerSecond,
+		&i.Burst,
+		&i.Tokens,
+		&i.RefilledAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getOrganization = `-- name: GetOrganization :one
+SELECT id, name, rate_per_second, burst, defer_over_limit, created_at, updated_at FROM organizations
+WHERE id = $1
+`
+
+func (q *Queries) GetOrganization(ctx context.Context, id string) (Organization, error) {
+	row := q.db.QueryRow(ctx, getOrganization, id)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.RatePerSecond,
+		&i.Burst,
+		&i.DeferOverLimit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getPendingCoalescedJobForUpdate = `-- name: GetPendingCoalescedJobForUpdate :one
+SELECT id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id FROM jobs
+WHERE status = 'pending'
+    AND type = $1
+    AND coalesce_key = $2
+    AND COALESCE('key:' || api_key_id, 'org:' || organization_id, '') = $3::text
+ORDER BY enqueue_seq DESC
+LIMIT 1
+FOR UPDATE
+`
+
+type GetPendingCoalescedJobForUpdateParams struct {
+	Type          string      `json:"type"`
+	CoalesceKey   pgtype.Text `json:"coalesce_key"`
+	CoalesceOwner string      `json:"coalesce_owner"`
+}
+
+// The owner is the submitting key, or the organization for callers without
+// one, so coalescing never folds one tenant's job into another's. A retried
+// job may be pending next to a newer one; the newest takes submissions.
+func (q *Queries) GetPendingCoalescedJobForUpdate(ctx context.Context, arg GetPendingCoalescedJobForUpdateParams) (Job, error) {
+	row := q.db.QueryRow(ctx, getPendingCoalescedJobForUpdate, arg.Type, arg.CoalesceKey, arg.CoalesceOwner)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Type,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.ErrorMessage,
+		&i.ScheduledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LeaseToken,
+		&i.LeaseExpiresAt,
+		&i.ExpiresAt,
+		&i.GroupKey,
+		&i.EnqueueSeq,
+		&i.CoalesceKey,
+		&i.CoalesceMode,
+		&i.CoalesceUntil,
+		&i.LostAttempts,
+		&i.ApiKeyID,
+		&i.Queue,
+		&i.WindowHeld,
+		&i.Requires,
+		&i.HandlerVersion,
+		&i.Tags,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const getSideEffect = `-- name: GetSideEffect :one
+SELECT key, job_id, outcome, created_at FROM job_side_effects
+WHERE key = $1
+`
+
+func (q *Queries) GetSideEffect(ctx context.Context, key string) (JobSideEffect, error) {
+	row := q.db.QueryRow(ctx, getSideEffect, key)
+	var i JobSideEffect
+	err := row.Scan(
+		&i.Key,
+		&i.JobID,
+		&i.Outcome,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const holdJobForWindow = `-- name: HoldJobForWindow :execrows
+UPDATE jobs
+SET 
+    status = 'pending',
+    scheduled_at = $3,
+    window_held = TRUE,
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE id = $1
+    AND status = 'processing'
+    AND lease_token = $2
+`
+
+type HoldJobForWindowParams struct {
+	ID          string             `json:"id"`
+	LeaseToken  pgtype.Text        `json:"lease_token"`
+	ScheduledAt pgtype.Timestamptz `json:"scheduled_at"`
+}
+
+// Puts a job claimed outside its execution windows back to pending until
+// the next window opens. Attempts are untouched.
+func (q *Queries) HoldJobForWindow(ctx context.Context, arg HoldJobForWindowParams) (int64, error) {
+	result, err := q.db.Exec(ctx, holdJobForWindow, arg.ID, arg.LeaseToken, arg.ScheduledAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const listAPIKeys = `-- name: ListAPIKeys :many
+SELECT id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at FROM api_keys
+WHERE $1::text IS NULL OR organization_id = $1::text
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListAPIKeys(ctx context.Context, organizationID pgtype.Text) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, listAPIKeys, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ApiKey{}
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.KeyHash,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.IsActive,
+			&i.DeferOverLimit,
+			&i.OrganizationID,
+			&i.Description,
+			&i.KeyPrefix,
+			&i.RevokedAt,
+			&i.ReplacedBy,
+			&i.Scopes,
+			&i.AllowedTypes,
+			&i.AllowedQueues,
+			&i.SigningSecret,
+			&i.AllowedCidrs,
+			&i.IpDenials,
+			&i.LastIpDenialAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAdminActions = `-- name: ListAdminActions :many
+SELECT id, principal, method, path, status, created_at FROM admin_audit_log
+WHERE $1::text IS NULL OR principal = $1::text
+ORDER BY created_at DESC, id DESC
+LIMIT $2
+`
+
+type ListAdminActionsParams struct {
+	Principal pgtype.Text `json:"principal"`
+	PageSize  int32       `json:"page_size"`
+}
+
+func (q *Queries) ListAdminActions(ctx context.Context, arg ListAdminActionsParams) ([]AdminAuditLog, error) {
+	rows, err := q.db.Query(ctx, listAdminActions, arg.Principal, arg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AdminAuditLog{}
+	for rows.Next() {
+		var i AdminAuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.Principal,
+			&i.Method,
+			&i.Path,
+			&i.Status,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAdminPrincipals = `-- name: ListAdminPrincipals :many
+SELECT id, name, role, is_active, created_by, created_at, updated_at FROM admin_principals
+ORDER BY name
+`
+
+func (q *Queries) ListAdminPrincipals(ctx context.Context) ([]AdminPrincipal, error) {
+	rows, err := q.db.Query(ctx, listAdminPrincipals)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AdminPrincipal{}
+	for rows.Next() {
+		var i AdminPrincipal
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Role,
+			&i.IsActive,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAdminTokens = `-- name: ListAdminTokens :many
+SELECT id, principal_id, token_prefix, created_at, expires_at, last_used_at, revoked_at
+FROM admin_tokens
+WHERE principal_id = $1
+ORDER BY created_at DESC
+`
+
+type ListAdminTokensRow struct {
+	ID          string             `json:"id"`
+	PrincipalID string             `json:"principal_id"`
+	TokenPrefix string             `json:"token_prefix"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+	LastUsedAt  pgtype.Timestamptz `json:"last_used_at"`
+	RevokedAt   pgtype.Timestamptz `json:"revoked_at"`
+}
+
+func (q *Queries) ListAdminTokens(ctx context.Context, principalID string) ([]ListAdminTokensRow, error) {
+	rows, err := q.db.Query(ctx, listAdminTokens, principalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListAdminTokensRow{}
+	for rows.Next() {
+		var i ListAdminTokensRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.PrincipalID,
+			&i.TokenPrefix,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listBacklogLimits = `-- name: ListBacklogLimits :many
+SELECT scope, name, max_pending, updated_at FROM backlog_limits
+ORDER BY scope, name
+`
+
+func (q *Queries) ListBacklogLimits(ctx context.Context) ([]BacklogLimit, error) {
+	rows, err := q.db.Query(ctx, listBacklogLimits)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []BacklogLimit{}
+	for rows.Next() {
+		var i BacklogLimit
+		if err := rows.Scan(
+			&i.Scope,
+			&i.Name,
+			&i.MaxPending,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCircuitBreakers = `-- name: ListCircuitBreakers :many
+SELECT job_type, state, failures, successes, window_started_at, opened_at, open_until, probe_until, failure_rate_threshold, min_requests, window_seconds, open_seconds, updated_at FROM circuit_breakers
+ORDER BY job_type
+`
+
+func (q *Queries) ListCircuitBreakers(ctx context.Context) ([]CircuitBreaker, error) {
+	rows, err := q.db.Query(ctx, listCircuitBreakers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CircuitBreaker{}
+	for rows.Next() {
+		var i CircuitBreaker
+		if err := rows.Scan(
+			&i.JobType,
+			&i.State,
+			&i.Failures,
+			&i.Successes,
+			&i.WindowStartedAt,
+			&i.OpenedAt,
+			&i.OpenUntil,
+			&i.ProbeUntil,
+			&i.FailureRateThreshold,
+			&i.MinRequests,
+			&i.WindowSeconds,
+			&i.OpenSeconds,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listClientIdentities = `-- name: ListClientIdentities :many
+SELECT id, api_key_id, identity, created_at FROM api_key_client_identities
+WHERE api_key_id = $1
+ORDER BY identity
+`
+
+func (q *Queries) ListClientIdentities(ctx context.Context, apiKeyID string) ([]ApiKeyClientIdentity, error) {
+	rows, err := q.db.Query(ctx, listClientIdentities, apiKeyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ApiKeyClientIdentity{}
+	for rows.Next() {
+		var i ApiKeyClientIdentity
+		if err := rows.Scan(
+			&i.ID,
+			&i.ApiKeyID,
+			&i.Identity,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExecutionWindows = `-- name: ListExecutionWindows :many
+SELECT id, scope, name, kind, timezone, weekdays, start_time, end_time, starts_at, ends_at, description, created_at FROM execution_windows
+ORDER BY scope, name, id
+`
+
+func (q *Queries) ListExecutionWindows(ctx context.Context) ([]ExecutionWindow, error) {
+	rows, err := q.db.Query(ctx, listExecutionWindows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ExecutionWindow{}
+	for rows.Next() {
+		var i ExecutionWindow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Scope,
+			&i.Name,
+			&i.Kind,
+			&i.Timezone,
+			&i.Weekdays,
+			&i.StartTime,
+			&i.EndTime,
+			&i.StartsAt,
+			&i.EndsAt,
+			&i.Description,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExecutionWindowsForJob = `-- name: ListExecutionWindowsForJob :many
+SELECT id, scope, name, kind, timezone, weekdays, start_time, end_time, starts_at, ends_at, description, created_at FROM execution_windows
+WHERE (scope = 'type' AND name = $1)
+    OR (scope = 'queue' AND name = $2)
+`
+
+type ListExecutionWindowsForJobParams struct {
+	JobType string `json:"job_type"`
+	Queue   string `json:"queue"`
+}
+
+func (q *Queries) ListExecutionWindowsForJob(ctx context.Context, arg ListExecutionWindowsForJobParams) ([]ExecutionWindow, error) {
+	rows, err := q.db.Query(ctx, listExecutionWindowsForJob, arg.JobType, arg.Queue)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ExecutionWindow{}
+	for rows.Next() {
+		var i ExecutionWindow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Scope,
+			&i.Name,
+			&i.Kind,
+			&i.Timezone,
+			&i.Weekdays,
+			&i.StartTime,
+			&i.EndTime,
+			&i.StartsAt,
+			&i.EndsAt,
+			&i.Description,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listHandlerRollouts = `-- name: ListHandlerRollouts :many
+SELECT job_type, stable_version, canary_version, canary_percent, min_jobs, max_error_rate_increase, state, reason, started_at, updated_at FROM handler_rollouts
+ORDER BY job_type
+`
+
+func (q *Queries) ListHandlerRollouts(ctx context.Context) ([]HandlerRollout, error) {
+	rows, err := q.db.Query(ctx, listHandlerRollouts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []HandlerRollout{}
+	for rows.Next() {
+		var i HandlerRollout
+		if err := rows.Scan(
+			&i.JobType,
+			&i.StableVersion,
+			&i.CanaryVersion,
+			&i.CanaryPercent,
+			&i.MinJobs,
+			&i.MaxErrorRateIncrease,
+			&i.State,
+			&i.Reason,
+			&i.StartedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listHandlerVersionStats = `-- name: ListHandlerVersionStats :many
+SELECT job_type, version, successes, failures, total_latency_ms FROM handler_version_stats
+ORDER BY job_type, version
+`
+
+func (q *Queries) ListHandlerVersionStats(ctx context.Context) ([]HandlerVersionStat, error) {
+	rows, err := q.db.Query(ctx, listHandlerVersionStats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []HandlerVersionStat{}
+	for rows.Next() {
+		var i HandlerVersionStat
+		if err := rows.Scan(
+			&i.JobType,
+			&i.Version,
+			&i.Successes,
+			&i.Failures,
+			&i.TotalLatencyMs,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listJobTypeLimits = `-- name: ListJobTypeLimits :many
+SELECT job_type, max_concurrent, rate_per_second, burst, tokens, refilled_at, updated_at FROM job_type_limits
+ORDER BY job_type
+`
+
+func (q *Queries) ListJobTypeLimits(ctx context.Context) ([]JobTypeLimit, error) {
+	rows, err := q.db.Query(ctx, listJobTypeLimits)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []JobTypeLimit{}
+	for rows.Next() {
+		var i JobTypeLimit
+		if err := rows.Scan(
+			&i.JobType,
+			&i.MaxConcurrent,
+			&i.RatePerSecond,
+			&i.Burst,
+			&i.Tokens,
+			&i.RefilledAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listJobs = `-- name: ListJobs :many
+SELECT id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id FROM jobs
+WHERE status = $1
+ORDER BY created_at DESC
+LIMIT $2 OFFSET $3
+`
+
+type ListJobsParams struct {
+	Status string `json:"status"`
+	Limit  int32  `json:"limit"`
+	Offset int32  `json:"offset"`
+}
+
+func (q *Queries) ListJobs(ctx context.Context, arg ListJobsParams) ([]Job, error) {
+	rows, err := q.db.Query(ctx, listJobs, arg.Status, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Job{}
+	for rows.Next() {
+		var i Job
+		if err := rows.Scan(
+			&i.ID,
+			&i.Type,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.MaxAttempts,
+			&i.ErrorMessage,
+			&i.ScheduledAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LeaseToken,
+			&i.LeaseExpiresAt,
+			&i.ExpiresAt,
+			&i.GroupKey,
+			&i.EnqueueSeq,
+			&i.CoalesceKey,
+			&i.CoalesceMode,
+			&i.CoalesceUntil,
+			&i.LostAttempts,
+			&i.ApiKeyID,
+			&i.Queue,
+			&i.WindowHeld,
+			&i.Requires,
+			&i.HandlerVersion,
+			&i.Tags,
+			&i.OrganizationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrganizations = `-- name: ListOrganizations :many
+SELECT id, name, rate_per_second, burst, defer_over_limit, created_at, updated_at FROM organizations
+ORDER BY created_at
+`
+
+func (q *Queries) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	rows, err := q.db.Query(ctx, listOrganizations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Organization{}
+	for rows.Next() {
+		var i Organization
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.RatePerSecond,
+			&i.Burst,
+			&i.DeferOverLimit,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTenantSchedule = `-- name: ListTenantSchedule :many
+SELECT tenant_id, weight, pass, updated_at, last_start FROM tenant_schedule
+ORDER BY pass ASC
+`
+
+func (q *Queries) ListTenantSchedule(ctx context.Context) ([]TenantSchedule, error) {
+	rows, err := q.db.Query(ctx, listTenantSchedule)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []TenantSchedule{}
+	for rows.Next() {
+		var i TenantSchedule
+		if err := rows.Scan(
+			&i.TenantID,
+			&i.Weight,
+			&i.Pass,
+			&i.UpdatedAt,
+			&i.LastStart,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWindowHeldJobGroups = `-- name: ListWindowHeldJobGroups :many
+SELECT DISTINCT type, queue FROM jobs
+WHERE status = 'pending'
+    AND window_held
+    AND (($1::text = 'type' AND type = $2::text)
+        OR ($1::text = 'queue' AND queue = $2::text))
+`
+
+type ListWindowHeldJobGroupsParams struct {
+	Scope string `json:"scope"`
+	Name  string `json:"name"`
+}
+
+type ListWindowHeldJobGroupsRow struct {
+	Type  string `json:"type"`
+	Queue string `json:"queue"`
+}
+
+func (q *Queries) ListWindowHeldJobGroups(ctx context.Context, arg ListWindowHeldJobGroupsParams) ([]ListWindowHeldJobGroupsRow, error) {
+	rows, err := q.db.Query(ctx, listWindowHeldJobGroups, arg.Scope, arg.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListWindowHeldJobGroupsRow{}
+	for rows.Next() {
+		var i ListWindowHeldJobGroupsRow
+		if err := rows.Scan(&i.Type, &i.Queue); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWorkers = `-- name: ListWorkers :many
+SELECT id, hostname, labels, handler_versions, started_at, last_seen_at FROM workers
+WHERE last_seen_at > $1
+ORDER BY started_at
+`
+
+func (q *Queries) ListWorkers(ctx context.Context, lastSeenAt pgtype.Timestamptz) ([]Worker, error) {
+	rows, err := q.db.Query(ctx, listWorkers, lastSeenAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Worker{}
+	for rows.Next() {
+		var i Worker
+		if err := rows.Scan(
+			&i.ID,
+			&i.Hostname,
+			&i.Labels,
+			&i.HandlerVersions,
+			&i.StartedAt,
+			&i.LastSeenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockActiveAdminOwners = `-- name: LockActiveAdminOwners :many
+SELECT id FROM admin_principals
+WHERE role = 'owner' AND is_active = true
+FOR UPDATE
+`
+
+// Locks the active owners, so two requests cannot demote the last two
+// owners at once.
+func (q *Queries) LockActiveAdminOwners(ctx context.Context) ([]string, error) {
+	rows, err := q.db.Query(ctx, lockActiveAdminOwners)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockCoalesceKey = `-- name: LockCoalesceKey :exec
+SELECT pg_advisory_xact_lock(hashtextextended($1::text, 0))
+`
+
+// Serializes the coalescing submissions of one type, key and owner, so two
+// of them cannot both miss the pending job and create one each.
+func (q *Queries) LockCoalesceKey(ctx context.Context, lockKey string) error {
+	_, err := q.db.Exec(ctx, lockCoalesceKey, lockKey)
+	return err
+}
+
+const moveClientIdentities = `-- name: MoveClientIdentities :exec
+UPDATE api_key_client_identities
+SET api_key_id = $1
+WHERE api_key_id = $2
+`
+
+type MoveClientIdentitiesParams struct {
+	ToApiKeyID   string `json:"to_api_key_id"`
+	FromApiKeyID string `json:"from_api_key_id"`
+}
+
+func (q *Queries) MoveClientIdentities(ctx context.Context, arg MoveClientIdentitiesParams) error {
+	_, err := q.db.Exec(ctx, moveClientIdentities, arg.ToApiKeyID, arg.FromApiKeyID)
+	return err
+}
+
+const quarantineLostJobs = `-- name: QuarantineLostJobs :many
+UPDATE jobs
+SET 
+    status = 'quarantined',
+    lost_attempts = lost_attempts + 1,
+    error_message = 'quarantined: lease expired without an outcome too many times',
+    lease_token = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE status = 'processing'
+    AND lease_expires_at < NOW()
+    AND lost_attempts + 1 >= $1::integer
+RETURNING id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id
+`
+
+// Jobs whose lease ran out without an outcome being recorded most likely
+// crashed or hung the worker running them. Once that has happened
+// quarantine_threshold times they are quarantined instead of reclaimed.
+func (q *Queries) QuarantineLostJobs(ctx context.Context, quarantineThreshold int32) ([]Job, error) {
+	rows, err := q.db.Query(ctx, quarantineLostJobs, quarantineThreshold)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Job{}
+	for rows.Next() {
+		var i Job
+		if err := rows.Scan(
+			&i.ID,
+			&i.Type,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.MaxAttempts,
+			&i.ErrorMessage,
+			&i.ScheduledAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LeaseToken,
+			&i.LeaseExpiresAt,
+			&i.ExpiresAt,
+			&i.GroupKey,
+			&i.EnqueueSeq,
+			&i.CoalesceKey,
+			&i.CoalesceMode,
+			&i.CoalesceUntil,
+			&i.LostAttempts,
+			&i.ApiKeyID,
+			&i.Queue,
+			&i.WindowHeld,
+			&i.Requires,
+			&i.HandlerVersion,
+			&i.Tags,
+			&i.OrganizationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recordAPIKeyIPDenial = `-- name: RecordAPIKeyIPDenial :exec
+UPDATE api_keys
+SET ip_denials = ip_denials + 1,
+    last_ip_denial_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) RecordAPIKeyIPDenial(ctx context.Context, id string) error {
+	_, err := q.db.Exec(ctx, recordAPIKeyIPDenial, id)
+	return err
+}
+
+const recordAdminAction = `-- name: RecordAdminAction :exec
+INSERT INTO admin_audit_log (principal, method, path, status)
+VALUES ($1, $2, $3, $4)
+`
+
+type RecordAdminActionParams struct {
+	Principal string `json:"principal"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int32  `json:"status"`
+}
+
+func (q *Queries) RecordAdminAction(ctx context.Context, arg RecordAdminActionParams) error {
+	_, err := q.db.Exec(ctx, recordAdminAction,
+		arg.Principal,
+		arg.Method,
+		arg.Path,
+		arg.Status,
+	)
+	return err
+}
+
+const recordHandlerVersionOutcome = `-- name: RecordHandlerVersionOutcome :one
+INSERT INTO handler_version_stats (job_type, version, successes, failures, total_latency_ms)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (job_type, version) DO UPDATE SET
+    successes = handler_version_stats.successes + EXCLUDED.successes,
+    failures = handler_version_stats.failures + EXCLUDED.failures,
+    total_latency_ms = handler_version_stats.total_latency_ms + EXCLUDED.total_latency_ms
+RETURNING job_type, version, successes, failures, total_latency_ms
+`
+
+type RecordHandlerVersionOutcomeParams struct {
+	JobType   string `json:"job_type"`
+	Version   string `json:"version"`
+	Successes int64  `json:"successes"`
+	Failures  int64  `json:"failures"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+func (q *Queries) RecordHandlerVersionOutcome(ctx context.Context, arg RecordHandlerVersionOutcomeParams) (HandlerVersionStat, error) {
+	row := q.db.QueryRow(ctx, recordHandlerVersionOutcome,
+		arg.JobType,
+		arg.Version,
+		arg.Successes,
+		arg.Failures,
+		arg.LatencyMs,
+	)
+	var i HandlerVersionStat
+	err := row.Scan(
+		&i.JobType,
+		&i.Version,
+		&i.Successes,
+		&i.Failures,
+		&i.TotalLatencyMs,
+	)
+	return i, err
+}
+
+const recordSideEffect = `-- name: RecordSideEffect :exec
+INSERT INTO job_side_effects (key, job_id, outcome)
+VALUES ($1, $2, $3)
+ON CONFLICT (key) DO NOTHING
+`
+
+type RecordSideEffectParams struct {
+	Key     string `json:"key"`
+	JobID   string `json:"job_id"`
+	Outcome []byte `json:"outcome"`
+}
+
+func (q *Queries) RecordSideEffect(ctx context.Context, arg RecordSideEffectParams) error {
+	_, err := q.db.Exec(ctx, recordSideEffect, arg.Key, arg.JobID, arg.Outcome)
+	return err
+}
+
+const registerWorker = `-- name: RegisterWorker :one
+INSERT INTO workers (id, hostname, labels, handler_versions)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (id) DO UPDATE SET
+    labels = EXCLUDED.labels,
+    handler_versions = EXCLUDED.handler_versions,
+    last_seen_at = NOW()
+RETURNING id, hostname, labels, handler_versions, started_at, last_seen_at
+`
+
+type RegisterWorkerParams struct {
+	ID              string   `json:"id"`
+	Hostname        string   `json:"hostname"`
+	Labels          []string `json:"labels"`
+	HandlerVersions []byte   `json:"handler_versions"`
+}
+
+func (q *Queries) RegisterWorker(ctx context.Context, arg RegisterWorkerParams) (Worker, error) {
+	row := q.db.QueryRow(ctx, registerWorker,
+		arg.ID,
+		arg.Hostname,
+		arg.Labels,
+		arg.HandlerVersions,
+	)
+	var i Worker
+	err := row.Scan(
+		&i.ID,
+		&i.Hostname,
+		&i.Labels,
+		&i.HandlerVersions,
+		&i.StartedAt,
+		&i.LastSeenAt,
+	)
+	return i, err
+}
+
+const releaseQuarantinedJob = `-- name: ReleaseQuarantinedJob :one
+UPDATE jobs
+SET 
+    status = 'pending',
+    lost_attempts = 0,
+    scheduled_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+    AND status = 'quarantined'
+RETURNING id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id
+`
+
+func (q *Queries) ReleaseQuarantinedJob(ctx context.Context, id string) (Job, error) {
+	row := q.db.QueryRow(ctx, releaseQuarantinedJob, id)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Type,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.ErrorMessage,
+		&i.ScheduledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LeaseToken,
+		&i.LeaseExpiresAt,
+		&i.ExpiresAt,
+		&i.GroupKey,
+		&i.EnqueueSeq,
+		&i.CoalesceKey,
+		&i.CoalesceMode,
+		&i.CoalesceUntil,
+		&i.LostAttempts,
+		&i.ApiKeyID,
+		&i.Queue,
+		&i.WindowHeld,
+		&i.Requires,
+		&i.HandlerVersion,
+		&i.Tags,
+		&i.OrganizationID,
+	)
+	return i, err
+}
+
+const rescheduleWindowHeldJobs = `-- name: RescheduleWindowHeldJobs :execrows
+UPDATE jobs
+SET 
+    scheduled_at = $1,
+    window_held = $2,
+    updated_at = NOW()
+WHERE status = 'pending'
+    AND window_held
+    AND type = $3
+    AND queue = $4
+`
+
+type RescheduleWindowHeldJobsParams struct {
+	ScheduledAt pgtype.Timestamptz `json:"scheduled_at"`
+	WindowHeld  bool               `json:"window_held"`
+	Type        string             `json:"type"`
+	Queue       string             `json:"queue"`
+}
+
+func (q *Queries) RescheduleWindowHeldJobs(ctx context.Context, arg RescheduleWindowHeldJobsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, rescheduleWindowHeldJobs,
+		arg.ScheduledAt,
+		arg.WindowHeld,
+		arg.Type,
+		arg.Queue,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const resetCircuitBreaker = `-- name: ResetCircuitBreaker :one
+UPDATE circuit_breakers
+SET 
+    state = 'closed',
+    failures = 0,
+    successes = 0,
+    window_started_at = NOW(),
+    opened_at = NULL,
+    open_until = NULL,
+    probe_until = NULL,
+    updated_at = NOW()
+WHERE job_type = $1
+RETURNING job_type, state, failures, successes, window_started_at, opened_at, open_until, probe_until, failure_rate_threshold, min_requests, window_seconds, open_seconds, updated_at
+`
+
+func (q *Queries) ResetCircuitBreaker(ctx context.Context, jobType string) (CircuitBreaker, error) {
+	row := q.db.QueryRow(ctx, resetCircuitBreaker, jobType)
+	var i CircuitBreaker
+	err := row.Scan(
+		&i.JobType,
+		&i.State,
+		&i.Failures,
+		&i.Successes,
+		&i.WindowStartedAt,
+		&i.OpenedAt,
+		&i.OpenUntil,
+		&i.ProbeUntil,
+		&i.FailureRateThreshold,
+		&i.MinRequests,
+		&i.WindowSeconds,
+		&i.OpenSeconds,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const resetHandlerVersionStats = `-- name: ResetHandlerVersionStats :exec
+DELETE FROM handler_version_stats
+WHERE job_type = $1
+`
+
+func (q *Queries) ResetHandlerVersionStats(ctx context.Context, jobType string) error {
+	_, err := q.db.Exec(ctx, resetHandlerVersionStats, jobType)
+	return err
+}
+
+const retireRotatedAPIKey = `-- name: RetireRotatedAPIKey :one
+UPDATE api_keys
+SET expires_at = LEAST(expires_at, $1::timestamptz),
+    replaced_by = $2
+WHERE id = $3
+RETURNING id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at
+`
+
+type RetireRotatedAPIKeyParams struct {
+	OverlapEnds pgtype.Timestamptz `json:"overlap_ends"`
+	ReplacedBy  pgtype.Text        `json:"replaced_by"`
+	ID          string             `json:"id"`
+}
+
+// Lets a rotated key keep working until overlap_ends, or its own expiry if
+// that comes first.
+func (q *Queries) RetireRotatedAPIKey(ctx context.Context, arg RetireRotatedAPIKeyParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, retireRotatedAPIKey, arg.OverlapEnds, arg.ReplacedBy, arg.ID)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const revokeAdminToken = `-- name: RevokeAdminToken :execrows
+UPDATE admin_tokens
+SET revoked_at = COALESCE(revoked_at, NOW())
+WHERE id = $1 AND principal_id = $2
+`
+
+type RevokeAdminTokenParams struct {
+	ID          string `json:"id"`
+	PrincipalID string `json:"principal_id"`
+}
+
+func (q *Queries) RevokeAdminToken(ctx context.Context, arg RevokeAdminTokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeAdminToken, arg.ID, arg.PrincipalID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const rollBackHandlerRollout = `-- name: RollBackHandlerRollout :one
+UPDATE handler_rollouts
+SET 
+    state = 'rolled_back',
+    reason = $2,
+    updated_at = NOW()
+WHERE job_type = $1
+RETURNING job_type, stable_version, canary_version, canary_percent, min_jobs, max_error_rate_increase, state, reason, started_at, updated_at
+`
+
+type RollBackHandlerRolloutParams struct {
+	JobType string      `json:"job_type"`
+	Reason  pgtype.Text `json:"reason"`
+}
+
+func (q *Queries) RollBackHandlerRollout(ctx context.Context, arg RollBackHandlerRolloutParams) (HandlerRollout, error) {
+	row := q.db.QueryRow(ctx, rollBackHandlerRollout, arg.JobType, arg.Reason)
+	var i HandlerRollout
+	err := row.Scan(
+		&i.JobType,
+		&i.StableVersion,
+		&i.CanaryVersion,
+		&i.CanaryPercent,
+		&i.MinJobs,
+		&i.MaxErrorRateIncrease,
+		&i.State,
+		&i.Reason,
+		&i.StartedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const searchJobs = `-- name: SearchJobs :many
+SELECT id, type, payload, status, attempts, max_attempts, error_message, scheduled_at, created_at, updated_at, lease_token, lease_expires_at, expires_at, group_key, enqueue_seq, coalesce_key, coalesce_mode, coalesce_until, lost_attempts, api_key_id, queue, window_held, requires, handler_version, tags, organization_id FROM jobs
+WHERE ($1::text IS NULL
+        OR api_key_id = $1::text
+        OR organization_id = $2::text)
+    AND ($3::text IS NULL OR api_key_id = $3::text)
+    AND ($4::text IS NULL OR organization_id = $4::text)
+    AND (cardinality($5::text[]) = 0 OR status = ANY($5::text[]))
+    AND ($6::text IS NULL OR type = $6::text)
+    AND ($7::text IS NULL OR queue = $7::text)
+    AND ($8::timestamptz IS NULL OR created_at >= $8::timestamptz)
+    AND ($9::timestamptz IS NULL OR created_at < $9::timestamptz)
+    AND ($10::timestamptz IS NULL OR updated_at >= $10::timestamptz)
+    AND ($11::timestamptz IS NULL OR updated_at < $11::timestamptz)
+    AND ($12::text IS NULL OR error_message ILIKE $12::text)
+    AND tags @> $13::text[]
+    AND ($14::timestamptz IS NULL
+        OR (created_at, id) < ($14::timestamptz, $15::text))
+ORDER BY created_at DESC, id DESC
+LIMIT $16
+`
+
+type SearchJobsParams struct {
+	OwnerApiKeyID       pgtype.Text        `json:"owner_api_key_id"`
+	OwnerOrganizationID pgtype.Text        `json:"owner_organization_id"`
+	ApiKeyID            pgtype.Text        `json:"api_key_id"`
+	OrganizationID      pgtype.Text        `json:"organization_id"`
+	Statuses            []string           `json:"statuses"`
+	Type                pgtype.Text        `json:"type"`
+	Queue               pgtype.Text        `json:"queue"`
+	CreatedAfter        pgtype.Timestamptz `json:"created_after"`
+	CreatedBefore       pgtype.Timestamptz `json:"created_before"`
+	UpdatedAfter        pgtype.Timestamptz `json:"updated_after"`
+	UpdatedBefore       pgtype.Timestamptz `json:"updated_before"`
+	ErrorPattern        pgtype.Text        `json:"error_pattern"`
+	Tags                []string           `json:"tags"`
+	CursorCreatedAt     pgtype.Timestamptz `json:"cursor_created_at"`
+	CursorID            string             `json:"cursor_id"`
+	PageSize            int32              `json:"page_size"`
+}
+
+// Lists jobs newest first. Every filter is optional; the page starts after
+// the (cursor_created_at, cursor_id) of the last job of the previous page.
+// With owner_api_key_id set only the jobs of that key, or of
+// owner_organization_id, are listed, as for Owner.Owns.
+func (q *Queries) SearchJobs(ctx context.Context, arg SearchJobsParams) ([]Job, error) {
+	rows, err := q.db.Query(ctx, searchJobs,
+		arg.OwnerApiKeyID,
+		arg.OwnerOrganizationID,
+		arg.ApiKeyID,
+		arg.OrganizationID,
+		arg.Statuses,
+		arg.Type,
+		arg.Queue,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.UpdatedAfter,
+		arg.UpdatedBefore,
+		arg.ErrorPattern,
+		arg.Tags,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Job{}
+	for rows.Next() {
+		var i Job
+		if err := rows.Scan(
+			&i.ID,
+			&i.Type,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.MaxAttempts,
+			&i.ErrorMessage,
+			&i.ScheduledAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LeaseToken,
+			&i.LeaseExpiresAt,
+			&i.ExpiresAt,
+			&i.GroupKey,
+			&i.EnqueueSeq,
+			&i.CoalesceKey,
+			&i.CoalesceMode,
+			&i.CoalesceUntil,
+			&i.LostAttempts,
+			&i.ApiKeyID,
+			&i.Queue,
+			&i.WindowHeld,
+			&i.Requires,
+			&i.HandlerVersion,
+			&i.Tags,
+			&i.OrganizationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setAPIKeyAllowedCIDRs = `-- name: SetAPIKeyAllowedCIDRs :one
+UPDATE api_keys
+SET allowed_cidrs = $2
+WHERE id = $1
+RETURNING id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at
+`
+
+type SetAPIKeyAllowedCIDRsParams struct {
+	ID           string   `json:"id"`
+	AllowedCidrs []string `json:"allowed_cidrs"`
+}
+
+func (q *Queries) SetAPIKeyAllowedCIDRs(ctx context.Context, arg SetAPIKeyAllowedCIDRsParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, setAPIKeyAllowedCIDRs, arg.ID, arg.AllowedCidrs)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const setAPIKeyDeferOverLimit = `-- name: SetAPIKeyDeferOverLimit :one
+UPDATE api_keys
+SET defer_over_limit = $2
+WHERE id = $1
+RETURNING id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at
+`
+
+type SetAPIKeyDeferOverLimitParams struct {
+	ID             string `json:"id"`
+	DeferOverLimit bool   `json:"defer_over_limit"`
+}
+
+func (q *Queries) SetAPIKeyDeferOverLimit(ctx context.Context, arg SetAPIKeyDeferOverLimitParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, setAPIKeyDeferOverLimit, arg.ID, arg.DeferOverLimit)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const setAPIKeyExpiry = `-- name: SetAPIKeyExpiry :one
+UPDATE api_keys
+SET expires_at = $2
+WHERE id = $1 AND is_active = true
+RETURNING id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at
+`
+
+type SetAPIKeyExpiryParams struct {
+	ID        string             `json:"id"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) SetAPIKeyExpiry(ctx context.Context, arg SetAPIKeyExpiryParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, setAPIKeyExpiry, arg.ID, arg.ExpiresAt)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const setAPIKeyOrganization = `-- name: SetAPIKeyOrganization :one
+UPDATE api_keys
+SET organization_id = $2
+WHERE id = $1
+RETURNING id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at
+`
+
+type SetAPIKeyOrganizationParams struct {
+	ID             string      `json:"id"`
+	OrganizationID pgtype.Text `json:"organization_id"`
+}
+
+func (q *Queries) SetAPIKeyOrganization(ctx context.Context, arg SetAPIKeyOrganizationParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, setAPIKeyOrganization, arg.ID, arg.OrganizationID)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const setAPIKeyScopes = `-- name: SetAPIKeyScopes :one
+UPDATE api_keys
+SET scopes = $2,
+    allowed_types = $3,
+    allowed_queues = $4
+WHERE id = $1
+RETURNING id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at
+`
+
+type SetAPIKeyScopesParams struct {
+	ID            string   `json:"id"`
+	Scopes        []string `json:"scopes"`
+	AllowedTypes  []string `json:"allowed_types"`
+	AllowedQueues []string `json:"allowed_queues"`
+}
+
+func (q *Queries) SetAPIKeyScopes(ctx context.Context, arg SetAPIKeyScopesParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, setAPIKeyScopes,
+		arg.ID,
+		arg.Scopes,
+		arg.AllowedTypes,
+		arg.AllowedQueues,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const setAPIKeySigningSecret = `-- name: SetAPIKeySigningSecret :one
+UPDATE api_keys
+SET signing_secret = $2
+WHERE id = $1 AND is_active = true
+RETURNING id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at
+`
+
+type SetAPIKeySigningSecretParams struct {
+	ID            string      `json:"id"`
+	SigningSecret pgtype.Text `json:"-"`
+}
+
+// Sets, replaces or, with NULL, removes the signing secret of an active key.
+func (q *Queries) SetAPIKeySigningSecret(ctx context.Context, arg SetAPIKeySigningSecretParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, setAPIKeySigningSecret, arg.ID, arg.SigningSecret)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const setTenantWeight = `-- name: SetTenantWeight :one
+INSERT INTO tenant_schedule (tenant_id, weight, pass)
+VALUES ($1, $2, (SELECT COALESCE(MAX(s.last_start), 0) FROM tenant_schedule s))
+ON CONFLICT (tenant_id) DO UPDATE SET
+    weight = EXCLUDED.weight,
+    updated_at = NOW()
+RETURNING tenant_id, weight, pass, updated_at, last_start
+`
+
+type SetTenantWeightParams struct {
+	TenantID string `json:"tenant_id"`
+	Weight   int32  `json:"weight"`
+}
+
+func (q *Queries) SetTenantWeight(ctx context.Context, arg SetTenantWeightParams) (TenantSchedule, error) {
+	row := q.db.QueryRow(ctx, setTenantWeight, arg.TenantID, arg.Weight)
+	var i TenantSchedule
+	err := row.Scan(
+		&i.TenantID,
+		&i.Weight,
+		&i.Pass,
+		&i.UpdatedAt,
+		&i.LastStart,
+	)
+	return i, err
+}
+
+const touchAdminToken = `-- name: TouchAdminToken :exec
+UPDATE admin_tokens
+SET last_used_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) TouchAdminToken(ctx context.Context, id string) error {
+	_, err := q.db.Exec(ctx, touchAdminToken, id)
+	return err
+}
+
+const updateAPIKeyMetadata = `-- name: UpdateAPIKeyMetadata :one
+UPDATE api_keys
+SET name = COALESCE($1::text, name),
+    description = COALESCE($2::text, description)
+WHERE id = $3
+RETURNING id, name, key_hash, created_by, created_at, expires_at, last_used_at, is_active, defer_over_limit, organization_id, description, key_prefix, revoked_at, replaced_by, scopes, allowed_types, allowed_queues, signing_secret, allowed_cidrs, ip_denials, last_ip_denial_at
+`
+
+type UpdateAPIKeyMetadataParams struct {
+	Name        pgtype.Text `json:"name"`
+	Description pgtype.Text `json:"description"`
+	ID          string      `json:"id"`
+}
+
+// Edits the metadata of a key; a NULL leaves the field as it is.
+func (q *Queries) UpdateAPIKeyMetadata(ctx context.Context, arg UpdateAPIKeyMetadataParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, updateAPIKeyMetadata, arg.Name, arg.Description, arg.ID)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.IsActive,
+		&i.DeferOverLimit,
+		&i.OrganizationID,
+		&i.Description,
+		&i.KeyPrefix,
+		&i.RevokedAt,
+		&i.ReplacedBy,
+		&i.Scopes,
+		&i.AllowedTypes,
+		&i.AllowedQueues,
+		&i.SigningSecret,
+		&i.AllowedCidrs,
+		&i.IpDenials,
+		&i.LastIpDenialAt,
+	)
+	return i, err
+}
+
+const updateAdminPrincipal = `-- name: UpdateAdminPrincipal :one
+UPDATE admin_principals
+SET role = COALESCE($1::text, role),
+    is_active = COALESCE($2::boolean, is_active),
+    updated_at = NOW()
+WHERE id = $3
+RETURNING id, name, role, is_active, created_by, created_at, updated_at
+`
+
+type UpdateAdminPrincipalParams struct {
+	Role     pgtype.Text `json:"role"`
+	IsActive pgtype.Bool `json:"is_active"`
+	ID       string      `json:"id"`
+}
+
+// A NULL leaves the field as it is.
+func (q *Queries) UpdateAdminPrincipal(ctx context.Context, arg UpdateAdminPrincipalParams) (AdminPrincipal, error) {
+	row := q.db.QueryRow(ctx, updateAdminPrincipal, arg.Role, arg.IsActive, arg.ID)
+	var i AdminPrincipal
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Role,
+		&i.IsActive,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateCircuitBreaker = `-- name: UpdateCircuitBreaker :exec
+UPDATE circuit_breakers
+SET 
+    state = $2,
+    failures = $3,
+    successes = $4,
+    window_started_at = $5,
+    opened_at = $6,
+    open_until = $7,
+    probe_until = $8,
+    updated_at = NOW()
+WHERE job_type = $1
+`
+
+type UpdateCircuitBreakerParams struct {
+	JobType         string             `json:"job_type"`
+	State           string             `json:"state"`
+	Failures        int32              `json:"failures"`
+	Successes       int32              `json:"successes"`
+	WindowStartedAt pgtype.Timestamptz `json:"window_started_at"`
+	OpenedAt        pgtype.Timestamptz `json:"opened_at"`
+	OpenUntil       pgtype.Timestamptz `json:"open_until"`
+	ProbeUntil      pgtype.Timestamptz `json:"probe_until"`
+}
+
+func (q *Queries) UpdateCircuitBreaker(ctx context.Context, arg UpdateCircuitBreakerParams) error {
+	_, err := q.db.Exec(ctx, updateCircuitBreaker,
+		arg.JobType,
+		arg.State,
+		arg.Failures,
+		arg.Successes,
+		arg.WindowStartedAt,
+		arg.OpenedAt,
+		arg.OpenUntil,
+		arg.ProbeUntil,
+	)
+	return err
+}
+
+const updateJobTypeTokens = `-- name: UpdateJobTypeTokens :exec
+UPDATE job_type_limits
+SET 
+    tokens = $2,
+    refilled_at = $3
+WHERE job_type = $1
+`
+
+type UpdateJobTypeTokensParams struct {
+	JobType    string             `json:"job_type"`
+	Tokens     float64            `json:"tokens"`
+	RefilledAt pgtype.Timestamptz `json:"refilled_at"`
+}
+
+func (q *Queries) UpdateJobTypeTokens(ctx context.Context, arg UpdateJobTypeTokensParams) error {
+	_, err := q.db.Exec(ctx, updateJobTypeTokens, arg.JobType, arg.Tokens, arg.RefilledAt)
+	return err
+}
+
+const updateLastUsed = `-- name: UpdateLastUsed :exec
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) UpdateLastUsed(ctx context.Context, id string) error {
+	_, err := q.db.Exec(ctx, updateLastUsed, id)
+	return err
+}
+
+const upsertBacklogLimit = `-- name: UpsertBacklogLimit :one
+INSERT INTO backlog_limits (scope, name, max_pending)
+VALUES ($1, $2, $3)
+ON CONFLICT (scope, name) DO UPDATE SET
+    max_pending = EXCLUDED.max_pending,
+    updated_at = NOW()
+RETURNING scope, name, max_pending, updated_at
+`
+
+type UpsertBacklogLimitParams struct {
+	Scope      string `json:"scope"`
+	Name       string `json:"name"`
+	MaxPending int32  `json:"max_pending"`
+}
+
+func (q *Queries) UpsertBacklogLimit(ctx context.Context, arg UpsertBacklogLimitParams) (BacklogLimit, error) {
+	row := q.db.QueryRow(ctx, upsertBacklogLimit, arg.Scope, arg.Name, arg.MaxPending)
+	var i BacklogLimit
+	err := row.Scan(
+		&i.Scope,
+		&i.Name,
+		&i.MaxPending,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertHandlerRollout = `-- name: UpsertHandlerRollout :one
+INSERT INTO handler_rollouts (
+    job_type,
+    stable_version,
+    canary_version,
+    canary_percent,
+    min_jobs,
+    max_error_rate_increase
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+)
+ON CONFLICT (job_type) DO UPDATE SET
+    stable_version = EXCLUDED.stable_version,
+    canary_version = EXCLUDED.canary_version,
+    canary_percent = EXCLUDED.canary_percent,
+    min_jobs = EXCLUDED.min_jobs,
+    max_error_rate_increase = EXCLUDED.max_error_rate_increase,
+    state = 'active',
+    reason = NULL,
+    started_at = NOW(),
+    updated_at = NOW()
+RETURNING job_type, stable_version, canary_version, canary_percent, min_jobs, max_error_rate_increase, state, reason, started_at, updated_at
+`
+
+type UpsertHandlerRolloutParams struct {
+	JobType              string  `json:"job_type"`
+	StableVersion        string  `json:"stable_version"`
+	CanaryVersion        string  `json:"canary_version"`
+	CanaryPercent        int32   `json:"canary_percent"`
+	MinJobs              int32   `json:"min_jobs"`
+	MaxErrorRateIncrease float64 `json:"max_error_rate_increase"`
+}
+
+func (q *Queries) UpsertHandlerRollout(ctx context.Context, arg UpsertHandlerRolloutParams) (HandlerRollout, error) {
+	row := q.db.QueryRow(ctx, upsertHandlerRollout,
+		arg.JobType,
+		arg.StableVersion,
+		arg.CanaryVersion,
+		arg.CanaryPercent,
+		arg.MinJobs,
+		arg.MaxErrorRateIncrease,
+	)
+	var i HandlerRollout
+	err := row.Scan(
+		&i.JobType,
+		&i.StableVersion,
+		&i.CanaryVersion,
+		&i.CanaryPercent,
+		&i.MinJobs,
+		&i.MaxErrorRateIncrease,
+		&i.State,
+		&i.Reason,
+		&i.StartedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertJobTypeLimit = `-- name: UpsertJobTypeLimit :one
+INSERT INTO job_type_limits (job_type, max_concurrent, rate_per_second, burst, tokens)
+VALUES ($1, $2, $3, $4, $4)
+ON CONFLICT (job_type) DO UPDATE SET
+    max_concurrent = EXCLUDED.max_concurrent,
+    rate_per_second = EXCLUDED.rate_per_second,
+    burst = EXCLUDED.burst,
+    tokens = LEAST(job_type_limits.tokens, EXCLUDED.burst),
+    updated_at = NOW()
+RETURNING job_type, max_concurrent, rate_per_second, burst, tokens, refilled_at, updated_at
+`
+
+type UpsertJobTypeLimitParams struct {
+	JobType       string        `json:"job_type"`
+	MaxConcurrent pgtype.Int4   `json:"max_concurrent"`
+	RatePerSecond pgtype.Float8 `json:"rate_per_second"`
+	Burst         int32         `json:"burst"`
+}
+
+func (q *Queries) UpsertJobTypeLimit(ctx context.Context, arg UpsertJobTypeLimitParams) (JobTypeLimit, error) {
+	row := q.db.QueryRow(ctx, upsertJobTypeLimit,
+		arg.JobType,
+		arg.MaxConcurrent,
+		arg.RatePerSecond,
+		arg.Burst,
+	)
+	var i JobTypeLimit
+	err := row.Scan(
+		&i.JobType,
+		&i.MaxConcurrent,
+		&i.RatePerSecond,
+		&i.Burst,
+		&i.Tokens,
+		&i.RefilledAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertOrganization = `-- name: UpsertOrganization :one
+INSERT INTO organizations (id, name, rate_per_second, burst, defer_over_limit)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE SET
+    name = EXCLUDED.name,
+    rate_per_second = EXCLUDED.rate_per_second,
+    burst = EXCLUDED.burst,
+    defer_over_limit = EXCLUDED.defer_over_limit,
+    updated_at = NOW()
+RETURNING id, name, rate_per_second, burst, defer_over_limit, created_at, updated_at
+`
+
+type UpsertOrganizationParams struct {
+	ID             string        `json:"id"`
+	Name           string        `json:"name"`
+	RatePerSecond  pgtype.Float8 `json:"rate_per_second"`
+	Burst          pgtype.Int4   `json:"burst"`
+	DeferOverLimit bool          `json:"defer_over_limit"`
+}
+
+func (q *Queries) UpsertOrganization(ctx context.Context, arg UpsertOrganizationParams) (Organization, error) {
+	row := q.db.QueryRow(ctx, upsertOrganization,
+		arg.ID,
+		arg.Name,
+		arg.RatePerSecond,
+		arg.Burst,
+		arg.DeferOverLimit,
+	)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.RatePerSecond,
+		&i.Burst,
+		&i.DeferOverLimit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
 }
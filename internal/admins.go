@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AdminRole is the role stored in admin_principals.role. Each role has the
+// rights of the roles below it.
+type AdminRole string
+
+const (
+	// RoleViewer may read everything under /admin.
+	RoleViewer AdminRole = "viewer"
+	// RoleOperator may also change jobs, keys, limits and the like.
+	RoleOperator AdminRole = "operator"
+	// RoleOwner may also manage admin principals and read the audit log.
+	RoleOwner AdminRole = "owner"
+)
+
+// adminTokenPrefix starts every admin token, so they are not mistaken for API
+// keys.
+const adminTokenPrefix = "adm"
+
+var adminRoleRank = map[AdminRole]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleOwner:    3,
+}
+
+var (
+	ErrAdminPrincipalNotFound = errors.New("admin principal not found")
+	ErrAdminTokenNotFound     = errors.New("admin token not found")
+	// ErrLastOwner is returned when a change would leave no active owner.
+	ErrLastOwner = errors.New("cannot demote or deactivate the last active owner")
+)
+
+// ParseAdminRole validates a role from a request.
+func ParseAdminRole(role string) (AdminRole, error) {
+	if _, ok := adminRoleRank[AdminRole(role)]; !ok {
+		return "", fmt.Errorf("role must be one of %s, %s or %s", RoleViewer, RoleOperator, RoleOwner)
+	}
+	return AdminRole(role), nil
+}
+
+// Allows reports whether r has at least the rights of required.
+func (r AdminRole) Allows(required AdminRole) bool {
+	return adminRoleRank[r] >= adminRoleRank[required]
+}
+
+// RequiredAdminRole is the role an admin request needs unless its route asks
+// for more: reading takes a viewer, anything else an operator.
+func RequiredAdminRole(method string) AdminRole {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return RoleViewer
+	}
+	return RoleOperator
+}
+
+// AdminRoleFromScopes returns the highest role among scopes such as
+// admin:operator, which JWTs carry to reach the admin routes.
+func AdminRoleFromScopes(scopes []string) (AdminRole, bool) {
+	var best AdminRole
+	for _, scope := range scopes {
+		role, ok := strings.CutPrefix(scope, "admin:")
+		if !ok {
+			continue
+		}
+		if rank, known := adminRoleRank[AdminRole(role)]; known && rank > adminRoleRank[best] {
+			best = AdminRole(role)
+		}
+	}
+	return best, best != ""
+}
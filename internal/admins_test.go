@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAdminRole_Allows(t *testing.T) {
+	cases := []struct {
+		role, required AdminRole
+		allowed        bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleOperator, false},
+		{RoleOperator, RoleViewer, true},
+		{RoleOperator, RoleOwner, false},
+		{RoleOwner, RoleOperator, true},
+		{AdminRole("root"), RoleViewer, false},
+	}
+	for _, tc := range cases {
+		if got := tc.role.Allows(tc.required); got != tc.allowed {
+			t.Fatalf("%s.Allows(%s) = %v, want %v", tc.role, tc.required, got, tc.allowed)
+		}
+	}
+}
+
+func TestRequiredAdminRole(t *testing.T) {
+	if got := RequiredAdminRole(http.MethodGet); got != RoleViewer {
+		t.Fatalf("expected reads to need a viewer, got %s", got)
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if got := RequiredAdminRole(method); got != RoleOperator {
+			t.Fatalf("expected %s to need an operator, got %s", method, got)
+		}
+	}
+}
+
+func TestParseAdminRole(t *testing.T) {
+	if role, err := ParseAdminRole("operator"); err != nil || role != RoleOperator {
+		t.Fatalf("expected operator, got %q, %v", role, err)
+	}
+	if _, err := ParseAdminRole("admin"); err == nil {
+		t.Fatal("expected an unknown role to be rejected")
+	}
+}
+
+func TestAdminRoleFromScopes(t *testing.T) {
+	cases := []struct {
+		scopes []string
+		role   AdminRole
+		ok     bool
+	}{
+		{[]string{"openid", "admin:viewer"}, RoleViewer, true},
+		{[]string{"admin:owner", "admin:operator"}, RoleOwner, true},
+		{[]string{"admin:keys", "jobs:read"}, "", false},
+		{nil, "", false},
+	}
+	for _, tc := range cases {
+		role, ok := AdminRoleFromScopes(tc.scopes)
+		if role != tc.role || ok != tc.ok {
+			t.Fatalf("AdminRoleFromScopes(%v) = %q, %v, want %q, %v", tc.scopes, role, ok, tc.role, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultRotationOverlap is how long a rotated key keeps working next to its
+// replacement, so clients can switch over without downtime.
+const DefaultRotationOverlap = 24 * time.Hour
+
+// MaxRotationOverlap caps the overlap, so a leaked key cannot be kept alive
+// by rotating it.
+const MaxRotationOverlap = 30 * 24 * time.Hour
+
+// legacyKeyPrefix is given to the key replacing one created before key
+// prefixes were recorded.
+const legacyKeyPrefix = "key"
+
+// signingSecretPrefix starts every signing secret, so it is not mistaken for
+// an API key.
+const signingSecretPrefix = "sig"
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrAPIKeyInactive is returned when rotating a revoked or expired key,
+	// or setting the expiry of a revoked one.
+	ErrAPIKeyInactive = errors.New("api key is revoked or expired")
+)
+
+// rotationOverlap turns the overlap_seconds of a rotation into a duration,
+// DefaultRotationOverlap if it is not set.
+func rotationOverlap(seconds *int64) (time.Duration, error) {
+	if seconds == nil {
+		return DefaultRotationOverlap, nil
+	}
+	overlap := time.Duration(*seconds) * time.Second
+	if *seconds < 0 || overlap > MaxRotationOverlap {
+		return 0, fmt.Errorf("overlap_seconds must be between 0 and %d", int64(MaxRotationOverlap/time.Second))
+	}
+	return overlap, nil
+}
+
+// validateExpiry rejects expiry times that have already passed.
+func validateExpiry(expiresAt *time.Time, now time.Time) error {
+	if expiresAt != nil && !expiresAt.After(now) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
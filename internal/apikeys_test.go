@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotationOverlap(t *testing.T) {
+	ptr := func(v int64) *int64 { return &v }
+	cases := []struct {
+		seconds *int64
+		want    time.Duration
+		wantErr bool
+	}{
+		{nil, DefaultRotationOverlap, false},
+		{ptr(0), 0, false},
+		{ptr(3600), time.Hour, false},
+		{ptr(-1), 0, true},
+		{ptr(int64(MaxRotationOverlap/time.Second) + 1), 0, true},
+	}
+	for _, tc := range cases {
+		got, err := rotationOverlap(tc.seconds)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("rotationOverlap(%v): unexpected error %v", tc.seconds, err)
+		}
+		if got != tc.want {
+			t.Fatalf("rotationOverlap(%v) = %v, want %v", tc.seconds, got, tc.want)
+		}
+	}
+}
+
+func TestValidateExpiry(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	if err := validateExpiry(nil, now); err != nil {
+		t.Fatalf("expected no expiry to be valid, got %v", err)
+	}
+	if err := validateExpiry(&future, now); err != nil {
+		t.Fatalf("expected a future expiry to be valid, got %v", err)
+	}
+	if err := validateExpiry(&past, now); err == nil {
+		t.Fatal("expected a past expiry to be rejected")
+	}
+}
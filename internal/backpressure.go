@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+)
+
+// BacklogScope is the scope stored in backlog_limits.scope.
+type BacklogScope string
+
+const (
+	BacklogGlobal       BacklogScope = "global"
+	BacklogQueue        BacklogScope = "queue"
+	BacklogType         BacklogScope = "type"
+	BacklogAPIKey       BacklogScope = "api_key"
+	BacklogOrganization BacklogScope = "organization"
+)
+
+// DefaultQueue is the queue jobs go to when the client does not name one.
+const DefaultQueue = "default"
+
+// DefaultMaxDBLatency is the average database latency above which enqueues
+// are shed.
+const DefaultMaxDBLatency = 500 * time.Millisecond
+
+const (
+	// backlogCacheTTL is how long limits and pending counts are reused, so
+	// a burst of enqueues does not turn into a burst of COUNT(*) queries.
+	backlogCacheTTL   = 2 * time.Second
+	backlogRetryAfter = 30 * time.Second
+	latencyRetryAfter = 5 * time.Second
+)
+
+// OverloadedError is returned by Enqueue when a job is shed instead of stored.
+type OverloadedError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("queue is overloaded: %s", e.Reason)
+}
+
+// RetryAfterSeconds is RetryAfter rounded up for the Retry-After header.
+func (e *OverloadedError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+type pendingCount struct {
+	count     int64
+	countedAt time.Time
+}
+
+// LoadShedder rejects enqueues while a pending backlog is over its limit or
+// the database is answering slowly. Every query it makes is timed, so the
+// latency average keeps moving even while everything is being shed.
+type LoadShedder struct {
+	r          *Repository
+	maxLatency time.Duration
+	mu         sync.Mutex
+	latency    time.Duration // moving average
+	limits     []db.BacklogLimit
+	limitsAt   time.Time
+	counts     map[string]pendingCount
+}
+
+func NewLoadShedder(r *Repository, maxLatency time.Duration) *LoadShedder {
+	return &LoadShedder{
+		r:          r,
+		maxLatency: maxLatency,
+		counts:     make(map[string]pendingCount),
+	}
+}
+
+// Observe feeds one database round trip into the latency average.
+func (s *LoadShedder) Observe(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = (s.latency*4 + latency) / 5
+	}
+}
+
+func (s *LoadShedder) degraded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxLatency > 0 && s.latency > s.maxLatency
+}
+
+// Admit returns an *OverloadedError if job should be shed.
+func (s *LoadShedder) Admit(ctx context.Context, job db.Job) error {
+	limits, err := s.backlogLimits(ctx)
+	if err != nil {
+		return err
+	}
+	if s.degraded() {
+		return &OverloadedError{
+			Reason:     "database latency is degraded",
+			RetryAfter: latencyRetryAfter,
+		}
+	}
+	for _, limit := range applicableLimits(limits, job) {
+		pending, err := s.pending(ctx, BacklogScope(limit.Scope), limit.Name)
+		if err != nil {
+			return err
+		}
+		if pending >= int64(limit.MaxPending) {
+			return &OverloadedError{
+				Reason:     fmt.Sprintf("pending backlog for %s is at its limit of %d", describeLimit(limit), limit.MaxPending),
+				RetryAfter: backlogRetryAfter,
+			}
+		}
+	}
+	return nil
+}
+
+func (s *LoadShedder) backlogLimits(ctx context.Context) ([]db.BacklogLimit, error) {
+	s.mu.Lock()
+	if time.Since(s.limitsAt) < backlogCacheTTL {
+		limits := s.limits
+		s.mu.Unlock()
+		return limits, nil
+	}
+	s.mu.Unlock()
+	start := time.Now()
+	limits, err := s.r.ListBacklogLimits(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list backlog limits: %w", err)
+	}
+	s.Observe(time.Since(start))
+	s.mu.Lock()
+	s.limits, s.limitsAt = limits, time.Now()
+	s.mu.Unlock()
+	return limits, nil
+}
+
+func (s *LoadShedder) pending(ctx context.Context, scope BacklogScope, name string) (int64, error) {
+	key := string(scope) + ":" + name
+	s.mu.Lock()
+	cached, ok := s.counts[key]
+	s.mu.Unlock()
+	if ok && time.Since(cached.countedAt) < backlogCacheTTL {
+		return cached.count, nil
+	}
+	start := time.Now()
+	count, err := s.r.CountPendingJobs(ctx, scope, name)
+	if err != nil {
+		return 0, fmt.Errorf("could not count pending jobs for %s %s: %w", scope, name, err)
+	}
+	s.Observe(time.Since(start))
+	s.mu.Lock()
+	s.counts[key] = pendingCount{count: count, countedAt: time.Now()}
+	s.mu.Unlock()
+	return count, nil
+}
+
+// applicableLimits picks the limits whose backlog job would add to.
+func applicableLimits(limits []db.BacklogLimit, job db.Job) []db.BacklogLimit {
+	var applicable []db.BacklogLimit
+	for _, limit := range limits {
+		switch BacklogScope(limit.Scope) {
+		case BacklogGlobal:
+		case BacklogQueue:
+			if limit.Name != job.Queue {
+				continue
+			}
+		case BacklogType:
+			if limit.Name != job.Type {
+				continue
+			}
+		case BacklogAPIKey:
+			if !job.ApiKeyID.Valid || limit.Name != job.ApiKeyID.String {
+				continue
+			}
+		case BacklogOrganization:
+			if !job.OrganizationID.Valid || limit.Name != job.OrganizationID.String {
+				continue
+			}
+		default:
+			continue
+		}
+		applicable = append(applicable, limit)
+	}
+	return applicable
+}
+
+func describeLimit(limit db.BacklogLimit) string {
+	if BacklogScope(limit.Scope) == BacklogGlobal {
+		return "all jobs"
+	}
+	return fmt.Sprintf("%s %s", limit.Scope, limit.Name)
+}
+
+// maxDBLatencyFromEnv reads SHED_DB_LATENCY, e.g. "750ms". "0" turns
+// latency based shedding off.
+func maxDBLatencyFromEnv() time.Duration {
+	value := os.Getenv("SHED_DB_LATENCY")
+	if value == "" {
+		return DefaultMaxDBLatency
+	}
+	latency, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("invalid SHED_DB_LATENCY %q, using %s: %v", value, DefaultMaxDBLatency, err)
+		return DefaultMaxDBLatency
+	}
+	return latency
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestApplicableLimits(t *testing.T) {
+	limits := []db.BacklogLimit{
+		{Scope: string(BacklogGlobal), MaxPending: 10000},
+		{Scope: string(BacklogQueue), Name: "bulk", MaxPending: 500},
+		{Scope: string(BacklogType), Name: "send_email", MaxPending: 1000},
+		{Scope: string(BacklogType), Name: "resize_image", MaxPending: 100},
+		{Scope: string(BacklogAPIKey), Name: "key-1", MaxPending: 50},
+		{Scope: string(BacklogOrganization), Name: "org-1", MaxPending: 200},
+		{Scope: string(BacklogOrganization), Name: "org-2", MaxPending: 200},
+	}
+	job := db.Job{
+		Type:           "send_email",
+		Queue:          DefaultQueue,
+		ApiKeyID:       pgtype.Text{String: "key-1", Valid: true},
+		OrganizationID: pgtype.Text{String: "org-1", Valid: true},
+	}
+	got := applicableLimits(limits, job)
+	if len(got) != 4 {
+		t.Fatalf("expected 4 applicable limits, got %d: %+v", len(got), got)
+	}
+	for i, scope := range []BacklogScope{BacklogGlobal, BacklogType, BacklogAPIKey, BacklogOrganization} {
+		if BacklogScope(got[i].Scope) != scope {
+			t.Fatalf("limit %d: expected scope %s, got %s", i, scope, got[i].Scope)
+		}
+	}
+
+	job.ApiKeyID = pgtype.Text{}
+	job.OrganizationID = pgtype.Text{}
+	if got := applicableLimits(limits, job); len(got) != 2 {
+		t.Fatalf("expected a job without an api key to skip the key and organization limits, got %+v", got)
+	}
+}
+func TestLoadShedder_Degraded(t *testing.T) {
+	s := NewLoadShedder(nil, 100*time.Millisecond)
+	s.Observe(20 * time.Millisecond)
+	if s.degraded() {
+		t.Fatal("expected fast queries not to degrade")
+	}
+	for i := 0; i < 10; i++ {
+		s.Observe(time.Second)
+	}
+	if !s.degraded() {
+		t.Fatal("expected slow queries to degrade")
+	}
+	for i := 0; i < 20; i++ {
+		s.Observe(10 * time.Millisecond)
+	}
+	if s.degraded() {
+		t.Fatal("expected recovery once queries are fast again")
+	}
+
+	off := NewLoadShedder(nil, 0)
+	off.Observe(time.Minute)
+	if off.degraded() {
+		t.Fatal("expected a zero max latency to turn shedding off")
+	}
+}
+func TestOverloadedError_RetryAfterSeconds(t *testing.T) {
+	err := &OverloadedError{RetryAfter: 1500 * time.Millisecond}
+	if got := err.RetryAfterSeconds(); got != 2 {
+		t.Fatalf("expected 2, got %d", got)
+	}
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// BreakerState is the state stored in circuit_breakers.state.
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+var ErrCircuitBreakerNotFound = errors.New("circuit breaker not found")
+
+// recordBreakerOutcome applies one job result to b. A closed breaker opens
+// once the failure rate over its window reaches the threshold; a half open
+// breaker closes on a successful probe and opens again on a failed one.
+func recordBreakerOutcome(b db.CircuitBreaker, failed bool, now time.Time) db.CircuitBreaker {
+	switch BreakerState(b.State) {
+	case BreakerHalfOpen:
+		if failed {
+			return openBreaker(b, now)
+		}
+		return closeBreaker(b, now)
+	case BreakerOpen:
+		// a job that was already running when the breaker opened
+		return b
+	}
+	window := time.Duration(b.WindowSeconds) * time.Second
+	if now.Sub(b.WindowStartedAt.Time) > window {
+		b.Failures, b.Successes = 0, 0
+		b.WindowStartedAt = pgtype.Timestamptz{Time: now, Valid: true}
+	}
+	if failed {
+		b.Failures++
+	} else {
+		b.Successes++
+	}
+	total := b.Failures + b.Successes
+	if total >= b.MinRequests && float64(b.Failures)/float64(total) >= b.FailureRateThreshold {
+		return openBreaker(b, now)
+	}
+	return b
+}
+
+// admitThroughBreaker decides whether a freshly dequeued job may run. Once an
+// open breaker's cool-down has passed the job becomes the half open probe,
+// holding the probe slot until probeUntil. It returns the updated breaker and
+// how long to defer the job, zero if it may run.
+func admitThroughBreaker(b db.CircuitBreaker, probeUntil time.Time, now time.Time) (db.CircuitBreaker, time.Duration) {
+	switch BreakerState(b.State) {
+	case BreakerOpen:
+		if now.Before(b.OpenUntil.Time) {
+			return b, b.OpenUntil.Time.Sub(now)
+		}
+	case BreakerHalfOpen:
+		if now.Before(b.ProbeUntil.Time) {
+			return b, b.ProbeUntil.Time.Sub(now)
+		}
+	default:
+		return b, 0
+	}
+	b.State = string(BreakerHalfOpen)
+	b.ProbeUntil = pgtype.Timestamptz{Time: probeUntil, Valid: true}
+	return b, 0
+}
+
+func openBreaker(b db.CircuitBreaker, now time.Time) db.CircuitBreaker {
+	b.State = string(BreakerOpen)
+	b.OpenedAt = pgtype.Timestamptz{Time: now, Valid: true}
+	b.OpenUntil = pgtype.Timestamptz{Time: now.Add(time.Duration(b.OpenSeconds) * time.Second), Valid: true}
+	b.ProbeUntil = pgtype.Timestamptz{}
+	return b
+}
+
+func closeBreaker(b db.CircuitBreaker, now time.Time) db.CircuitBreaker {
+	b.State = string(BreakerClosed)
+	b.Failures, b.Successes = 0, 0
+	b.WindowStartedAt = pgtype.Timestamptz{Time: now, Valid: true}
+	b.OpenedAt = pgtype.Timestamptz{}
+	b.OpenUntil = pgtype.Timestamptz{}
+	b.ProbeUntil = pgtype.Timestamptz{}
+	return b
+}
+
+func updateBreakerParams(b db.CircuitBreaker) db.UpdateCircuitBreakerParams {
+	return db.UpdateCircuitBreakerParams{
+		JobType:         b.JobType,
+		State:           b.State,
+		Failures:        b.Failures,
+		Successes:       b.Successes,
+		WindowStartedAt: b.WindowStartedAt,
+		OpenedAt:        b.OpenedAt,
+		OpenUntil:       b.OpenUntil,
+		ProbeUntil:      b.ProbeUntil,
+	}
+}
+
+// admitBreaker runs inside the dequeue transaction, see admitJob.
+func admitBreaker(ctx context.Context, q *db.Queries, job db.Job) (time.Duration, error) {
+	b, err := q.GetCircuitBreakerForUpdate(ctx, job.Type)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not get circuit breaker for %s: %w", job.Type, err)
+	}
+	next, delay := admitThroughBreaker(b, job.LeaseExpiresAt.Time, time.Now())
+	if next.State != b.State || next.ProbeUntil != b.ProbeUntil {
+		if err := q.UpdateCircuitBreaker(ctx, updateBreakerParams(next)); err != nil {
+			return 0, fmt.Errorf("could not update circuit breaker for %s: %w", job.Type, err)
+		}
+	}
+	return delay, nil
+}
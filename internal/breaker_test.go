@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func newTestBreaker(now time.Time) db.CircuitBreaker {
+	return db.CircuitBreaker{
+		JobType:              "send_email",
+		State:                string(BreakerClosed),
+		WindowStartedAt:      pgtype.Timestamptz{Time: now, Valid: true},
+		FailureRateThreshold: 0.5,
+		MinRequests:          4,
+		WindowSeconds:        60,
+		OpenSeconds:          30,
+	}
+}
+
+func TestRecordBreakerOutcome_OpensAtThreshold(t *testing.T) {
+	now := time.Now()
+	b := newTestBreaker(now)
+	b = recordBreakerOutcome(b, false, now)
+	b = recordBreakerOutcome(b, true, now)
+	b = recordBreakerOutcome(b, true, now)
+	if b.State != string(BreakerClosed) {
+		t.Fatalf("breaker should stay closed below min_requests, got %s", b.State)
+	}
+	b = recordBreakerOutcome(b, false, now)
+	if b.State != string(BreakerOpen) {
+		t.Fatalf("expected breaker to open at 50%% failures, got %s", b.State)
+	}
+	if !b.OpenUntil.Time.Equal(now.Add(30 * time.Second)) {
+		t.Fatalf("expected open_until 30s out, got %v", b.OpenUntil.Time)
+	}
+}
+func TestRecordBreakerOutcome_WindowResets(t *testing.T) {
+	now := time.Now()
+	b := newTestBreaker(now)
+	for i := 0; i < 3; i++ {
+		b = recordBreakerOutcome(b, true, now)
+	}
+	b = recordBreakerOutcome(b, true, now.Add(2*time.Minute))
+	if b.State != string(BreakerClosed) || b.Failures != 1 {
+		t.Fatalf("expected a fresh window with one failure, got %s with %d failures", b.State, b.Failures)
+	}
+}
+func TestAdmitThroughBreaker_HalfOpenProbe(t *testing.T) {
+	now := time.Now()
+	b := openBreaker(newTestBreaker(now), now)
+
+	if _, delay := admitThroughBreaker(b, now.Add(time.Minute), now.Add(10*time.Second)); delay != 20*time.Second {
+		t.Fatalf("expected job to wait out the open breaker, got %v", delay)
+	}
+	probeTime := now.Add(31 * time.Second)
+	b, delay := admitThroughBreaker(b, probeTime.Add(time.Minute), probeTime)
+	if delay != 0 || b.State != string(BreakerHalfOpen) {
+		t.Fatalf("expected the first job after cool-down to probe, got %s delay %v", b.State, delay)
+	}
+	if _, delay := admitThroughBreaker(b, probeTime.Add(time.Minute), probeTime); delay == 0 {
+		t.Fatal("only one probe should run at a time")
+	}
+	if closed := recordBreakerOutcome(b, false, probeTime); closed.State != string(BreakerClosed) {
+		t.Fatalf("successful probe should close the breaker, got %s", closed.State)
+	}
+	if reopened := recordBreakerOutcome(b, true, probeTime); reopened.State != string(BreakerOpen) {
+		t.Fatalf("failed probe should reopen the breaker, got %s", reopened.State)
+	}
+}
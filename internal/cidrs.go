@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// NormalizeCIDRs validates the allowlist of an API key and returns it
+// deduplicated and sorted. A bare address stands for itself alone, e.g.
+// 10.0.0.7 becomes 10.0.0.7/32, and host bits are cleared, so 10.1.2.3/16
+// becomes 10.1.0.0/16.
+func NormalizeCIDRs(cidrs []string) ([]string, error) {
+	seen := make(map[string]bool, len(cidrs))
+	normalized := []string{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		prefix, err := parseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		if s := prefix.String(); !seen[s] {
+			seen[s] = true
+			normalized = append(normalized, s)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+func parseCIDR(cidr string) (netip.Prefix, error) {
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// CIDRsAllow reports whether ip is in one of cidrs. An empty allowlist
+// allows every address; an ip that does not parse is allowed by none.
+func CIDRsAllow(cidrs []string, ip string) bool {
+	if len(cidrs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	// IPv4 clients may show up as ::ffff:a.b.c.d on dual stack listeners
+	addr = addr.Unmap()
+	for _, cidr := range cidrs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// coversCIDRs checks that every network in requested lies inside one of
+// allowed, both lists as NormalizeCIDRs returns them. Like coversList, an
+// empty allowed list covers anything and an empty requested list only that.
+func coversCIDRs(allowed, requested []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	if len(requested) == 0 {
+		return fmt.Errorf("must be limited to %v", allowed)
+	}
+	for _, cidr := range requested {
+		inner, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		if !slices.ContainsFunc(allowed, func(a string) bool {
+			outer, err := netip.ParsePrefix(a)
+			return err == nil && outer.Bits() <= inner.Bits() && outer.Contains(inner.Addr())
+		}) {
+			return fmt.Errorf("cannot grant %s", cidr)
+		}
+	}
+	return nil
+}
+
+// ParseTrustedProxies reads a comma separated CIDR list such as
+// TRUSTED_PROXIES, e.g. "10.0.0.0/8, 192.168.1.10".
+func ParseTrustedProxies(s string) ([]string, error) {
+	cidrs := []string{}
+	for _, cidr := range strings.Split(s, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return NormalizeCIDRs(cidrs)
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeCIDRs(t *testing.T) {
+	got, err := NormalizeCIDRs([]string{" 10.1.2.3/16 ", "192.168.1.7", "10.1.0.0/16", "2001:db8::1/32"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"10.1.0.0/16", "192.168.1.7/32", "2001:db8::/32"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got, err := NormalizeCIDRs(nil); err != nil || got == nil || len(got) != 0 {
+		t.Fatalf("expected an empty allowlist, got %v, %v", got, err)
+	}
+	for _, invalid := range []string{"10.0.0.0/33", "example.com", ""} {
+		if _, err := NormalizeCIDRs([]string{invalid}); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestCIDRsAllow(t *testing.T) {
+	office := []string{"10.1.0.0/16", "192.168.1.7/32", "2001:db8::/32"}
+	cases := []struct {
+		cidrs   []string
+		ip      string
+		allowed bool
+	}{
+		{nil, "203.0.113.9", true},
+		{office, "10.1.200.4", true},
+		{office, "10.2.0.1", false},
+		{office, "192.168.1.7", true},
+		{office, "192.168.1.8", false},
+		{office, "::ffff:10.1.0.9", true},
+		{office, "2001:db8:5::1", true},
+		{office, "", false},
+	}
+	for _, tc := range cases {
+		if got := CIDRsAllow(tc.cidrs, tc.ip); got != tc.allowed {
+			t.Fatalf("CIDRsAllow(%v, %q) = %v, want %v", tc.cidrs, tc.ip, got, tc.allowed)
+		}
+	}
+}
+
+func TestCoversCIDRs(t *testing.T) {
+	cases := []struct {
+		allowed, requested []string
+		ok                 bool
+	}{
+		{nil, nil, true},
+		{nil, []string{"0.0.0.0/0"}, true},
+		{[]string{"10.0.0.0/8"}, []string{"10.1.0.0/16", "10.2.3.4/32"}, true},
+		{[]string{"10.0.0.0/8"}, []string{"10.0.0.0/8"}, true},
+		{[]string{"10.0.0.0/8"}, nil, false},
+		{[]string{"10.1.0.0/16"}, []string{"10.0.0.0/8"}, false},
+		{[]string{"10.0.0.0/8"}, []string{"10.1.0.0/16", "192.168.0.0/16"}, false},
+		{[]string{"10.0.0.0/8"}, []string{"2001:db8::/32"}, false},
+	}
+	for _, tc := range cases {
+		if err := coversCIDRs(tc.allowed, tc.requested); (err == nil) != tc.ok {
+			t.Fatalf("coversCIDRs(%v, %v) = %v, want ok %v", tc.allowed, tc.requested, err, tc.ok)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	got, err := ParseTrustedProxies("10.0.0.0/8, ,192.168.1.10")
+	if err != nil || !slices.Equal(got, []string{"10.0.0.0/8", "192.168.1.10/32"}) {
+		t.Fatalf("unexpected proxies %v, %v", got, err)
+	}
+	if got, err := ParseTrustedProxies(""); err != nil || len(got) != 0 {
+		t.Fatalf("expected no trusted proxies, got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Kinds of client certificate identities. An identity is written kind:value,
+// e.g. dns:billing.internal or uri:spiffe://cluster.local/ns/billing/sa/api.
+const (
+	IdentityDNS   = "dns"
+	IdentityURI   = "uri"
+	IdentityEmail = "email"
+	// IdentityCN is the subject common name, for certificates without SANs.
+	IdentityCN = "cn"
+)
+
+var (
+	ErrClientIdentityNotFound = errors.New("client identity not found")
+	// ErrClientIdentityTaken is returned when the identity already
+	// authenticates another key, or the same one.
+	ErrClientIdentityTaken = errors.New("client identity already belongs to an api key")
+)
+
+// ParseClientIdentity validates and normalizes an identity from a request.
+// DNS names are matched case-insensitively, so they are stored lowercase.
+func ParseClientIdentity(identity string) (string, error) {
+	kind, value, ok := strings.Cut(strings.TrimSpace(identity), ":")
+	kind = strings.ToLower(kind)
+	value = strings.TrimSpace(value)
+	if !ok || value == "" {
+		return "", fmt.Errorf("identity must be written kind:value, e.g. dns:billing.internal")
+	}
+	switch kind {
+	case IdentityDNS:
+		value = strings.ToLower(value)
+	case IdentityURI:
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" {
+			return "", fmt.Errorf("uri identity must be an absolute URI")
+		}
+	case IdentityEmail:
+		if !strings.Contains(value, "@") {
+			return "", fmt.Errorf("email identity must be an email address")
+		}
+	case IdentityCN:
+	default:
+		return "", fmt.Errorf("identity kind must be one of %s, %s, %s or %s", IdentityDNS, IdentityURI, IdentityEmail, IdentityCN)
+	}
+	return kind + ":" + value, nil
+}
+
+// CertificateIdentities lists every identity cert may authenticate as: its
+// DNS, URI and email SANs and its subject common name.
+func CertificateIdentities(cert *x509.Certificate) []string {
+	identities := []string{}
+	for _, name := range cert.DNSNames {
+		identities = append(identities, IdentityDNS+":"+strings.ToLower(name))
+	}
+	for _, uri := range cert.URIs {
+		identities = append(identities, IdentityURI+":"+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		identities = append(identities, IdentityEmail+":"+email)
+	}
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, IdentityCN+":"+cert.Subject.CommonName)
+	}
+	return identities
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestParseClientIdentity(t *testing.T) {
+	cases := []struct {
+		identity string
+		want     string
+		valid    bool
+	}{
+		{"dns:Billing.Internal", "dns:billing.internal", true},
+		{" URI:spiffe://cluster.local/ns/billing/sa/api ", "uri:spiffe://cluster.local/ns/billing/sa/api", true},
+		{"email:ops@example.com", "email:ops@example.com", true},
+		{"cn:billing-service", "cn:billing-service", true},
+		{"billing.internal", "", false},
+		{"dns:", "", false},
+		{"uri:not a uri", "", false},
+		{"email:ops", "", false},
+		{"ip:10.0.0.1", "", false},
+	}
+	for _, tc := range cases {
+		got, err := ParseClientIdentity(tc.identity)
+		if (err == nil) != tc.valid || got != tc.want {
+			t.Fatalf("ParseClientIdentity(%q) = %q, %v, want %q, valid=%v", tc.identity, got, err, tc.want, tc.valid)
+		}
+	}
+}
+
+func TestCertificateIdentities(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/billing/sa/api")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "billing-service"},
+		DNSNames:       []string{"Billing.Internal"},
+		URIs:           []*url.URL{spiffe},
+		EmailAddresses: []string{"ops@example.com"},
+	}
+	want := []string{
+		"dns:billing.internal",
+		"uri:spiffe://cluster.local/ns/billing/sa/api",
+		"email:ops@example.com",
+		"cn:billing-service",
+	}
+	if got := CertificateIdentities(cert); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for _, identity := range want {
+		if parsed, err := ParseClientIdentity(identity); err != nil || parsed != identity {
+			t.Fatalf("expected %s to be stored as is, got %q, %v", identity, parsed, err)
+		}
+	}
+}
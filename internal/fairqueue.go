@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+)
+
+// chargeTenant bills the job to its tenant in the fair queue, which also
+// moves the scheduler clock to the job's start tag. It runs inside the
+// dequeue transaction, after the job has been admitted.
+func chargeTenant(ctx context.Context, q *db.Queries, job db.Job) error {
+	if _, err := q.ChargeTenant(ctx, job.ApiKeyID.String); err != nil {
+		return fmt.Errorf("could not charge tenant %q: %w", job.ApiKeyID.String, err)
+	}
+	return nil
+}
+
+// ensureTenant gives the tenant of a job about to be enqueued its place in
+// the fair queue; dequeues only look at tenants that have one.
+func ensureTenant(ctx context.Context, q *db.Queries, apiKeyID string) error {
+	if err := q.EnsureTenant(ctx, apiKeyID); err != nil {
+		return fmt.Errorf("could not add tenant %q to the fair queue: %w", apiKeyID, err)
+	}
+	return nil
+}
@@ -2,8 +2,11 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/franzego/distributed_task_queue/authutil"
@@ -11,6 +14,7 @@ import (
 	"github.com/franzego/distributed_task_queue/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Handler struct {
@@ -42,49 +46,212 @@ func (h *Handler) PostJob(c *gin.Context) {
 		})
 		return
 	}
+	var expiresAt pgtype.Timestamptz
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Message: "expires_at must be in the future",
+			})
+			return
+		}
+		expiresAt = pgtype.Timestamptz{Time: *req.ExpiresAt, Valid: true}
+	}
+	queue := req.Queue
+	if queue == "" {
+		queue = DefaultQueue
+	}
+	requires, err := NormalizeLabels(req.Requires)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid requires labels",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err := callerPermissions(c).Allows(req.Type, queue); err != nil {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Message: "Api key may not submit this job",
+			Error:   err.Error(),
+		})
+		return
+	}
+	tags, err := NormalizeLabels(req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid tags",
+			Error:   err.Error(),
+		})
+		return
+	}
 	uuid := uuid.New().String()
 	job := db.Job{
 		ID:          uuid,
 		Type:        req.Type,
 		Payload:     req.Payload,
-		Status:      "pending",
+		Status:      string(StatusPending),
 		MaxAttempts: 3,
+		ExpiresAt:   expiresAt,
+		GroupKey:    pgtype.Text{String: req.GroupKey, Valid: req.GroupKey != ""},
+		ApiKeyID:    pgtype.Text{String: c.GetString("api_key_id"), Valid: c.GetString("api_key_id") != ""},
+		Queue:       queue,
+		Requires:    requires,
+		Tags:        tags,
+		// kept on the job so it stays visible to the organization after the
+		// key is rotated or moved
+		OrganizationID: optionalText(c.GetString("organization_id")),
+	}
+	if req.Coalesce != nil {
+		if err := applyCoalesce(&job, *req.Coalesce, time.Now()); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Message: "Invalid coalesce options",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+	// set by the rate limiter for keys that defer instead of getting a 429
+	deferUntil := c.GetTime("defer_until")
+	deferred := !deferUntil.IsZero()
+	if deferred {
+		applyDeferral(&job, deferUntil)
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	if err := h.q.Enqueue(ctx, job); err != nil {
+	enqueued, err := h.q.Enqueue(ctx, job, deferUntil)
+	var overloaded *OverloadedError
+	if errors.As(err, &overloaded) {
+		c.Header("Retry-After", strconv.Itoa(overloaded.RetryAfterSeconds()))
+		c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{
+			Message: "Queue is overloaded, retry later",
+			Error:   overloaded.Error(),
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Message: "Failed to Enqueue job",
 			Error:   err.Error(),
 		})
 		return
 	}
-	c.JSON(http.StatusOK, models.SuccessMessage{
+	response := models.SuccessMessage{
 		Message: "Message successfully received",
-		ID:      fmt.Sprintf("Message of id: %s", job.ID),
-	})
+		ID:      fmt.Sprintf("Message of id: %s", enqueued.ID),
+	}
+	if enqueued.ID != job.ID {
+		response.Message = "Message coalesced into a pending job"
+	}
+	if deferred {
+		response.Message = "Rate limit exceeded, job accepted and deferred"
+		response.ScheduledAt = &enqueued.ScheduledAt.Time
+	}
+	c.JSON(http.StatusOK, response)
+
+}
+
+// applyDeferral pushes job back to deferUntil unless it is already due later.
+// A coalesced job's window is stretched as well so the deferral is not cut
+// short.
+func applyDeferral(job *db.Job, deferUntil time.Time) {
+	if job.ScheduledAt.Valid && !job.ScheduledAt.Time.Before(deferUntil) {
+		return
+	}
+	job.ScheduledAt = pgtype.Timestamptz{Time: deferUntil, Valid: true}
+	if job.CoalesceUntil.Valid && job.CoalesceUntil.Time.Before(deferUntil) {
+		job.CoalesceUntil = job.ScheduledAt
+	}
+}
 
+// applyCoalesce validates opts and fills in the coalescing columns of job.
+// opts is a copy, so filling in its defaults leaves the request as it was.
+func applyCoalesce(job *db.Job, opts models.CoalesceOptions, now time.Time) error {
+	if opts.Key == "" {
+		return fmt.Errorf("coalesce key is required")
+	}
+	if opts.DelaySeconds <= 0 {
+		return fmt.Errorf("delay_seconds must be positive")
+	}
+	if opts.WindowSeconds == 0 {
+		opts.WindowSeconds = opts.DelaySeconds
+	}
+	if opts.WindowSeconds < opts.DelaySeconds {
+		return fmt.Errorf("window_seconds cannot be shorter than delay_seconds")
+	}
+	if opts.Mode == "" {
+		opts.Mode = CoalesceReplace
+	}
+	if opts.Mode != CoalesceReplace && opts.Mode != CoalesceMerge {
+		return fmt.Errorf("mode must be %q or %q", CoalesceReplace, CoalesceMerge)
+	}
+	job.CoalesceKey = pgtype.Text{String: opts.Key, Valid: true}
+	job.CoalesceMode = pgtype.Text{String: opts.Mode, Valid: true}
+	job.ScheduledAt = pgtype.Timestamptz{Time: now.Add(time.Duration(opts.DelaySeconds) * time.Second), Valid: true}
+	job.CoalesceUntil = pgtype.Timestamptz{Time: now.Add(time.Duration(opts.WindowSeconds) * time.Second), Valid: true}
+	return nil
 }
 
 // Get Request To Get the Status of a particulat job using the uuid
 func (h *Handler) GetStatus(c *gin.Context) {
-	uuid := c.Query("id")
-	job, err := h.q.GetJob(c.Request.Context(), uuid)
-	if err != nil {
+	h.respondJob(c, callerOwner(c))
+}
+
+// Get Request For Admin to see any job, whichever API key submitted it
+func (h *Handler) GetAdminJob(c *gin.Context) {
+	h.respondJob(c, AdminOwner)
+}
+func (h *Handler) respondJob(c *gin.Context, owner Owner) {
+	job, err := h.q.GetJob(c.Request.Context(), c.Param("id"), owner)
+	if errors.Is(err, ErrJobNotFound) {
 		c.JSON(http.StatusNotFound, models.ErrorResponse{
 			Message: "UUID could not be found",
 		})
 		return
 	}
-	// jsonJob, err := json.Marshal(&job)
-	// if err != nil {
-	// 	c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-	// 		Message: "Error in marshalling job",
-	// 	})
-	// 	return
-	// }
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not get job",
+			Error:   err.Error(),
+		})
+		return
+	}
 	c.JSON(http.StatusOK, job)
+}
+
+// Post Request To cancel one of the caller's own jobs before it runs
+func (h *Handler) PostCancelJob(c *gin.Context) {
+	h.cancelJob(c, callerOwner(c))
+}
+
+// Post Request For Admin to cancel any job before it runs
+func (h *Handler) PostAdminCancelJob(c *gin.Context) {
+	h.cancelJob(c, AdminOwner)
+}
 
+// callerOwner scopes job operations to the authenticated API key and its
+// organization.
+func callerOwner(c *gin.Context) Owner {
+	return APIKeyOwner(c.GetString("api_key_id"), c.GetString("organization_id"))
+}
+func (h *Handler) cancelJob(c *gin.Context, owner Owner) {
+	job, err := h.q.CancelJob(c.Request.Context(), c.Param("id"), owner)
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "UUID could not be found",
+		})
+	case errors.Is(err, ErrIllegalTransition):
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Message: "Only pending or quarantined jobs can be cancelled",
+			Error:   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not cancel job",
+			Error:   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, job)
+	}
 }
 
 // Post Request For Admin to create Api Keys
@@ -93,6 +260,16 @@ func (h *Handler) PostAdminApiKey(c *gin.Context) {
 		Name        string `json:"name"` //name of the api key
 		Description string `json:"description"`
 		Prefix      string `json:"prefix"`
+		// accept over-limit job submissions for later instead of a 429
+		DeferOverLimit bool       `json:"defer_over_limit"`
+		OrganizationID string     `json:"organization_id"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+		// nil gives the key DefaultScopes
+		Scopes        []string `json:"scopes"`
+		AllowedTypes  []string `json:"allowed_types"`
+		AllowedQueues []string `json:"allowed_queues"`
+		// networks the key may be used from; empty allows all
+		AllowedCIDRs []string `json:"allowed_cidrs"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
@@ -101,6 +278,46 @@ func (h *Handler) PostAdminApiKey(c *gin.Context) {
 		})
 		return
 	}
+	if err := validateExpiry(req.ExpiresAt, time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	permissions, err := NewKeyPermissions(req.Scopes, req.AllowedTypes, req.AllowedQueues)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid permissions",
+			Error:   err.Error(),
+		})
+		return
+	}
+	allowedCIDRs, err := NormalizeCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid allowed_cidrs",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if org, limited := keyAdminOrganization(c); limited {
+		if req.OrganizationID != "" && req.OrganizationID != org {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Message: "Api keys can only create keys in their own organization",
+			})
+			return
+		}
+		req.OrganizationID = org
+		permissions.AllowedCIDRs = allowedCIDRs
+		if err := callerPermissions(c).Covers(permissions); err != nil {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Message: "Api keys cannot grant permissions they do not have",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
 	key, err := authutil.KeyGenerator(req.Prefix)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
@@ -111,10 +328,26 @@ func (h *Handler) PostAdminApiKey(c *gin.Context) {
 	hashKey := authutil.HashApiKeys(key)
 
 	newKey, err := h.q.CreateAPIKey(c.Request.Context(), db.CreateAPIKeyParams{
-		ID:      uuid.New().String(),
-		Name:    req.Name,
-		KeyHash: hashKey,
+		ID:             uuid.New().String(),
+		Name:           req.Name,
+		KeyHash:        hashKey,
+		CreatedBy:      optionalText(c.GetString("admin_principal")),
+		DeferOverLimit: req.DeferOverLimit,
+		OrganizationID: optionalText(req.OrganizationID),
+		Description:    req.Description,
+		KeyPrefix:      authutil.KeyPrefix(key),
+		ExpiresAt:      optionalTime(req.ExpiresAt),
+		Scopes:         permissions.Scopes,
+		AllowedTypes:   permissions.AllowedTypes,
+		AllowedQueues:  permissions.AllowedQueues,
+		AllowedCidrs:   allowedCIDRs,
 	})
+	if errors.Is(err, ErrOrganizationNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "Organization could not be found",
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Message: "Failed to create Api key",
@@ -122,17 +355,25 @@ func (h *Handler) PostAdminApiKey(c *gin.Context) {
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{
-		"id":         newKey.ID,
-		"name":       newKey.Name,
-		"key":        key,
-		"created_at": newKey.CreatedAt,
-		"warning":    "Save this key securely. It wont be shown again",
+		"id":              newKey.ID,
+		"name":            newKey.Name,
+		"key":             key,
+		"key_prefix":      newKey.KeyPrefix,
+		"organization_id": newKey.OrganizationID,
+		"scopes":          newKey.Scopes,
+		"allowed_types":   newKey.AllowedTypes,
+		"allowed_queues":  newKey.AllowedQueues,
+		"allowed_cidrs":   newKey.AllowedCidrs,
+		"created_at":      newKey.CreatedAt,
+		"expires_at":      newKey.ExpiresAt,
+		"warning":         "Save this key securely. It wont be shown again",
 	})
 }
 
-// Get Request For Admin to list all Api keys
+// Get Request For Admin to list all Api keys, or those of the caller's organization for keys with admin:keys
 func (h *Handler) GetApiKeys(c *gin.Context) {
-	keys, err := h.q.ListAPIKeys(c.Request.Context())
+	org, _ := keyAdminOrganization(c)
+	keys, err := h.q.ListAPIKeys(c.Request.Context(), org)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Message: "Could not list Api Keys",
@@ -146,3 +387,1308 @@ func (h *Handler) GetApiKeys(c *gin.Context) {
 	})
 
 }
+
+// Patch Request For Admin to edit the name or description of an Api key
+func (h *Handler) PatchApiKey(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	var req models.APIKeyMetadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if req.Name != nil && *req.Name == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Name cannot be empty",
+		})
+		return
+	}
+	arg := db.UpdateAPIKeyMetadataParams{ID: c.Param("id")}
+	if req.Name != nil {
+		arg.Name = pgtype.Text{String: *req.Name, Valid: true}
+	}
+	if req.Description != nil {
+		arg.Description = pgtype.Text{String: *req.Description, Valid: true}
+	}
+	key, err := h.q.UpdateAPIKeyMetadata(c.Request.Context(), arg)
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not update Api key")
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// Put Request For Admin to set, extend or clear the expiry of an Api key
+func (h *Handler) PutApiKeyExpiry(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	var req models.APIKeyExpiryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err := validateExpiry(req.ExpiresAt, time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	key, err := h.q.SetAPIKeyExpiry(c.Request.Context(), c.Param("id"), optionalTime(req.ExpiresAt))
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not set Api key expiry")
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// Post Request For Admin to revoke an Api key immediately
+func (h *Handler) PostRevokeApiKey(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	if err := h.q.RevokeAPIKey(c.Request.Context(), c.Param("id")); err != nil {
+		respondAPIKeyError(c, err, "Could not revoke Api key")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Post Request For Admin to replace an Api key, keeping the old one working for an overlap period
+func (h *Handler) PostRotateApiKey(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	var req models.RotateAPIKeyRequest
+	// the body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Message: "Invalid Request",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+	overlap, err := rotationOverlap(req.OverlapSeconds)
+	if err == nil {
+		err = validateExpiry(req.ExpiresAt, time.Now())
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	key, plaintext, err := h.q.RotateAPIKey(c.Request.Context(), c.Param("id"), overlap, optionalTime(req.ExpiresAt), c.GetString("admin_principal"))
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not rotate Api key")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"id":              key.ID,
+		"name":            key.Name,
+		"key":             plaintext,
+		"key_prefix":      key.KeyPrefix,
+		"organization_id": key.OrganizationID,
+		"scopes":          key.Scopes,
+		"allowed_types":   key.AllowedTypes,
+		"allowed_queues":  key.AllowedQueues,
+		"created_at":      key.CreatedAt,
+		"expires_at":      key.ExpiresAt,
+		"warning":         "Save this key securely. It wont be shown again",
+	})
+}
+
+// Put Request For Admin to change the scopes and job type and queue allowlists of an Api key
+func (h *Handler) PutApiKeyScopes(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	var req models.APIKeyScopesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	permissions, err := NewKeyPermissions(req.Scopes, req.AllowedTypes, req.AllowedQueues)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid permissions",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if _, limited := keyAdminOrganization(c); limited {
+		caller := callerPermissions(c)
+		// the key keeps its networks, which canManageKey already checked
+		permissions.AllowedCIDRs = caller.AllowedCIDRs
+		if err := caller.Covers(permissions); err != nil {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Message: "Api keys cannot grant permissions they do not have",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+	key, err := h.q.SetAPIKeyScopes(c.Request.Context(), c.Param("id"), permissions)
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not set Api key scopes")
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// Put Request For Admin to limit the networks an Api key may be used from
+func (h *Handler) PutApiKeyAllowedCIDRs(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	var req models.APIKeyAllowedCIDRsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	allowedCIDRs, err := NormalizeCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid allowed_cidrs",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if _, limited := keyAdminOrganization(c); limited {
+		if err := coversCIDRs(callerPermissions(c).AllowedCIDRs, allowedCIDRs); err != nil {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Message: "Api keys cannot grant permissions they do not have",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+	key, err := h.q.SetAPIKeyAllowedCIDRs(c.Request.Context(), c.Param("id"), allowedCIDRs)
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not set Api key allowed CIDRs")
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// Post Request For Admin to issue an Api key a secret to sign requests with, replacing any it had
+func (h *Handler) PostApiKeySigningSecret(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	key, secret, err := h.q.IssueSigningSecret(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not issue signing secret")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key_id":     key.ID,
+		"signing_secret": secret,
+	})
+}
+
+// Delete Request For Admin to stop an Api key from accepting signed requests
+func (h *Handler) DeleteApiKeySigningSecret(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	if err := h.q.RemoveSigningSecret(c.Request.Context(), c.Param("id")); err != nil {
+		respondAPIKeyError(c, err, "Could not remove signing secret")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Get Request For Admin to list the client certificate identities that authenticate as an Api key
+func (h *Handler) GetApiKeyClientIdentities(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	identities, err := h.q.ListClientIdentities(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not list client identities")
+		return
+	}
+	c.JSON(http.StatusOK, identities)
+}
+
+// Post Request For Admin to let client certificates with an identity, such as dns:billing.internal, authenticate as an Api key
+func (h *Handler) PostApiKeyClientIdentity(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	var req models.ClientIdentityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	identity, err := ParseClientIdentity(req.Identity)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid identity",
+			Error:   err.Error(),
+		})
+		return
+	}
+	added, err := h.q.AddClientIdentity(c.Request.Context(), c.Param("id"), identity)
+	if errors.Is(err, ErrClientIdentityTaken) {
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Message: "Identity already belongs to an Api key",
+		})
+		return
+	}
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not add client identity")
+		return
+	}
+	c.JSON(http.StatusCreated, added)
+}
+
+// Delete Request For Admin to stop a client certificate identity from authenticating as an Api key
+func (h *Handler) DeleteApiKeyClientIdentity(c *gin.Context) {
+	if !h.canManageKey(c, c.Param("id")) {
+		return
+	}
+	err := h.q.DeleteClientIdentity(c.Request.Context(), c.Param("id"), c.Param("identity_id"))
+	if errors.Is(err, ErrClientIdentityNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "Client identity could not be found",
+		})
+		return
+	}
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not delete client identity")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// keyAdminOrganization returns the organization an API key with admin:keys
+// is limited to. It reports false for ADMIN_TOKEN, which manages every key.
+func keyAdminOrganization(c *gin.Context) (string, bool) {
+	org, limited := c.Get("key_admin_organization_id")
+	if !limited {
+		return "", false
+	}
+	return org.(string), true
+}
+
+// callerPermissions returns the permissions of the authenticated API key.
+func callerPermissions(c *gin.Context) KeyPermissions {
+	permissions, _ := c.Get("api_key_permissions")
+	p, _ := permissions.(KeyPermissions)
+	return p
+}
+
+// canManageKey answers 404 and returns false if the caller is an API key
+// with admin:keys and key id belongs to another organization, and 403 if key
+// id may do more than the caller, as rotating it would hand the caller a key
+// with those permissions.
+func (h *Handler) canManageKey(c *gin.Context, id string) bool {
+	org, limited := keyAdminOrganization(c)
+	if !limited {
+		return true
+	}
+	key, err := h.q.GetAPIKey(c.Request.Context(), id)
+	if err != nil && !errors.Is(err, ErrAPIKeyNotFound) {
+		respondAPIKeyError(c, err, "Could not get Api key")
+		return false
+	}
+	if err != nil || key.OrganizationID.String != org {
+		respondAPIKeyError(c, ErrAPIKeyNotFound, "")
+		return false
+	}
+	target := KeyPermissions{
+		Scopes:        key.Scopes,
+		AllowedTypes:  key.AllowedTypes,
+		AllowedQueues: key.AllowedQueues,
+		AllowedCIDRs:  key.AllowedCidrs,
+	}
+	if err := callerPermissions(c).Covers(target); err != nil {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Message: "Api keys cannot manage keys with permissions they do not have",
+			Error:   err.Error(),
+		})
+		return false
+	}
+	return true
+}
+func respondAPIKeyError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "Api key could not be found",
+		})
+	case errors.Is(err, ErrAPIKeyInactive):
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Message: message,
+			Error:   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: message,
+			Error:   err.Error(),
+		})
+	}
+}
+
+// Get Request For Admin to see job counts per status
+func (h *Handler) GetStats(c *gin.Context) {
+	stats, err := h.q.Stats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not get job stats",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// Get Request For Admin to list the cluster-wide limits per job type
+func (h *Handler) GetJobTypeLimits(c *gin.Context) {
+	limits, err := h.q.ListJobTypeLimits(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list job type limits",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"limits": limits,
+	})
+}
+
+// Put Request For Admin to set the concurrency cap and execution rate of a job type
+func (h *Handler) PutJobTypeLimit(c *gin.Context) {
+	var req models.JobTypeLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if req.MaxConcurrent == nil && req.RatePerSecond == nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Set max_concurrent, rate_per_second or both",
+		})
+		return
+	}
+	if (req.MaxConcurrent != nil && *req.MaxConcurrent <= 0) || (req.RatePerSecond != nil && *req.RatePerSecond <= 0) || req.Burst < 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Limits must be positive",
+		})
+		return
+	}
+	arg := db.UpsertJobTypeLimitParams{
+		JobType: c.Param("type"),
+		Burst:   max(req.Burst, 1),
+	}
+	if req.MaxConcurrent != nil {
+		arg.MaxConcurrent = pgtype.Int4{Int32: *req.MaxConcurrent, Valid: true}
+	}
+	if req.RatePerSecond != nil {
+		arg.RatePerSecond = pgtype.Float8{Float64: *req.RatePerSecond, Valid: true}
+	}
+	limit, err := h.q.SetJobTypeLimit(c.Request.Context(), arg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not set job type limit",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, limit)
+}
+
+// Delete Request For Admin to lift all limits on a job type
+func (h *Handler) DeleteJobTypeLimit(c *gin.Context) {
+	if err := h.q.DeleteJobTypeLimit(c.Request.Context(), c.Param("type")); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not delete job type limit",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Get Request For Admin to see the circuit breaker of every job type
+func (h *Handler) GetCircuitBreakers(c *gin.Context) {
+	breakers, err := h.q.ListCircuitBreakers(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list circuit breakers",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"breakers": breakers,
+	})
+}
+
+// Put Request For Admin to configure when a job type's circuit breaker opens
+func (h *Handler) PutCircuitBreaker(c *gin.Context) {
+	var req models.CircuitBreakerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if req.FailureRateThreshold <= 0 || req.FailureRateThreshold > 1 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "failure_rate_threshold must be in (0, 1]",
+		})
+		return
+	}
+	if req.MinRequests <= 0 || req.WindowSeconds <= 0 || req.OpenSeconds <= 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "min_requests, window_seconds and open_seconds must be positive",
+		})
+		return
+	}
+	breaker, err := h.q.ConfigureCircuitBreaker(c.Request.Context(), db.ConfigureCircuitBreakerParams{
+		JobType:              c.Param("type"),
+		FailureRateThreshold: req.FailureRateThreshold,
+		MinRequests:          req.MinRequests,
+		WindowSeconds:        req.WindowSeconds,
+		OpenSeconds:          req.OpenSeconds,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not configure circuit breaker",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, breaker)
+}
+
+// Post Request For Admin to force a job type's circuit breaker closed
+func (h *Handler) PostResetCircuitBreaker(c *gin.Context) {
+	breaker, err := h.q.ResetCircuitBreaker(c.Request.Context(), c.Param("type"))
+	if errors.Is(err, ErrCircuitBreakerNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "Circuit breaker could not be found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not reset circuit breaker",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, breaker)
+}
+
+// Get Request To list the caller's own jobs, or its organization's, see parseJobSearch for the filters
+func (h *Handler) GetJobs(c *gin.Context) {
+	arg, err := parseJobSearch(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid search",
+			Error:   err.Error(),
+		})
+		return
+	}
+	// the same jobs the caller may get one by one, see Owner.Owns; an empty
+	// key matches no job, so a JWT caller sees its organization's jobs only
+	arg.OwnerApiKeyID = pgtype.Text{String: c.GetString("api_key_id"), Valid: true}
+	arg.OwnerOrganizationID = optionalText(c.GetString("organization_id"))
+	h.respondJobPage(c, arg)
+}
+
+// Get Request For Admin to list jobs across every API key, optionally narrowed with ?api_key_id= and ?organization_id=
+func (h *Handler) GetAdminJobs(c *gin.Context) {
+	arg, err := parseJobSearch(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid search",
+			Error:   err.Error(),
+		})
+		return
+	}
+	arg.ApiKeyID = optionalText(c.Query("api_key_id"))
+	arg.OrganizationID = optionalText(c.Query("organization_id"))
+	h.respondJobPage(c, arg)
+}
+func (h *Handler) respondJobPage(c *gin.Context, arg db.SearchJobsParams) {
+	jobs, next, err := h.q.SearchJobs(c.Request.Context(), arg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list jobs",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":        jobs,
+		"next_cursor": next,
+	})
+}
+
+// Get Request For Admin to inspect quarantined jobs
+func (h *Handler) GetQuarantinedJobs(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 500 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "limit must be between 1 and 500",
+		})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "offset must not be negative",
+		})
+		return
+	}
+	jobs, err := h.q.ListQuarantinedJobs(c.Request.Context(), int32(limit), int32(offset))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list quarantined jobs",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": jobs,
+	})
+}
+
+// Post Request For Admin to put a quarantined job back in the queue
+func (h *Handler) PostReleaseQuarantinedJob(c *gin.Context) {
+	job, err := h.q.ReleaseQuarantinedJob(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "Quarantined job could not be found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not release quarantined job",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// Get Request For Admin to see each tenant's weight and place in the fair queue
+func (h *Handler) GetTenants(c *gin.Context) {
+	tenants, err := h.q.ListTenants(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list tenants",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tenants": tenants,
+	})
+}
+
+// Put Request For Admin to set a tenant's share of worker capacity
+func (h *Handler) PutTenantWeight(c *gin.Context) {
+	var req models.TenantWeightRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if req.Weight <= 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "weight must be positive",
+		})
+		return
+	}
+	tenant, err := h.q.SetTenantWeight(c.Request.Context(), c.Param("id"), req.Weight)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not set tenant weight",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, tenant)
+}
+
+// Get Request For Admin to see the configured backlog limits
+func (h *Handler) GetBacklogLimits(c *gin.Context) {
+	limits, err := h.q.ListBacklogLimits(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list backlog limits",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, limits)
+}
+
+// Put Request For Admin to cap the pending backlog of a queue, job type or API key
+func (h *Handler) PutBacklogLimit(c *gin.Context) {
+	var req models.BacklogLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err := validateBacklogScope(BacklogScope(req.Scope), req.Name); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid backlog limit",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if req.MaxPending <= 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "max_pending must be positive",
+		})
+		return
+	}
+	limit, err := h.q.SetBacklogLimit(c.Request.Context(), db.UpsertBacklogLimitParams{
+		Scope:      req.Scope,
+		Name:       req.Name,
+		MaxPending: req.MaxPending,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not set backlog limit",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, limit)
+}
+
+// Delete Request For Admin to lift a backlog limit, e.g. ?scope=type&name=send_email
+func (h *Handler) DeleteBacklogLimit(c *gin.Context) {
+	scope := BacklogScope(c.Query("scope"))
+	if err := validateBacklogScope(scope, c.Query("name")); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid backlog limit",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err := h.q.DeleteBacklogLimit(c.Request.Context(), scope, c.Query("name")); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not delete backlog limit",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func validateBacklogScope(scope BacklogScope, name string) error {
+	switch scope {
+	case BacklogGlobal:
+		if name != "" {
+			return fmt.Errorf("a global limit takes no name")
+		}
+		return nil
+	case BacklogQueue, BacklogType, BacklogAPIKey, BacklogOrganization:
+		if name == "" {
+			return fmt.Errorf("a %s limit needs a name", scope)
+		}
+		return nil
+	}
+	return fmt.Errorf("scope must be one of global, queue, type, api_key or organization")
+}
+
+// Get Request For Admin to see every execution window
+func (h *Handler) GetExecutionWindows(c *gin.Context) {
+	windows, err := h.q.ListExecutionWindows(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list execution windows",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, windows)
+}
+
+// Post Request For Admin to restrict when a job type or queue may run
+func (h *Handler) PostExecutionWindow(c *gin.Context) {
+	var req models.ExecutionWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	arg, err := executionWindowParams(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid execution window",
+			Error:   err.Error(),
+		})
+		return
+	}
+	window, err := h.q.CreateExecutionWindow(c.Request.Context(), arg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not create execution window",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, window)
+}
+
+// Delete Request For Admin to remove an execution window
+func (h *Handler) DeleteExecutionWindow(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid window id",
+			Error:   err.Error(),
+		})
+		return
+	}
+	_, err = h.q.DeleteExecutionWindow(c.Request.Context(), id)
+	if errors.Is(err, ErrExecutionWindowNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "Execution window could not be found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not delete execution window",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// executionWindowParams validates req and converts it for storage.
+func executionWindowParams(req models.ExecutionWindowRequest) (db.CreateExecutionWindowParams, error) {
+	if req.Scope != string(WindowScopeType) && req.Scope != string(WindowScopeQueue) {
+		return db.CreateExecutionWindowParams{}, fmt.Errorf("scope must be %q or %q", WindowScopeType, WindowScopeQueue)
+	}
+	if req.Name == "" {
+		return db.CreateExecutionWindowParams{}, fmt.Errorf("name is required")
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(req.Timezone); err != nil {
+		return db.CreateExecutionWindowParams{}, fmt.Errorf("unknown timezone %q", req.Timezone)
+	}
+	if req.Weekdays == nil {
+		req.Weekdays = []int32{}
+	}
+	arg := db.CreateExecutionWindowParams{
+		Scope:       req.Scope,
+		Name:        req.Name,
+		Kind:        req.Kind,
+		Timezone:    req.Timezone,
+		Weekdays:    req.Weekdays,
+		Description: pgtype.Text{String: req.Description, Valid: req.Description != ""},
+	}
+	switch WindowKind(req.Kind) {
+	case WindowAllow:
+		for _, d := range req.Weekdays {
+			if d < 0 || d > 6 {
+				return db.CreateExecutionWindowParams{}, fmt.Errorf("weekdays must be between 0 (Sunday) and 6")
+			}
+		}
+		start, err := time.Parse("15:04", req.Start)
+		if err != nil {
+			return db.CreateExecutionWindowParams{}, fmt.Errorf("start must be a HH:MM time")
+		}
+		end, err := time.Parse("15:04", req.End)
+		if err != nil {
+			return db.CreateExecutionWindowParams{}, fmt.Errorf("end must be a HH:MM time")
+		}
+		arg.StartTime = pgtype.Time{Microseconds: int64(start.Hour()*60+start.Minute()) * int64(time.Minute/time.Microsecond), Valid: true}
+		arg.EndTime = pgtype.Time{Microseconds: int64(end.Hour()*60+end.Minute()) * int64(time.Minute/time.Microsecond), Valid: true}
+	case WindowBlackout:
+		if req.StartsAt == nil || req.EndsAt == nil || !req.EndsAt.After(*req.StartsAt) {
+			return db.CreateExecutionWindowParams{}, fmt.Errorf("a blackout needs starts_at before ends_at")
+		}
+		arg.StartsAt = pgtype.Timestamptz{Time: *req.StartsAt, Valid: true}
+		arg.EndsAt = pgtype.Timestamptz{Time: *req.EndsAt, Valid: true}
+	default:
+		return db.CreateExecutionWindowParams{}, fmt.Errorf("kind must be %q or %q", WindowAllow, WindowBlackout)
+	}
+	return arg, nil
+}
+
+// Put Request For Admin to choose whether an API key gets a 429 or a deferred job when over its rate limit
+func (h *Handler) PutApiKeyRateLimitMode(c *gin.Context) {
+	var req models.RateLimitModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	key, err := h.q.SetAPIKeyDeferOverLimit(c.Request.Context(), c.Param("id"), req.DeferOverLimit)
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not set Api key rate limit mode")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":               key.ID,
+		"name":             key.Name,
+		"defer_over_limit": key.DeferOverLimit,
+	})
+}
+
+// Put Request For Admin to move an API key to another organization
+func (h *Handler) PutApiKeyOrganization(c *gin.Context) {
+	var req models.APIKeyOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	key, err := h.q.SetAPIKeyOrganization(c.Request.Context(), c.Param("id"), req.OrganizationID)
+	if errors.Is(err, ErrOrganizationNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "Organization could not be found",
+		})
+		return
+	}
+	if err != nil {
+		respondAPIKeyError(c, err, "Could not move Api key")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":              key.ID,
+		"name":            key.Name,
+		"organization_id": key.OrganizationID,
+	})
+}
+
+// Get Request For Admin to list every organization
+func (h *Handler) GetOrganizations(c *gin.Context) {
+	orgs, err := h.q.ListOrganizations(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list organizations",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, orgs)
+}
+
+// Put Request For Admin to create an organization or change its settings
+func (h *Handler) PutOrganization(c *gin.Context) {
+	var req models.OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	arg, err := organizationParams(c.Param("id"), req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid organization",
+			Error:   err.Error(),
+		})
+		return
+	}
+	org, err := h.q.SetOrganization(c.Request.Context(), arg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not set organization",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, org)
+}
+
+// Delete Request For Admin to delete an organization; its keys are left without one
+func (h *Handler) DeleteOrganization(c *gin.Context) {
+	if err := h.q.DeleteOrganization(c.Request.Context(), c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not delete organization",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// organizationParams validates req and fills in the default burst.
+func organizationParams(id string, req models.OrganizationRequest) (db.UpsertOrganizationParams, error) {
+	if req.Name == "" {
+		return db.UpsertOrganizationParams{}, fmt.Errorf("name is required")
+	}
+	arg := db.UpsertOrganizationParams{
+		ID:             id,
+		Name:           req.Name,
+		DeferOverLimit: req.DeferOverLimit,
+	}
+	if req.RatePerSecond == nil {
+		if req.Burst != 0 {
+			return db.UpsertOrganizationParams{}, fmt.Errorf("burst needs a rate_per_second")
+		}
+		return arg, nil
+	}
+	if *req.RatePerSecond <= 0 || req.Burst < 0 {
+		return db.UpsertOrganizationParams{}, fmt.Errorf("rate_per_second and burst must be positive")
+	}
+	arg.RatePerSecond = pgtype.Float8{Float64: *req.RatePerSecond, Valid: true}
+	// a second's worth of requests unless set
+	arg.Burst = pgtype.Int4{Int32: req.Burst, Valid: true}
+	if req.Burst == 0 {
+		arg.Burst.Int32 = max(int32(math.Ceil(*req.RatePerSecond)), 1)
+	}
+	return arg, nil
+}
+
+// Get Request For Admin to see the live workers and their labels
+func (h *Handler) GetWorkers(c *gin.Context) {
+	workers, err := h.q.ListWorkers(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list workers",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, workers)
+}
+
+// Get Request For Admin to compare the handler versions of every rollout
+func (h *Handler) GetRollouts(c *gin.Context) {
+	rollouts, err := h.q.ListRollouts(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list rollouts",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, rollouts)
+}
+
+// Put Request For Admin to start a canary rollout of a new handler version
+func (h *Handler) PutRollout(c *gin.Context) {
+	var req models.RolloutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	arg, err := rolloutParams(c.Param("type"), req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid rollout",
+			Error:   err.Error(),
+		})
+		return
+	}
+	rollout, err := h.q.StartRollout(c.Request.Context(), arg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not start rollout",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, rollout)
+}
+
+// Post Request For Admin to send every job of a type back to its stable handler version
+func (h *Handler) PostRollbackRollout(c *gin.Context) {
+	var req models.RollbackRequest
+	// the body is optional
+	_ = c.ShouldBindJSON(&req)
+	if req.Reason == "" {
+		req.Reason = "rolled back by an admin"
+	}
+	rollout, err := h.q.RollBackRollout(c.Request.Context(), c.Param("type"), req.Reason)
+	if errors.Is(err, ErrRolloutNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "Rollout could not be found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not roll back rollout",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, rollout)
+}
+
+// Delete Request For Admin to end a rollout, letting any version run the job type
+func (h *Handler) DeleteRollout(c *gin.Context) {
+	if err := h.q.DeleteRollout(c.Request.Context(), c.Param("type")); err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not delete rollout",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// rolloutParams validates req and fills in the defaults.
+func rolloutParams(jobType string, req models.RolloutRequest) (db.UpsertHandlerRolloutParams, error) {
+	if req.StableVersion == "" || req.CanaryVersion == "" {
+		return db.UpsertHandlerRolloutParams{}, fmt.Errorf("stable_version and canary_version are required")
+	}
+	if req.StableVersion == req.CanaryVersion {
+		return db.UpsertHandlerRolloutParams{}, fmt.Errorf("canary_version must differ from stable_version")
+	}
+	if req.CanaryPercent < 0 || req.CanaryPercent > 100 {
+		return db.UpsertHandlerRolloutParams{}, fmt.Errorf("canary_percent must be between 0 and 100")
+	}
+	if req.MinJobs < 0 {
+		return db.UpsertHandlerRolloutParams{}, fmt.Errorf("min_jobs cannot be negative")
+	}
+	if req.MinJobs == 0 {
+		req.MinJobs = 50
+	}
+	maxIncrease := 0.05
+	if req.MaxErrorRateIncrease != nil {
+		maxIncrease = *req.MaxErrorRateIncrease
+	}
+	if maxIncrease < 0 || maxIncrease > 1 {
+		return db.UpsertHandlerRolloutParams{}, fmt.Errorf("max_error_rate_increase must be between 0 and 1")
+	}
+	return db.UpsertHandlerRolloutParams{
+		JobType:              jobType,
+		StableVersion:        req.StableVersion,
+		CanaryVersion:        req.CanaryVersion,
+		CanaryPercent:        req.CanaryPercent,
+		MinJobs:              req.MinJobs,
+		MaxErrorRateIncrease: maxIncrease,
+	}, nil
+}
+
+// Get Request For Admin owners to list the admin principals
+func (h *Handler) GetAdminPrincipals(c *gin.Context) {
+	principals, err := h.q.ListAdminPrincipals(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list admin principals",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, principals)
+}
+
+// Post Request For Admin owners to add an admin principal with its first token
+func (h *Handler) PostAdminPrincipal(c *gin.Context) {
+	var req models.AdminPrincipalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	role, err := ParseAdminRole(req.Role)
+	if err == nil && req.Name == "" {
+		err = fmt.Errorf("name is required")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid admin principal",
+			Error:   err.Error(),
+		})
+		return
+	}
+	principal, token, err := h.q.CreateAdminPrincipal(c.Request.Context(), req.Name, role, c.GetString("admin_principal"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not create admin principal",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"principal": principal,
+		"token":     token,
+		"warning":   "Save this token securely. It wont be shown again",
+	})
+}
+
+// Patch Request For Admin owners to change the role of an admin principal or deactivate it
+func (h *Handler) PatchAdminPrincipal(c *gin.Context) {
+	var req models.UpdateAdminPrincipalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	arg := db.UpdateAdminPrincipalParams{ID: c.Param("id")}
+	if req.Role != nil {
+		role, err := ParseAdminRole(*req.Role)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Message: "Invalid admin principal",
+				Error:   err.Error(),
+			})
+			return
+		}
+		arg.Role = pgtype.Text{String: string(role), Valid: true}
+	}
+	if req.IsActive != nil {
+		arg.IsActive = pgtype.Bool{Bool: *req.IsActive, Valid: true}
+	}
+	principal, err := h.q.UpdateAdminPrincipal(c.Request.Context(), arg)
+	if err != nil {
+		respondAdminPrincipalError(c, err, "Could not update admin principal")
+		return
+	}
+	c.JSON(http.StatusOK, principal)
+}
+
+// Get Request For Admin owners to list the tokens of an admin principal
+func (h *Handler) GetAdminTokens(c *gin.Context) {
+	tokens, err := h.q.ListAdminTokens(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list admin tokens",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Post Request For Admin owners to issue another token to an admin principal, e.g. to rotate the old one
+func (h *Handler) PostAdminToken(c *gin.Context) {
+	var req models.AdminTokenRequest
+	// the body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Message: "Invalid Request",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+	if err := validateExpiry(req.ExpiresAt, time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "Invalid Request",
+			Error:   err.Error(),
+		})
+		return
+	}
+	token, plaintext, err := h.q.CreateAdminToken(c.Request.Context(), c.Param("id"), optionalTime(req.ExpiresAt))
+	if err != nil {
+		respondAdminPrincipalError(c, err, "Could not create admin token")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"id":           token.ID,
+		"principal_id": token.PrincipalID,
+		"token":        plaintext,
+		"token_prefix": token.TokenPrefix,
+		"expires_at":   token.ExpiresAt,
+		"warning":      "Save this token securely. It wont be shown again",
+	})
+}
+
+// Delete Request For Admin owners to revoke a token of an admin principal
+func (h *Handler) DeleteAdminToken(c *gin.Context) {
+	if err := h.q.RevokeAdminToken(c.Request.Context(), c.Param("id"), c.Param("token_id")); err != nil {
+		respondAdminPrincipalError(c, err, "Could not revoke admin token")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Get Request For Admin owners to see who changed what, newest first, optionally for one ?principal=
+func (h *Handler) GetAdminAudit(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Message: "limit must be between 1 and 1000",
+		})
+		return
+	}
+	actions, err := h.q.ListAdminActions(c.Request.Context(), c.Query("principal"), int32(limit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: "Could not list admin actions",
+			Error:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, actions)
+}
+func respondAdminPrincipalError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrAdminPrincipalNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "Admin principal could not be found",
+		})
+	case errors.Is(err, ErrAdminTokenNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Message: "Admin token could not be found",
+		})
+	case errors.Is(err, ErrLastOwner):
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Message: message,
+			Error:   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Message: message,
+			Error:   err.Error(),
+		})
+	}
+}
@@ -14,6 +14,11 @@ import (
 
 var ErrInvalidPayload = errors.New("invalid email payload")
 
+// EmailHandlerVersion identifies this implementation of the send_email
+// handler. Bump it with every behavioural change so the new version can be
+// rolled out as a canary.
+const EmailHandlerVersion = "1"
+
 type EmailPayload struct {
 	To      string `json:"to"`
 	From    string `json:"from"`
@@ -21,18 +26,28 @@ type EmailPayload struct {
 }
 
 type EmailHandler struct {
-	ApiKey string //resend api key
+	ApiKey      string //resend api key
+	Version     string
+	Idempotency IdempotencyStore
 	// httpclient *http.Client
 }
 
 func NewEmailHandlerService() *EmailHandler {
 	return &EmailHandler{
-		ApiKey: os.Getenv("RESEND_API_KEY"),
+		ApiKey:  os.Getenv("RESEND_API_KEY"),
+		Version: EmailHandlerVersion,
 		// httpclient: &http.Client{
 		// 	Timeout: 10 * time.Second,
 		// },
 	}
 }
+
+// WithIdempotency makes Sendemail record sent emails in store so retries of the
+// same job do not send them again.
+func (e *EmailHandler) WithIdempotency(store IdempotencyStore) *EmailHandler {
+	e.Idempotency = store
+	return e
+}
 func (e *EmailHandler) HandleMail(ctx context.Context, payload json.RawMessage) error {
 	var req EmailPayload
 	if err := json.Unmarshal([]byte(payload), &req); err != nil {
@@ -59,11 +74,22 @@ func (e *EmailHandler) Sendemail(ctx context.Context, mail EmailPayload) error {
 		// Bcc:     []string{"bcc@example.com"},
 		// ReplyTo: "replyto@example.com",
 	}
-	responseEmail, err := client.Emails.SendWithContext(ctx, params)
+	key, _ := IdempotencyKey(ctx, "send_email")
+	outcome, err := Once(ctx, e.Idempotency, key, func(ctx context.Context) (json.RawMessage, error) {
+		// resend dedupes on the same key too, which covers the window where
+		// the email was accepted but the outcome was never recorded
+		responseEmail, err := client.Emails.SendWithOptions(ctx, params, &resend.SendEmailOptions{
+			IdempotencyKey: key,
+		})
+		if err != nil {
+			return nil, ClassifyEmailError(err)
+		}
+		return json.Marshal(responseEmail)
+	})
 	if err != nil {
-		return ClassifyEmailError(err)
+		return err
 	}
-	log.Printf("email was sent successfully: id=%s", responseEmail.Id)
+	log.Printf("email was sent successfully: %s", outcome)
 	return nil
 
 }
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// IdempotencyStore persists the outcome of side effects so a retried job can
+// replay them instead of performing them twice.
+type IdempotencyStore interface {
+	LookupSideEffect(ctx context.Context, key string) (json.RawMessage, bool, error)
+	RecordSideEffect(ctx context.Context, key, jobID string, outcome json.RawMessage) error
+}
+
+type jobIDKey struct{}
+
+// WithJobID attaches the id of the job being processed to ctx.
+func WithJobID(ctx context.Context, jobID string) context.Context {
+	return context.WithValue(ctx, jobIDKey{}, jobID)
+}
+
+func JobIDFromContext(ctx context.Context) (string, bool) {
+	jobID, ok := ctx.Value(jobIDKey{}).(string)
+	return jobID, ok && jobID != ""
+}
+
+// IdempotencyKey builds a key that is stable across every attempt of the job
+// in ctx, e.g. "<job_id>:send_email".
+func IdempotencyKey(ctx context.Context, effect string) (string, bool) {
+	jobID, ok := JobIDFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%s", jobID, effect), true
+}
+
+// Once runs fn unless an outcome has already been recorded under key, in
+// which case the stored outcome is returned instead. Only successful outcomes
+// are recorded so failed attempts can still be retried.
+func Once(ctx context.Context, store IdempotencyStore, key string, fn func(ctx context.Context) (json.RawMessage, error)) (json.RawMessage, error) {
+	if store == nil || key == "" {
+		return fn(ctx)
+	}
+	outcome, found, err := store.LookupSideEffect(ctx, key)
+	if err != nil {
+		return nil, &RetriableError{
+			Msg: fmt.Sprintf("could not look up side effect %s: %v", key, err),
+		}
+	}
+	if found {
+		log.Printf("side effect %s already performed, replaying stored outcome", key)
+		return outcome, nil
+	}
+	outcome, err = fn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jobID, _ := JobIDFromContext(ctx)
+	if err := store.RecordSideEffect(ctx, key, jobID, outcome); err != nil {
+		// the side effect already happened; failing the job now would only
+		// make a retry repeat it
+		log.Printf("could not record side effect %s: %v", key, err)
+	}
+	return outcome, nil
+}
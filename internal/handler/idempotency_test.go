@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type memoryStore struct {
+	outcomes map[string]json.RawMessage
+}
+
+func (m *memoryStore) LookupSideEffect(ctx context.Context, key string) (json.RawMessage, bool, error) {
+	outcome, ok := m.outcomes[key]
+	return outcome, ok, nil
+}
+func (m *memoryStore) RecordSideEffect(ctx context.Context, key, jobID string, outcome json.RawMessage) error {
+	m.outcomes[key] = outcome
+	return nil
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	if _, ok := IdempotencyKey(context.Background(), "send_email"); ok {
+		t.Fatal("expected no key without a job id")
+	}
+	ctx := WithJobID(context.Background(), "job-1")
+	key, ok := IdempotencyKey(ctx, "send_email")
+	if !ok || key != "job-1:send_email" {
+		t.Fatalf("expected job-1:send_email, got %q", key)
+	}
+}
+func TestOnce_ReplaysRecordedOutcome(t *testing.T) {
+	store := &memoryStore{outcomes: map[string]json.RawMessage{}}
+	ctx := WithJobID(context.Background(), "job-1")
+	calls := 0
+	fn := func(ctx context.Context) (json.RawMessage, error) {
+		calls++
+		return json.RawMessage(`{"id":"email-1"}`), nil
+	}
+	for i := 0; i < 2; i++ {
+		outcome, err := Once(ctx, store, "job-1:send_email", fn)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(outcome) != `{"id":"email-1"}` {
+			t.Fatalf("unexpected outcome %s", outcome)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected side effect to run once, ran %d times", calls)
+	}
+}
+func TestOnce_DoesNotRecordFailures(t *testing.T) {
+	store := &memoryStore{outcomes: map[string]json.RawMessage{}}
+	ctx := WithJobID(context.Background(), "job-1")
+	_, err := Once(ctx, store, "job-1:send_email", func(ctx context.Context) (json.RawMessage, error) {
+		return nil, errors.New("provider down")
+	})
+	if err == nil {
+		t.Fatal("expected the side effect error to be returned")
+	}
+	if _, found := store.outcomes["job-1:send_email"]; found {
+		t.Fatal("failed side effects should not be recorded")
+	}
+}
@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/franzego/distributed_task_queue/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestApplyCoalesce(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var job db.Job
+	err := applyCoalesce(&job, models.CoalesceOptions{Key: "user-42", DelaySeconds: 5, WindowSeconds: 60}, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts := models.CoalesceOptions{Key: "user-42", DelaySeconds: 5}
+	if err := applyCoalesce(&db.Job{}, opts, now); err != nil || opts.WindowSeconds != 0 || opts.Mode != "" {
+		t.Fatalf("expected the request options to be left as they were, got %+v, %v", opts, err)
+	}
+	if job.CoalesceKey.String != "user-42" || job.CoalesceMode.String != CoalesceReplace {
+		t.Fatalf("unexpected coalesce key/mode: %q/%q", job.CoalesceKey.String, job.CoalesceMode.String)
+	}
+	if !job.ScheduledAt.Time.Equal(now.Add(5 * time.Second)) {
+		t.Fatalf("expected run time 5s out, got %v", job.ScheduledAt.Time)
+	}
+	if !job.CoalesceUntil.Time.Equal(now.Add(60 * time.Second)) {
+		t.Fatalf("expected window to close 60s out, got %v", job.CoalesceUntil.Time)
+	}
+}
+func TestApplyCoalesce_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts models.CoalesceOptions
+	}{
+		{name: "missing key", opts: models.CoalesceOptions{DelaySeconds: 5}},
+		{name: "no delay", opts: models.CoalesceOptions{Key: "k"}},
+		{name: "window shorter than delay", opts: models.CoalesceOptions{Key: "k", DelaySeconds: 10, WindowSeconds: 5}},
+		{name: "unknown mode", opts: models.CoalesceOptions{Key: "k", DelaySeconds: 5, Mode: "append"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var job db.Job
+			if err := applyCoalesce(&job, tt.opts, time.Now()); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+func TestExecutionWindowParams(t *testing.T) {
+	arg, err := executionWindowParams(models.ExecutionWindowRequest{
+		Scope:    "type",
+		Name:     "marketing_email",
+		Kind:     "allow",
+		Timezone: "America/New_York",
+		Weekdays: []int32{1, 2, 3, 4, 5},
+		Start:    "09:00",
+		End:      "18:30",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := time.Duration(arg.EndTime.Microseconds) * time.Microsecond; got != 18*time.Hour+30*time.Minute {
+		t.Fatalf("expected end at 18:30, got %v", got)
+	}
+
+	starts := time.Now()
+	invalid := []models.ExecutionWindowRequest{
+		{Scope: "api_key", Name: "k", Kind: "allow", Start: "09:00", End: "18:00"},
+		{Scope: "type", Kind: "allow", Start: "09:00", End: "18:00"},
+		{Scope: "type", Name: "t", Kind: "allow", Timezone: "Mars/Olympus", Start: "09:00", End: "18:00"},
+		{Scope: "type", Name: "t", Kind: "allow", Start: "9am", End: "18:00"},
+		{Scope: "type", Name: "t", Kind: "allow", Weekdays: []int32{7}, Start: "09:00", End: "18:00"},
+		{Scope: "queue", Name: "q", Kind: "blackout", StartsAt: &starts, EndsAt: &starts},
+		{Scope: "queue", Name: "q", Kind: "sometimes"},
+	}
+	for _, req := range invalid {
+		if _, err := executionWindowParams(req); err == nil {
+			t.Fatalf("expected an error for %+v", req)
+		}
+	}
+}
+func TestApplyDeferral(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var job db.Job
+	applyDeferral(&job, now.Add(3*time.Second))
+	if !job.ScheduledAt.Valid || !job.ScheduledAt.Time.Equal(now.Add(3*time.Second)) {
+		t.Fatalf("expected the job to be deferred 3s, got %v", job.ScheduledAt)
+	}
+
+	// a coalesced job already due later keeps its run time
+	coalesced := db.Job{}
+	if err := applyCoalesce(&coalesced, models.CoalesceOptions{Key: "k", DelaySeconds: 10}, now); err != nil {
+		t.Fatal(err)
+	}
+	applyDeferral(&coalesced, now.Add(3*time.Second))
+	if !coalesced.ScheduledAt.Time.Equal(now.Add(10 * time.Second)) {
+		t.Fatalf("expected the coalesce delay to win, got %v", coalesced.ScheduledAt.Time)
+	}
+	applyDeferral(&coalesced, now.Add(time.Minute))
+	if !coalesced.ScheduledAt.Time.Equal(now.Add(time.Minute)) || !coalesced.CoalesceUntil.Time.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected the deferral to stretch the coalesce window, got %v / %v", coalesced.ScheduledAt.Time, coalesced.CoalesceUntil.Time)
+	}
+}
+func TestRolloutParams(t *testing.T) {
+	arg, err := rolloutParams("send_email", models.RolloutRequest{StableVersion: "1", CanaryVersion: "2", CanaryPercent: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if arg.MinJobs != 50 || arg.MaxErrorRateIncrease != 0.05 {
+		t.Fatalf("expected defaults to be filled in, got %+v", arg)
+	}
+	invalid := []models.RolloutRequest{
+		{CanaryVersion: "2", CanaryPercent: 10},
+		{StableVersion: "1", CanaryVersion: "1", CanaryPercent: 10},
+		{StableVersion: "1", CanaryVersion: "2", CanaryPercent: 101},
+		{StableVersion: "1", CanaryVersion: "2", MinJobs: -1},
+	}
+	for _, req := range invalid {
+		if _, err := rolloutParams("send_email", req); err == nil {
+			t.Fatalf("expected an error for %+v", req)
+		}
+	}
+}
+
+func TestOrganizationParams(t *testing.T) {
+	rate := 2.5
+	arg, err := organizationParams("acme", models.OrganizationRequest{Name: "Acme", RatePerSecond: &rate})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !arg.RatePerSecond.Valid || arg.Burst.Int32 != 3 {
+		t.Fatalf("expected a second's worth of burst, got %+v", arg)
+	}
+	arg, err = organizationParams("acme", models.OrganizationRequest{Name: "Acme"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if arg.RatePerSecond.Valid || arg.Burst.Valid {
+		t.Fatalf("expected no rate limit of its own, got %+v", arg)
+	}
+	zero := 0.0
+	invalid := []models.OrganizationRequest{
+		{RatePerSecond: &rate},
+		{Name: "Acme", Burst: 10},
+		{Name: "Acme", RatePerSecond: &zero},
+		{Name: "Acme", RatePerSecond: &rate, Burst: -1},
+	}
+	for _, req := range invalid {
+		if _, err := organizationParams("acme", req); err == nil {
+			t.Fatalf("expected an error for %+v", req)
+		}
+	}
+}
+
+// keyAdminQueue serves the API keys of the handler tests; the rest of Queue
+// is left unimplemented.
+type keyAdminQueue struct {
+	Queue
+	keys    map[string]db.ApiKey
+	rotated []string
+}
+
+func (q *keyAdminQueue) GetAPIKey(_ context.Context, id string) (db.ApiKey, error) {
+	key, ok := q.keys[id]
+	if !ok {
+		return db.ApiKey{}, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+func (q *keyAdminQueue) RotateAPIKey(_ context.Context, id string, _ time.Duration, _ pgtype.Timestamptz, _ string) (db.ApiKey, string, error) {
+	q.rotated = append(q.rotated, id)
+	return db.ApiKey{ID: id + "-rotated"}, "app-secret", nil
+}
+func (q *keyAdminQueue) SetAPIKeyAllowedCIDRs(_ context.Context, id string, allowedCIDRs []string) (db.ApiKey, error) {
+	key := q.keys[id]
+	key.AllowedCidrs = allowedCIDRs
+	q.keys[id] = key
+	return key, nil
+}
+
+func TestPostRotateApiKey_KeyAdminPermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	acme := pgtype.Text{String: "acme", Valid: true}
+	q := &keyAdminQueue{keys: map[string]db.ApiKey{
+		"reader": {ID: "reader", OrganizationID: acme, Scopes: []string{"jobs:read"}},
+		"writer": {ID: "writer", OrganizationID: acme, Scopes: []string{"jobs:read", "jobs:write"}},
+		"emails": {ID: "emails", OrganizationID: acme, Scopes: []string{"jobs:read"}, AllowedTypes: []string{"send_email"}},
+		"other":  {ID: "other", OrganizationID: pgtype.Text{String: "globex", Valid: true}, Scopes: []string{"jobs:read"}},
+	}}
+	// a narrow key of acme that may only read jobs of one type
+	caller := KeyPermissions{Scopes: []string{"admin:keys", "jobs:read"}, AllowedTypes: []string{"send_email"}}
+	router := gin.New()
+	router.POST("/admin/api-keys/:id/rotate", func(c *gin.Context) {
+		c.Set("key_admin_organization_id", "acme")
+		c.Set("api_key_permissions", caller)
+	}, NewHandlerService(q).PostRotateApiKey)
+
+	tests := []struct {
+		id   string
+		want int
+	}{
+		{"emails", http.StatusCreated},
+		{"writer", http.StatusForbidden}, // a scope the caller lacks
+		{"reader", http.StatusForbidden}, // any job type, the caller only one
+		{"other", http.StatusNotFound},   // another organization
+		{"missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/api-keys/"+tt.id+"/rotate", nil))
+		if rec.Code != tt.want {
+			t.Fatalf("rotating %s: expected %d, got %d: %s", tt.id, tt.want, rec.Code, rec.Body)
+		}
+	}
+	if len(q.rotated) != 1 || q.rotated[0] != "emails" {
+		t.Fatalf("expected only emails to be rotated, got %v", q.rotated)
+	}
+}
+
+func TestPutApiKeyAllowedCIDRs_KeyAdminPermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	acme := pgtype.Text{String: "acme", Valid: true}
+	q := &keyAdminQueue{keys: map[string]db.ApiKey{
+		"office": {ID: "office", OrganizationID: acme, Scopes: []string{"jobs:read"}, AllowedCidrs: []string{"10.1.0.0/16"}},
+		"open":   {ID: "open", OrganizationID: acme, Scopes: []string{"jobs:read"}},
+	}}
+	// a key of acme that may only be used from 10.0.0.0/8
+	caller := KeyPermissions{Scopes: []string{"admin:keys", "jobs:read"}, AllowedCIDRs: []string{"10.0.0.0/8"}}
+	router := gin.New()
+	router.PUT("/admin/api-keys/:id/allowed-cidrs", func(c *gin.Context) {
+		c.Set("key_admin_organization_id", "acme")
+		c.Set("api_key_permissions", caller)
+	}, NewHandlerService(q).PutApiKeyAllowedCIDRs)
+
+	tests := []struct {
+		id   string
+		body string
+		want int
+	}{
+		{"office", `{"allowed_cidrs":["10.2.0.0/16"]}`, http.StatusOK},
+		{"office", `{"allowed_cidrs":[]}`, http.StatusForbidden},                 // any network
+		{"office", `{"allowed_cidrs":["192.168.0.0/16"]}`, http.StatusForbidden}, // outside the caller's
+		{"office", `{"allowed_cidrs":["0.0.0.0/0"]}`, http.StatusForbidden},
+		{"open", `{"allowed_cidrs":["10.3.0.0/16"]}`, http.StatusForbidden}, // usable from anywhere
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/admin/api-keys/"+tt.id+"/allowed-cidrs", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Fatalf("setting %s to %s: expected %d, got %d: %s", tt.id, tt.body, tt.want, rec.Code, rec.Body)
+		}
+	}
+	if got := q.keys["office"].AllowedCidrs; !slices.Equal(got, []string{"10.2.0.0/16"}) {
+		t.Fatalf("expected office to be limited to 10.2.0.0/16, got %v", got)
+	}
+	if got := q.keys["open"].AllowedCidrs; len(got) != 0 {
+		t.Fatalf("expected open to stay unlimited, got %v", got)
+	}
+}
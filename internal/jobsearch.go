@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	defaultJobPageSize = 50
+	maxJobPageSize     = 500
+)
+
+// parseJobSearch turns the query of GET /jobs into search params. It leaves
+// ApiKeyID unset; scoping the search is up to the caller.
+func parseJobSearch(query url.Values) (db.SearchJobsParams, error) {
+	arg := db.SearchJobsParams{
+		Statuses: []string{},
+		Tags:     []string{},
+		PageSize: defaultJobPageSize,
+	}
+	for _, status := range splitList(query["status"]) {
+		if !knownStatus(JobStatus(status)) {
+			return arg, fmt.Errorf("unknown status %q", status)
+		}
+		arg.Statuses = append(arg.Statuses, status)
+	}
+	arg.Type = optionalText(query.Get("type"))
+	arg.Queue = optionalText(query.Get("queue"))
+	times := []struct {
+		param string
+		dest  *pgtype.Timestamptz
+	}{
+		{"created_after", &arg.CreatedAfter},
+		{"created_before", &arg.CreatedBefore},
+		{"updated_after", &arg.UpdatedAfter},
+		{"updated_before", &arg.UpdatedBefore},
+	}
+	for _, t := range times {
+		value := query.Get(t.param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return arg, fmt.Errorf("%s must be an RFC 3339 time", t.param)
+		}
+		*t.dest = pgtype.Timestamptz{Time: parsed, Valid: true}
+	}
+	if substr := query.Get("error"); substr != "" {
+		arg.ErrorPattern = pgtype.Text{String: "%" + escapeLike(substr) + "%", Valid: true}
+	}
+	tags, err := NormalizeLabels(splitList(query["tag"]))
+	if err != nil {
+		return arg, err
+	}
+	arg.Tags = tags
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxJobPageSize {
+			return arg, fmt.Errorf("limit must be between 1 and %d", maxJobPageSize)
+		}
+		arg.PageSize = int32(limit)
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		createdAt, id, err := decodeJobCursor(cursor)
+		if err != nil {
+			return arg, err
+		}
+		arg.CursorCreatedAt = pgtype.Timestamptz{Time: createdAt, Valid: true}
+		arg.CursorID = id
+	}
+	return arg, nil
+}
+
+func knownStatus(status JobStatus) bool {
+	switch status {
+	case StatusPending, StatusProcessing, StatusCompleted, StatusFailed, StatusExpired, StatusQuarantined, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// splitList accepts both repeated and comma separated query values.
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+func optionalText(s string) pgtype.Text {
+	return pgtype.Text{String: s, Valid: s != ""}
+}
+func optionalTime(t *time.Time) pgtype.Timestamptz {
+	if t == nil {
+		return pgtype.Timestamptz{}
+	}
+	return pgtype.Timestamptz{Time: *t, Valid: true}
+}
+
+// escapeLike makes s match literally inside an ILIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// encodeJobCursor points just past job in the created_at DESC, id DESC order.
+func encodeJobCursor(job db.Job) string {
+	raw := fmt.Sprintf("%d:%s", job.CreatedAt.Time.UnixMicro(), job.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+func decodeJobCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	micros, id, found := strings.Cut(string(raw), ":")
+	if !found || id == "" {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	unix, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	return time.UnixMicro(unix), id, nil
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestParseJobSearch(t *testing.T) {
+	query := url.Values{
+		"status":        {"pending,failed"},
+		"type":          {"send_email"},
+		"created_after": {"2024-03-01T00:00:00Z"},
+		"error":         {"50%_off"},
+		"tag":           {"campaign-7", "eu"},
+		"limit":         {"20"},
+	}
+	arg, err := parseJobSearch(query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(arg.Statuses, []string{"pending", "failed"}) {
+		t.Fatalf("unexpected statuses: %v", arg.Statuses)
+	}
+	if arg.Type.String != "send_email" || arg.Queue.Valid {
+		t.Fatalf("unexpected type/queue: %+v/%+v", arg.Type, arg.Queue)
+	}
+	if !arg.CreatedAfter.Time.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || arg.CreatedBefore.Valid {
+		t.Fatalf("unexpected creation range: %+v - %+v", arg.CreatedAfter, arg.CreatedBefore)
+	}
+	if arg.ErrorPattern.String != `%50\%\_off%` {
+		t.Fatalf("expected LIKE wildcards to be escaped, got %q", arg.ErrorPattern.String)
+	}
+	if !reflect.DeepEqual(arg.Tags, []string{"campaign-7", "eu"}) || arg.PageSize != 20 {
+		t.Fatalf("unexpected tags/page size: %v/%d", arg.Tags, arg.PageSize)
+	}
+	if arg.ApiKeyID.Valid {
+		t.Fatal("expected scoping to be left to the caller")
+	}
+}
+func TestParseJobSearch_Invalid(t *testing.T) {
+	for _, query := range []url.Values{
+		{"status": {"running"}},
+		{"created_before": {"yesterday"}},
+		{"limit": {"0"}},
+		{"limit": {"501"}},
+		{"cursor": {"not a cursor"}},
+	} {
+		if _, err := parseJobSearch(query); err == nil {
+			t.Fatalf("expected an error for %v", query)
+		}
+	}
+}
+func TestJobCursor(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.UTC)
+	job := db.Job{ID: "1b9d6bcd-bbfd-4b2d-9b5d-ab8dfbbd4bed", CreatedAt: pgtype.Timestamptz{Time: createdAt, Valid: true}}
+	arg, err := parseJobSearch(url.Values{"cursor": {encodeJobCursor(job)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !arg.CursorCreatedAt.Time.Equal(createdAt) || arg.CursorID != job.ID {
+		t.Fatalf("cursor did not round trip: %v %q", arg.CursorCreatedAt.Time, arg.CursorID)
+	}
+}
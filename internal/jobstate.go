@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"errors"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+)
+
+// JobStatus is the lifecycle state stored in jobs.status.
+type JobStatus string
+
+const (
+	StatusPending     JobStatus = "pending"
+	StatusProcessing  JobStatus = "processing"
+	StatusCompleted   JobStatus = "completed"
+	StatusFailed      JobStatus = "failed"
+	StatusExpired     JobStatus = "expired"
+	StatusQuarantined JobStatus = "quarantined"
+	StatusCancelled   JobStatus = "cancelled"
+)
+
+const (
+	CoalesceReplace = "replace"
+	CoalesceMerge   = "merge"
+)
+
+// DefaultLeaseDuration is how long a dequeued job stays owned by its worker
+// before another worker is allowed to reclaim it.
+const DefaultLeaseDuration = 5 * time.Minute
+
+// DefaultQuarantineThreshold is how many attempts may end without an outcome,
+// because the worker crashed or hung, before a job is quarantined.
+const DefaultQuarantineThreshold = 3
+
+var (
+	ErrIllegalTransition = errors.New("illegal job status transition")
+	ErrStaleLease        = errors.New("job lease is no longer held by this worker")
+	// ErrJobNotFound is also returned for jobs of another API key or
+	// organization, so their existence is not revealed.
+	ErrJobNotFound          = errors.New("job not found")
+	ErrOrganizationNotFound = errors.New("organization not found")
+)
+
+// transitions lists every status a job may move to from a given status.
+// Terminal statuses have no entry.
+var transitions = map[JobStatus][]JobStatus{
+	StatusPending:     {StatusProcessing, StatusExpired, StatusCancelled},
+	StatusProcessing:  {StatusCompleted, StatusFailed, StatusPending, StatusExpired, StatusQuarantined},
+	StatusQuarantined: {StatusPending, StatusCancelled},
+}
+
+func (s JobStatus) CanTransitionTo(next JobStatus) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+func (s JobStatus) IsTerminal() bool {
+	return len(transitions[s]) == 0
+}
+
+// Owner scopes a job operation to the API key that submitted the job, or to
+// every key of its organization.
+type Owner struct {
+	apiKeyID       string
+	organizationID string
+	admin          bool
+}
+
+// APIKeyOwner limits an operation to the jobs submitted with apiKeyID and,
+// when organizationID is set, to the jobs submitted by any key of that
+// organization.
+func APIKeyOwner(apiKeyID, organizationID string) Owner {
+	return Owner{apiKeyID: apiKeyID, organizationID: organizationID}
+}
+
+// AdminOwner bypasses ownership checks. Only use it behind admin
+// authentication.
+var AdminOwner = Owner{admin: true}
+
+func (o Owner) Owns(job db.Job) bool {
+	if o.admin {
+		return true
+	}
+	if o.organizationID != "" && job.OrganizationID.Valid && job.OrganizationID.String == o.organizationID {
+		return true
+	}
+	return o.apiKeyID != "" && job.ApiKeyID.Valid && job.ApiKeyID.String == o.apiKeyID
+}
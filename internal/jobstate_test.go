@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"testing"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestJobStatus_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		name string
+		from JobStatus
+		to   JobStatus
+		want bool
+	}{
+		{name: "pending to processing", from: StatusPending, to: StatusProcessing, want: true},
+		{name: "processing to completed", from: StatusProcessing, to: StatusCompleted, want: true},
+		{name: "processing to failed", from: StatusProcessing, to: StatusFailed, want: true},
+		{name: "processing back to pending", from: StatusProcessing, to: StatusPending, want: true},
+		{name: "pending to expired", from: StatusPending, to: StatusExpired, want: true},
+		{name: "expired to pending", from: StatusExpired, to: StatusPending, want: false},
+		{name: "processing to quarantined", from: StatusProcessing, to: StatusQuarantined, want: true},
+		{name: "quarantined released to pending", from: StatusQuarantined, to: StatusPending, want: true},
+		{name: "quarantined to processing", from: StatusQuarantined, to: StatusProcessing, want: false},
+		{name: "pending to completed", from: StatusPending, to: StatusCompleted, want: false},
+		{name: "completed to failed", from: StatusCompleted, to: StatusFailed, want: false},
+		{name: "failed to processing", from: StatusFailed, to: StatusProcessing, want: false},
+		{name: "pending cancelled", from: StatusPending, to: StatusCancelled, want: true},
+		{name: "quarantined cancelled", from: StatusQuarantined, to: StatusCancelled, want: true},
+		{name: "processing cancelled", from: StatusProcessing, to: StatusCancelled, want: false},
+		{name: "cancelled to pending", from: StatusCancelled, to: StatusPending, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+				t.Fatalf("%s -> %s: expected %v, got %v", tt.from, tt.to, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestJobStatus_IsTerminal(t *testing.T) {
+	for _, s := range []JobStatus{StatusCompleted, StatusFailed, StatusExpired, StatusCancelled} {
+		if !s.IsTerminal() {
+			t.Fatalf("expected %s to be terminal", s)
+		}
+	}
+	for _, s := range []JobStatus{StatusPending, StatusProcessing, StatusQuarantined} {
+		if s.IsTerminal() {
+			t.Fatalf("expected %s not to be terminal", s)
+		}
+	}
+}
+
+func TestOwner_Owns(t *testing.T) {
+	job := db.Job{ApiKeyID: pgtype.Text{String: "key-1", Valid: true}}
+	if !APIKeyOwner("key-1", "").Owns(job) {
+		t.Fatal("expected the submitting key to own its job")
+	}
+	if APIKeyOwner("key-2", "").Owns(job) {
+		t.Fatal("expected another key not to own the job")
+	}
+	if APIKeyOwner("", "").Owns(db.Job{}) {
+		t.Fatal("expected a job without a key to be owned by no key")
+	}
+	if !AdminOwner.Owns(job) || !AdminOwner.Owns(db.Job{}) {
+		t.Fatal("expected admins to bypass ownership")
+	}
+}
+
+func TestOwner_OwnsOrganizationJobs(t *testing.T) {
+	job := db.Job{
+		ApiKeyID:       pgtype.Text{String: "old-key", Valid: true},
+		OrganizationID: pgtype.Text{String: "org-1", Valid: true},
+	}
+	if !APIKeyOwner("new-key", "org-1").Owns(job) {
+		t.Fatal("expected any key of the organization to own its jobs")
+	}
+	if APIKeyOwner("new-key", "org-2").Owns(job) {
+		t.Fatal("expected a key of another organization not to own the job")
+	}
+	if APIKeyOwner("new-key", "").Owns(job) {
+		t.Fatal("expected a key without an organization not to own the job")
+	}
+	if !APIKeyOwner("old-key", "org-2").Owns(job) {
+		t.Fatal("expected the submitting key to keep owning its job after moving")
+	}
+}
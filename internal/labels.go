@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WorkerStaleAfter is how long a worker may go without a heartbeat before it
+// is no longer listed as live.
+const WorkerStaleAfter = 2 * time.Minute
+
+// ParseLabels splits a comma separated label list such as WORKER_LABELS,
+// e.g. "smtp-relay, high-mem", see NormalizeLabels.
+func ParseLabels(s string) ([]string, error) {
+	return NormalizeLabels(strings.Split(s, ","))
+}
+
+// NormalizeLabels trims, deduplicates and sorts labels, dropping empty ones.
+// It reports an error for a label containing whitespace or a comma.
+func NormalizeLabels(labels []string) ([]string, error) {
+	seen := make(map[string]bool, len(labels))
+	normalized := []string{}
+	var invalid error
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" || seen[label] {
+			continue
+		}
+		if strings.ContainsAny(label, ", \t\n") {
+			invalid = fmt.Errorf("invalid label %q", label)
+			continue
+		}
+		seen[label] = true
+		normalized = append(normalized, label)
+	}
+	sort.Strings(normalized)
+	return normalized, invalid
+}
@@ -0,0 +1,25 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	got, err := ParseLabels(" smtp-relay,high-mem,, smtp-relay ")
+	want := []string{"high-mem", "smtp-relay"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v, %v", want, got, err)
+	}
+	if got, err := ParseLabels(""); err != nil || got == nil || len(got) != 0 {
+		t.Fatalf("expected an empty, non-nil label list, got %#v, %v", got, err)
+	}
+	if _, err := ParseLabels("gpu,high mem"); err == nil {
+		t.Fatal("expected a label with a space to be rejected")
+	}
+}
+func TestNormalizeLabels_Invalid(t *testing.T) {
+	if _, err := NormalizeLabels([]string{"gpu", "high mem"}); err == nil {
+		t.Fatal("expected a label with a space to be rejected")
+	}
+}
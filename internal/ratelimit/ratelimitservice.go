@@ -1,6 +1,9 @@
 package ratelimit
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 type RateLimiter struct {
 	capacity   int
@@ -18,13 +21,35 @@ func NewRateLimiterService(capacity, refillRate int) *RateLimiter {
 	}
 }
 func (r *RateLimiter) Allow(keyID string) bool {
+	return r.bucket(keyID).TryConsume()
+}
+
+// Reserve is Allow for callers that would rather wait than be turned away, see
+// TokenBucket.Reserve.
+func (r *RateLimiter) Reserve(keyID string, maxWait time.Duration) (time.Duration, bool) {
+	return r.bucket(keyID).Reserve(maxWait)
+}
+
+// Cancel gives back the token of a reservation, see TokenBucket.Cancel.
+func (r *RateLimiter) Cancel(keyID string) {
+	r.bucket(keyID).Cancel()
+}
+
+// SetLimit gives the bucket of keyID its own capacity and refill rate, e.g.
+// the ones of an organization. A capacity of zero restores the defaults.
+func (r *RateLimiter) SetLimit(keyID string, capacity int, refillRate float64) {
+	if capacity <= 0 {
+		capacity, refillRate = r.capacity, float64(r.refillRate)
+	}
+	r.bucket(keyID).Resize(capacity, refillRate)
+}
+func (r *RateLimiter) bucket(keyID string) *TokenBucket {
 	r.mu.Lock()
-	// defer r.mu.Unlock()
+	defer r.mu.Unlock()
 	bucket, exists := r.Buckets[keyID]
 	if !exists {
 		bucket = NewTokenBucketService(r.capacity, r.refillRate)
 		r.Buckets[keyID] = bucket
 	}
-	r.mu.Unlock()
-	return bucket.TryConsume()
+	return bucket
 }
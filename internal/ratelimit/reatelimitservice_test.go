@@ -96,3 +96,80 @@ func BenchmarkRateLimiter_Allow(b *testing.B) {
 		rl.Allow("test_key")
 	}
 }
+
+func TestTokenBucket_Wait(t *testing.T) {
+	tb := NewTokenBucketService(1, 10) // 1 token, 10/sec refill
+
+	if wait := tb.Wait(); wait != 0 {
+		t.Fatalf("expected no wait with a full bucket, got %v", wait)
+	}
+	// overdrawn by half a token, so 50ms at 10 tokens/sec
+	tb = &TokenBucket{Tokens: -0.5, Capacity: 1, LastRefillTime: time.Now(), RefillRate: 10}
+	wait := tb.Wait()
+	if wait <= 0 || wait > 60*time.Millisecond {
+		t.Fatalf("expected roughly 50ms wait, got %v", wait)
+	}
+}
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	tb := &TokenBucket{Tokens: 1, Capacity: 1, LastRefillTime: time.Now(), RefillRate: 10}
+	if wait, ok := tb.Reserve(time.Second); !ok || wait != 0 {
+		t.Fatalf("expected the available token without waiting, got %v, %v", wait, ok)
+	}
+
+	// one token in debt at 10 tokens/sec: reservations are booked 100ms apart
+	tb = &TokenBucket{Tokens: -1, Capacity: 1, LastRefillTime: time.Now(), RefillRate: 10}
+	first, ok := tb.Reserve(time.Second)
+	if !ok || first < 90*time.Millisecond || first > 120*time.Millisecond {
+		t.Fatalf("expected the next token about 100ms out, got %v, %v", first, ok)
+	}
+	second, ok := tb.Reserve(time.Second)
+	if !ok || second < 190*time.Millisecond || second > 220*time.Millisecond {
+		t.Fatalf("expected the token after that about 200ms out, got %v, %v", second, ok)
+	}
+	if _, ok := tb.Reserve(50 * time.Millisecond); ok {
+		t.Fatal("expected a reservation beyond maxWait to be refused")
+	}
+	if tb.TryConsume() {
+		t.Fatal("expected reserved tokens to be unavailable to TryConsume")
+	}
+}
+
+func TestTokenBucket_Cancel(t *testing.T) {
+	tb := &TokenBucket{Tokens: 0, Capacity: 1, LastRefillTime: time.Now(), RefillRate: 10}
+	first, ok := tb.Reserve(time.Second)
+	if !ok {
+		t.Fatal("expected a reservation")
+	}
+	tb.Cancel()
+	// the cancelled token is free again for the next reservation
+	second, ok := tb.Reserve(time.Second)
+	if !ok || second > first {
+		t.Fatalf("expected the next reservation no later than %v, got %v, %v", first, second, ok)
+	}
+
+	full := &TokenBucket{Tokens: 1, Capacity: 1, LastRefillTime: time.Now(), RefillRate: 10}
+	full.Cancel()
+	if full.Tokens > 1 {
+		t.Fatalf("expected cancel to stop at capacity, got %v tokens", full.Tokens)
+	}
+}
+
+func TestRateLimiter_SetLimit(t *testing.T) {
+	rl := NewRateLimiterService(10, 1)
+	rl.SetLimit("org:acme", 2, 1)
+	if b := rl.Buckets["org:acme"]; b.Capacity != 2 || b.Tokens > 2 {
+		t.Fatalf("expected the custom capacity to cap the bucket, got %d with %v tokens", b.Capacity, b.Tokens)
+	}
+	if !rl.Allow("org:acme") || !rl.Allow("org:acme") {
+		t.Fatal("expected the custom burst to be available")
+	}
+
+	rl.SetLimit("org:acme", 0, 0)
+	if b := rl.Buckets["org:acme"]; b.Capacity != 10 || b.RefillRate != 1 {
+		t.Fatalf("expected the defaults back, got capacity %d and rate %v", b.Capacity, b.RefillRate)
+	}
+	if b := rl.Buckets["org:acme"]; b.Tokens > 1 {
+		t.Fatalf("expected resizing not to hand out free tokens, got %v", b.Tokens)
+	}
+}
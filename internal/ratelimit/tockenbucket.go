@@ -35,6 +35,66 @@ func (t *TokenBucket) TryConsume() bool {
 	return false
 
 }
+
+// Wait reports how long until TryConsume would succeed again, zero if it
+// would succeed now.
+func (t *TokenBucket) Wait() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Refill()
+	return t.wait()
+}
+
+// Reserve takes a token now if there is one. Otherwise it books the next token
+// to come, as long as that is at most maxWait away, by letting the bucket go
+// into debt. It returns how long the caller has to wait for its token.
+func (t *TokenBucket) Reserve(maxWait time.Duration) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Refill()
+	if t.Tokens > 0 {
+		t.Tokens--
+		return 0, true
+	}
+	if t.RefillRate <= 0 {
+		return 0, false
+	}
+	wait := t.wait()
+	if wait > maxWait {
+		return wait, false
+	}
+	t.Tokens--
+	return wait, true
+}
+
+// Cancel gives back a token taken by Reserve for a request that was then
+// turned away, so it does not push back the requests booked after it.
+func (t *TokenBucket) Cancel() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Refill()
+	t.Tokens = min(t.Tokens+1, float64(t.Capacity))
+}
+
+// Resize changes the bucket's capacity and refill rate, keeping the tokens it
+// holds up to the new capacity.
+func (t *TokenBucket) Resize(capacity int, refillRate float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Capacity == capacity && t.RefillRate == refillRate {
+		return
+	}
+	t.Refill()
+	t.Capacity = capacity
+	t.RefillRate = refillRate
+	t.Tokens = min(t.Tokens, float64(capacity))
+}
+func (t *TokenBucket) wait() time.Duration {
+	if t.Tokens > 0 || t.RefillRate <= 0 {
+		return 0
+	}
+	return time.Duration(-t.Tokens/t.RefillRate*float64(time.Second)) + time.Millisecond
+}
 func (t *TokenBucket) Refill() {
 	now := time.Now().UTC()
 	elapsedTime := now.Sub(t.LastRefillTime).Seconds()
@@ -2,9 +2,16 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -24,31 +31,252 @@ func NewRepositoryService(dbconn *pgxpool.Pool) *Repository {
 }
 
 func (r *Repository) CreateJob(ctx context.Context, arg db.CreateJobParams) (db.Job, error) {
+	if err := ensureTenant(ctx, &r.q, arg.ApiKeyID.String); err != nil {
+		return db.Job{}, err
+	}
 	job, err := r.q.CreateJob(ctx, arg)
 	if err != nil {
 		return db.Job{}, fmt.Errorf("could not create job in db: %w", err)
 	}
 	return job, nil
 }
+
+// EnqueueCoalescedJob folds the job into the pending job with the same type,
+// coalesce key and owner if there is one, see CoalesceJob, and creates it
+// otherwise. deferredUntil is set when the rate limiter deferred the job.
+func (r *Repository) EnqueueCoalescedJob(ctx context.Context, arg db.CreateCoalescedJobParams, deferredUntil pgtype.Timestamptz) (db.Job, error) {
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.Job{}, fmt.Errorf("could not begin coalesced enqueue: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	owner := coalesceOwner(arg.ApiKeyID, arg.OrganizationID)
+	lockKey := strings.Join([]string{arg.Type, arg.CoalesceKey.String, owner}, "\x1f")
+	if err := qtx.LockCoalesceKey(ctx, lockKey); err != nil {
+		return db.Job{}, fmt.Errorf("could not lock coalesce key %s: %w", arg.CoalesceKey.String, err)
+	}
+	pending, err := qtx.GetPendingCoalescedJobForUpdate(ctx, db.GetPendingCoalescedJobForUpdateParams{
+		Type:          arg.Type,
+		CoalesceKey:   arg.CoalesceKey,
+		CoalesceOwner: owner,
+	})
+	var job db.Job
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		if err = ensureTenant(ctx, qtx, arg.ApiKeyID.String); err == nil {
+			job, err = qtx.CreateCoalescedJob(ctx, arg)
+		}
+	case err == nil:
+		job, err = qtx.CoalesceJob(ctx, db.CoalesceJobParams{
+			ID:            pending.ID,
+			CoalesceMode:  arg.CoalesceMode.String,
+			Payload:       arg.Payload,
+			ExpiresAt:     arg.ExpiresAt,
+			Requires:      arg.Requires,
+			Tags:          arg.Tags,
+			ScheduledAt:   arg.ScheduledAt,
+			DeferredUntil: deferredUntil,
+		})
+	}
+	if err != nil {
+		return db.Job{}, fmt.Errorf("could not enqueue coalesced job in db: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.Job{}, fmt.Errorf("could not commit coalesced enqueue: %w", err)
+	}
+	return job, nil
+}
+
+// coalesceOwner matches the owner expression of GetPendingCoalescedJobForUpdate.
+func coalesceOwner(apiKeyID, organizationID pgtype.Text) string {
+	switch {
+	case apiKeyID.Valid:
+		return "key:" + apiKeyID.String
+	case organizationID.Valid:
+		return "org:" + organizationID.String
+	}
+	return ""
+}
 func (r *Repository) GetJob(ctx context.Context, id string) (db.Job, error) {
 	job, err := r.q.GetJob(ctx, id)
 	if err != nil {
-		return db.Job{}, fmt.Errorf("could not get job of id %s in db", id)
+		return db.Job{}, fmt.Errorf("could not get job of id %s in db: %w", id, err)
 	}
 	return job, nil
 }
-func (r *Repository) DequeueJob(ctx context.Context) (db.Job, error) {
-	job, err := r.q.DequeueJob(ctx)
+
+// CancelJob cancels a pending or quarantined job owned by owner. It returns
+// ErrJobNotFound if owner cannot see the job and ErrIllegalTransition if the
+// job has already started or finished.
+func (r *Repository) CancelJob(ctx context.Context, id string, owner Owner, reason string) (db.Job, error) {
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.Job{}, fmt.Errorf("could not begin cancel: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	job, err := qtx.GetJobForUpdate(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !owner.Owns(job)) {
+		return db.Job{}, ErrJobNotFound
+	}
+	if err != nil {
+		return db.Job{}, fmt.Errorf("could not get job of id %s: %w", id, err)
+	}
+	if !JobStatus(job.Status).CanTransitionTo(StatusCancelled) {
+		return db.Job{}, fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, job.Status, StatusCancelled)
+	}
+	cancelled, err := qtx.CancelJob(ctx, db.CancelJobParams{
+		ID:           id,
+		ErrorMessage: pgtype.Text{String: reason, Valid: true},
+	})
+	if err != nil {
+		return db.Job{}, fmt.Errorf("could not cancel job of id %s: %w", id, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.Job{}, fmt.Errorf("could not commit cancel: %w", err)
+	}
+	return cancelled, nil
+}
+
+// DequeueOptions controls which job DequeueJob may claim and for how long.
+type DequeueOptions struct {
+	Lease time.Duration
+	// ExcludedTypes are job types the caller has no capacity for.
+	ExcludedTypes []string
+	// Labels are the caller's capabilities. Only jobs whose requires are
+	// all among them are claimed.
+	Labels []string
+	// HandlerVersions maps job type to the version of the caller's handler
+	// for it. Jobs of a type under rollout are only claimed by the version
+	// they are routed to, and are tagged with it.
+	HandlerVersions map[string]string
+	// QuarantineThreshold stops DequeueJob from reclaiming jobs that have
+	// already lost this many attempts, see QuarantineLostJobs.
+	QuarantineThreshold int32
+}
+
+func DefaultDequeueOptions() DequeueOptions {
+	return DequeueOptions{
+		Lease:               DefaultLeaseDuration,
+		QuarantineThreshold: DefaultQuarantineThreshold,
+	}
+}
+
+// DequeueJob claims the next runnable job and issues it a fresh lease token.
+// Tenants (submitting api keys) take turns in proportion to their weight.
+// Jobs whose previous lease has expired are reclaimed as well. Jobs past their
+// expires_at are moved to expired first so they are never handed out.
+// If the job is outside its execution windows it is held until the next one
+// opens, and if its type is over its limits it is deferred, in the same
+// transaction; either way a *ThrottledError is returned.
+func (r *Repository) DequeueJob(ctx context.Context, opts DequeueOptions) (db.Job, error) {
+	excludedTypes := opts.ExcludedTypes
+	if excludedTypes == nil {
+		// NULL would make the type filter reject every job
+		excludedTypes = []string{}
+	}
+	labels := opts.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+	handlerVersions, err := json.Marshal(opts.HandlerVersions)
+	if err != nil {
+		return db.Job{}, fmt.Errorf("could not encode handler versions: %w", err)
+	}
+	if _, err := r.q.ExpireJobs(ctx); err != nil {
+		return db.Job{}, fmt.Errorf("could not expire jobs: %w", err)
+	}
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.Job{}, fmt.Errorf("could not begin dequeue: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	job, err := qtx.DequeueJob(ctx, db.DequeueJobParams{
+		LeaseToken:          pgtype.Text{String: uuid.New().String(), Valid: true},
+		LeaseExpiresAt:      pgtype.Timestamptz{Time: time.Now().Add(opts.Lease), Valid: true},
+		QuarantineThreshold: opts.QuarantineThreshold,
+		ExcludedTypes:       excludedTypes,
+		Labels:              labels,
+		HandlerVersions:     handlerVersions,
+	})
 	if err != nil {
 		return db.Job{}, fmt.Errorf("could not dequeue job: %w", err)
 	}
+	openAt, err := windowOpensAt(ctx, qtx, job.Type, job.Queue, time.Now())
+	if err != nil {
+		return db.Job{}, err
+	}
+	if !openAt.IsZero() {
+		_, err := qtx.HoldJobForWindow(ctx, db.HoldJobForWindowParams{
+			ID:          job.ID,
+			LeaseToken:  job.LeaseToken,
+			ScheduledAt: pgtype.Timestamptz{Time: openAt, Valid: true},
+		})
+		if err != nil {
+			return db.Job{}, fmt.Errorf("could not hold job of id %s: %w", job.ID, err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return db.Job{}, fmt.Errorf("could not commit dequeue: %w", err)
+		}
+		return db.Job{}, &ThrottledError{JobType: job.Type, reason: "held until its execution window opens at " + openAt.Format(time.RFC3339)}
+	}
+	delay, err := admitJob(ctx, qtx, job)
+	if err != nil {
+		return db.Job{}, err
+	}
+	deferredUntil := time.Now().Add(delay)
+	if delay > 0 {
+		_, err := qtx.DeferJob(ctx, db.DeferJobParams{
+			ID:          job.ID,
+			LeaseToken:  job.LeaseToken,
+			ScheduledAt: pgtype.Timestamptz{Time: deferredUntil, Valid: true},
+		})
+		if err != nil {
+			return db.Job{}, fmt.Errorf("could not defer job of id %s: %w", job.ID, err)
+		}
+	} else if err := chargeTenant(ctx, qtx, job); err != nil {
+		return db.Job{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.Job{}, fmt.Errorf("could not commit dequeue: %w", err)
+	}
+	if delay > 0 {
+		return db.Job{}, &ThrottledError{JobType: job.Type, Until: deferredUntil, reason: "deferred by " + delay.String()}
+	}
 	return job, nil
 }
+
+// FailJob records a failed attempt. arg.LeaseToken must be the token handed
+// out by DequeueJob; ErrStaleLease is returned if the job has since moved on.
 func (r *Repository) FailJob(ctx context.Context, arg db.FailJobParams) error {
-	return r.q.FailJob(ctx, arg)
+	if !StatusProcessing.CanTransitionTo(JobStatus(arg.Status)) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, StatusProcessing, arg.Status)
+	}
+	rows, err := r.q.FailJob(ctx, arg)
+	if err != nil {
+		return fmt.Errorf("could not fail job of id %s: %w", arg.ID, err)
+	}
+	if rows == 0 {
+		return ErrStaleLease
+	}
+	return nil
 }
-func (r *Repository) CompletedJob(ctx context.Context, id string) error {
-	return r.q.CompleteJob(ctx, id)
+
+// CompletedJob marks a job completed if leaseToken still owns it.
+func (r *Repository) CompletedJob(ctx context.Context, id string, leaseToken pgtype.Text) error {
+	rows, err := r.q.CompleteJob(ctx, db.CompleteJobParams{
+		ID:         id,
+		LeaseToken: leaseToken,
+	})
+	if err != nil {
+		return fmt.Errorf("could not complete job of id %s: %w", id, err)
+	}
+	if rows == 0 {
+		return ErrStaleLease
+	}
+	return nil
 }
 func (r *Repository) GetAPIKeyByHash(ctx context.Context, keyHash string) (db.ApiKey, error) {
 	return r.q.GetAPIKeyByHash(ctx, keyHash)
@@ -59,6 +287,701 @@ func (r *Repository) UpdateLastUsed(ctx context.Context, id string) error {
 func (r *Repository) CreateAPIKey(ctx context.Context, arg db.CreateAPIKeyParams) (db.ApiKey, error) {
 	return r.q.CreateAPIKey(ctx, arg)
 }
-func (r *Repository) ListAPIKeys(ctx context.Context) ([]db.ApiKey, error) {
-	return r.q.ListAPIKeys(ctx)
+func (r *Repository) SetAPIKeyDeferOverLimit(ctx context.Context, arg db.SetAPIKeyDeferOverLimitParams) (db.ApiKey, error) {
+	key, err := r.q.SetAPIKeyDeferOverLimit(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.ApiKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not set rate limit mode of api key %s: %w", arg.ID, err)
+	}
+	return key, nil
+}
+func (r *Repository) ListAPIKeys(ctx context.Context, organizationID pgtype.Text) ([]db.ApiKey, error) {
+	return r.q.ListAPIKeys(ctx, organizationID)
+}
+func (r *Repository) SetAPIKeyScopes(ctx context.Context, arg db.SetAPIKeyScopesParams) (db.ApiKey, error) {
+	key, err := r.q.SetAPIKeyScopes(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.ApiKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not set scopes of api key %s: %w", arg.ID, err)
+	}
+	return key, nil
+}
+func (r *Repository) SetAPIKeyAllowedCIDRs(ctx context.Context, arg db.SetAPIKeyAllowedCIDRsParams) (db.ApiKey, error) {
+	key, err := r.q.SetAPIKeyAllowedCIDRs(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.ApiKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not set allowed CIDRs of api key %s: %w", arg.ID, err)
+	}
+	return key, nil
+}
+func (r *Repository) RecordAPIKeyIPDenial(ctx context.Context, id string) error {
+	return r.q.RecordAPIKeyIPDenial(ctx, id)
+}
+
+// SetAPIKeySigningSecret returns ErrAPIKeyNotFound for revoked keys too.
+func (r *Repository) SetAPIKeySigningSecret(ctx context.Context, arg db.SetAPIKeySigningSecretParams) (db.ApiKey, error) {
+	key, err := r.q.SetAPIKeySigningSecret(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.ApiKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not set signing secret of api key %s: %w", arg.ID, err)
+	}
+	return key, nil
+}
+func (r *Repository) GetAPIKey(ctx context.Context, id string) (db.ApiKey, error) {
+	key, err := r.q.GetAPIKey(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.ApiKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not get api key %s: %w", id, err)
+	}
+	return key, nil
+}
+
+// RevokeAPIKey deactivates a key for good. Revoking a revoked key is a no-op.
+func (r *Repository) RevokeAPIKey(ctx context.Context, id string) error {
+	rows, err := r.q.DeactivateAPIKey(ctx, id)
+	if err != nil {
+		return fmt.Errorf("could not revoke api key %s: %w", id, err)
+	}
+	if rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// SetAPIKeyExpiry returns ErrAPIKeyInactive if key arg.ID is revoked.
+func (r *Repository) SetAPIKeyExpiry(ctx context.Context, arg db.SetAPIKeyExpiryParams) (db.ApiKey, error) {
+	key, err := r.q.SetAPIKeyExpiry(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := r.GetAPIKey(ctx, arg.ID); err != nil {
+			return db.ApiKey{}, err
+		}
+		return db.ApiKey{}, ErrAPIKeyInactive
+	}
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not set expiry of api key %s: %w", arg.ID, err)
+	}
+	return key, nil
+}
+func (r *Repository) UpdateAPIKeyMetadata(ctx context.Context, arg db.UpdateAPIKeyMetadataParams) (db.ApiKey, error) {
+	key, err := r.q.UpdateAPIKeyMetadata(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.ApiKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not update api key %s: %w", arg.ID, err)
+	}
+	return key, nil
+}
+
+// RotateAPIKey creates replacement, which takes over the settings and
+// permissions of key id,
+// and lets key id work until overlapEnds. It returns ErrAPIKeyInactive if
+// key id is revoked or expired.
+func (r *Repository) RotateAPIKey(ctx context.Context, id string, replacement db.CreateAPIKeyParams, overlapEnds time.Time) (db.ApiKey, error) {
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not begin rotation: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	old, err := qtx.GetAPIKeyForUpdate(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.ApiKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not get api key %s: %w", id, err)
+	}
+	if !old.IsActive || (old.ExpiresAt.Valid && !old.ExpiresAt.Time.After(time.Now())) {
+		return db.ApiKey{}, ErrAPIKeyInactive
+	}
+	replacement.Name = old.Name
+	replacement.Description = old.Description
+	replacement.DeferOverLimit = old.DeferOverLimit
+	replacement.OrganizationID = old.OrganizationID
+	replacement.Scopes = old.Scopes
+	replacement.AllowedTypes = old.AllowedTypes
+	replacement.AllowedQueues = old.AllowedQueues
+	replacement.AllowedCidrs = old.AllowedCidrs
+	key, err := qtx.CreateAPIKey(ctx, replacement)
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not create replacement of api key %s: %w", id, err)
+	}
+	_, err = qtx.RetireRotatedAPIKey(ctx, db.RetireRotatedAPIKeyParams{
+		ID:          id,
+		OverlapEnds: pgtype.Timestamptz{Time: overlapEnds, Valid: true},
+		ReplacedBy:  pgtype.Text{String: key.ID, Valid: true},
+	})
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not retire api key %s: %w", id, err)
+	}
+	// client certificates authenticate as the new key right away
+	err = qtx.MoveClientIdentities(ctx, db.MoveClientIdentitiesParams{
+		ToApiKeyID:   key.ID,
+		FromApiKeyID: id,
+	})
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not move client identities of api key %s: %w", id, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not commit rotation: %w", err)
+	}
+	return key, nil
+}
+
+// AddClientIdentity returns ErrClientIdentityTaken if the identity already
+// belongs to a key.
+func (r *Repository) AddClientIdentity(ctx context.Context, arg db.AddClientIdentityParams) (db.ApiKeyClientIdentity, error) {
+	identity, err := r.q.AddClientIdentity(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.ApiKeyClientIdentity{}, ErrClientIdentityTaken
+	}
+	if err != nil {
+		return db.ApiKeyClientIdentity{}, fmt.Errorf("could not add client identity to api key %s: %w", arg.ApiKeyID, err)
+	}
+	return identity, nil
+}
+func (r *Repository) ListClientIdentities(ctx context.Context, apiKeyID string) ([]db.ApiKeyClientIdentity, error) {
+	return r.q.ListClientIdentities(ctx, apiKeyID)
+}
+func (r *Repository) DeleteClientIdentity(ctx context.Context, apiKeyID, id string) error {
+	deleted, err := r.q.DeleteClientIdentity(ctx, db.DeleteClientIdentityParams{
+		ID:       id,
+		ApiKeyID: apiKeyID,
+	})
+	if err != nil {
+		return fmt.Errorf("could not delete client identity %s: %w", id, err)
+	}
+	if deleted == 0 {
+		return ErrClientIdentityNotFound
+	}
+	return nil
+}
+
+// GetAPIKeysByClientIdentities returns the active keys any of identities
+// belongs to.
+func (r *Repository) GetAPIKeysByClientIdentities(ctx context.Context, identities []string) ([]db.ApiKey, error) {
+	return r.q.GetAPIKeysByClientIdentities(ctx, identities)
+}
+func (r *Repository) SetAPIKeyOrganization(ctx context.Context, arg db.SetAPIKeyOrganizationParams) (db.ApiKey, error) {
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not begin organization change: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	key, err := qtx.SetAPIKeyOrganization(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.ApiKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not set organization of api key %s: %w", arg.ID, err)
+	}
+	if arg.OrganizationID.Valid {
+		err := qtx.AdoptAPIKeyJobs(ctx, db.AdoptAPIKeyJobsParams{
+			ApiKeyID:       pgtype.Text{String: arg.ID, Valid: true},
+			OrganizationID: arg.OrganizationID,
+		})
+		if err != nil {
+			return db.ApiKey{}, fmt.Errorf("could not move jobs of api key %s to organization %s: %w", arg.ID, arg.OrganizationID.String, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.ApiKey{}, fmt.Errorf("could not commit organization change: %w", err)
+	}
+	return key, nil
+}
+
+// GetOrganization returns ErrOrganizationNotFound if there is no organization
+// with that id.
+func (r *Repository) GetOrganization(ctx context.Context, id string) (db.Organization, error) {
+	org, err := r.q.GetOrganization(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Organization{}, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return db.Organization{}, fmt.Errorf("could not get organization %s: %w", id, err)
+	}
+	return org, nil
+}
+func (r *Repository) ListOrganizations(ctx context.Context) ([]db.Organization, error) {
+	return r.q.ListOrganizations(ctx)
+}
+func (r *Repository) UpsertOrganization(ctx context.Context, arg db.UpsertOrganizationParams) (db.Organization, error) {
+	return r.q.UpsertOrganization(ctx, arg)
+}
+func (r *Repository) DeleteOrganization(ctx context.Context, id string) error {
+	return r.q.DeleteOrganization(ctx, id)
+}
+
+// LookupSideEffect returns the outcome recorded for an idempotency key, if any.
+func (r *Repository) LookupSideEffect(ctx context.Context, key string) (json.RawMessage, bool, error) {
+	effect, err := r.q.GetSideEffect(ctx, key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("could not get side effect %s: %w", key, err)
+	}
+	return effect.Outcome, true, nil
+}
+func (r *Repository) RecordSideEffect(ctx context.Context, key, jobID string, outcome json.RawMessage) error {
+	return r.q.RecordSideEffect(ctx, db.RecordSideEffectParams{
+		Key:     key,
+		JobID:   jobID,
+		Outcome: outcome,
+	})
+}
+
+// JobStats counts jobs per status, plus how many expired since the given time.
+func (r *Repository) JobStats(ctx context.Context, expiredSince time.Time) (map[string]int64, int64, error) {
+	rows, err := r.q.CountJobsGroupByStatus(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not count jobs: %w", err)
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	expired, err := r.q.CountExpiredJobsSince(ctx, pgtype.Timestamptz{Time: expiredSince, Valid: true})
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not count expired jobs: %w", err)
+	}
+	return counts, expired, nil
+}
+
+func (r *Repository) ListJobTypeLimits(ctx context.Context) ([]db.JobTypeLimit, error) {
+	return r.q.ListJobTypeLimits(ctx)
+}
+func (r *Repository) UpsertJobTypeLimit(ctx context.Context, arg db.UpsertJobTypeLimitParams) (db.JobTypeLimit, error) {
+	return r.q.UpsertJobTypeLimit(ctx, arg)
+}
+func (r *Repository) DeleteJobTypeLimit(ctx context.Context, jobType string) error {
+	return r.q.DeleteJobTypeLimit(ctx, jobType)
+}
+
+// RecordBreakerOutcome feeds one job result into the circuit breaker of its
+// type, creating the breaker with default settings on first use.
+func (r *Repository) RecordBreakerOutcome(ctx context.Context, jobType string, failed bool) (db.CircuitBreaker, error) {
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.CircuitBreaker{}, fmt.Errorf("could not begin breaker update: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	if err := qtx.EnsureCircuitBreaker(ctx, jobType); err != nil {
+		return db.CircuitBreaker{}, fmt.Errorf("could not create circuit breaker for %s: %w", jobType, err)
+	}
+	b, err := qtx.GetCircuitBreakerForUpdate(ctx, jobType)
+	if err != nil {
+		return db.CircuitBreaker{}, fmt.Errorf("could not get circuit breaker for %s: %w", jobType, err)
+	}
+	b = recordBreakerOutcome(b, failed, time.Now())
+	if err := qtx.UpdateCircuitBreaker(ctx, updateBreakerParams(b)); err != nil {
+		return db.CircuitBreaker{}, fmt.Errorf("could not update circuit breaker for %s: %w", jobType, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.CircuitBreaker{}, fmt.Errorf("could not commit breaker update: %w", err)
+	}
+	return b, nil
+}
+func (r *Repository) ListCircuitBreakers(ctx context.Context) ([]db.CircuitBreaker, error) {
+	return r.q.ListCircuitBreakers(ctx)
+}
+func (r *Repository) ConfigureCircuitBreaker(ctx context.Context, arg db.ConfigureCircuitBreakerParams) (db.CircuitBreaker, error) {
+	return r.q.ConfigureCircuitBreaker(ctx, arg)
+}
+func (r *Repository) ResetCircuitBreaker(ctx context.Context, jobType string) (db.CircuitBreaker, error) {
+	b, err := r.q.ResetCircuitBreaker(ctx, jobType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.CircuitBreaker{}, ErrCircuitBreakerNotFound
+	}
+	if err != nil {
+		return db.CircuitBreaker{}, fmt.Errorf("could not reset circuit breaker of %s: %w", jobType, err)
+	}
+	return b, nil
+}
+
+// QuarantineLostJobs quarantines the jobs whose lease expired for the
+// threshold-th time and returns them.
+func (r *Repository) QuarantineLostJobs(ctx context.Context, threshold int32) ([]db.Job, error) {
+	jobs, err := r.q.QuarantineLostJobs(ctx, threshold)
+	if err != nil {
+		return nil, fmt.Errorf("could not quarantine lost jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// CrashJob records an attempt that crashed its handler, fenced by the lease
+// token like FailJob.
+func (r *Repository) CrashJob(ctx context.Context, arg db.CrashJobParams) (db.Job, error) {
+	job, err := r.q.CrashJob(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Job{}, ErrStaleLease
+	}
+	if err != nil {
+		return db.Job{}, fmt.Errorf("could not record crash of job %s: %w", arg.ID, err)
+	}
+	return job, nil
+}
+func (r *Repository) ReleaseQuarantinedJob(ctx context.Context, id string) (db.Job, error) {
+	job, err := r.q.ReleaseQuarantinedJob(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Job{}, ErrJobNotFound
+	}
+	if err != nil {
+		return db.Job{}, fmt.Errorf("could not release quarantined job %s: %w", id, err)
+	}
+	return job, nil
+}
+func (r *Repository) SearchJobs(ctx context.Context, arg db.SearchJobsParams) ([]db.Job, error) {
+	jobs, err := r.q.SearchJobs(ctx, arg)
+	if err != nil {
+		return nil, fmt.Errorf("could not search jobs: %w", err)
+	}
+	return jobs, nil
+}
+func (r *Repository) ListJobs(ctx context.Context, arg db.ListJobsParams) ([]db.Job, error) {
+	return r.q.ListJobs(ctx, arg)
+}
+func (r *Repository) ListTenantSchedule(ctx context.Context) ([]db.TenantSchedule, error) {
+	return r.q.ListTenantSchedule(ctx)
+}
+func (r *Repository) SetTenantWeight(ctx context.Context, arg db.SetTenantWeightParams) (db.TenantSchedule, error) {
+	return r.q.SetTenantWeight(ctx, arg)
+}
+func (r *Repository) ListBacklogLimits(ctx context.Context) ([]db.BacklogLimit, error) {
+	return r.q.ListBacklogLimits(ctx)
+}
+func (r *Repository) UpsertBacklogLimit(ctx context.Context, arg db.UpsertBacklogLimitParams) (db.BacklogLimit, error) {
+	return r.q.UpsertBacklogLimit(ctx, arg)
+}
+func (r *Repository) DeleteBacklogLimit(ctx context.Context, arg db.DeleteBacklogLimitParams) error {
+	return r.q.DeleteBacklogLimit(ctx, arg)
+}
+
+// CountPendingJobs counts the pending jobs a backlog limit of the given scope
+// applies to.
+func (r *Repository) CountPendingJobs(ctx context.Context, scope BacklogScope, name string) (int64, error) {
+	switch scope {
+	case BacklogGlobal:
+		return r.q.CountJobsByStatus(ctx, string(StatusPending))
+	case BacklogQueue:
+		return r.q.CountPendingJobsByQueue(ctx, name)
+	case BacklogType:
+		return r.q.CountPendingJobsByType(ctx, name)
+	case BacklogAPIKey:
+		return r.q.CountPendingJobsByAPIKey(ctx, pgtype.Text{String: name, Valid: true})
+	case BacklogOrganization:
+		return r.q.CountPendingJobsByOrganization(ctx, pgtype.Text{String: name, Valid: true})
+	}
+	return 0, fmt.Errorf("unknown backlog scope %q", scope)
+}
+
+// WindowOpensAt returns when a job of jobType in queue that is due at runAt
+// may actually run, or the zero time if it may run at runAt.
+func (r *Repository) WindowOpensAt(ctx context.Context, jobType, queue string, runAt time.Time) (time.Time, error) {
+	return windowOpensAt(ctx, &r.q, jobType, queue, runAt)
+}
+func (r *Repository) ListExecutionWindows(ctx context.Context) ([]db.ExecutionWindow, error) {
+	return r.q.ListExecutionWindows(ctx)
+}
+func (r *Repository) CreateExecutionWindow(ctx context.Context, arg db.CreateExecutionWindowParams) (db.ExecutionWindow, error) {
+	return r.q.CreateExecutionWindow(ctx, arg)
+}
+func (r *Repository) DeleteExecutionWindow(ctx context.Context, id int64) (db.ExecutionWindow, error) {
+	window, err := r.q.DeleteExecutionWindow(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.ExecutionWindow{}, ErrExecutionWindowNotFound
+	}
+	if err != nil {
+		return db.ExecutionWindow{}, fmt.Errorf("could not delete execution window %d: %w", id, err)
+	}
+	return window, nil
+}
+
+// RescheduleWindowHeldJobs moves the pending jobs held for the windows of a
+// job type or queue to their next opening under the current windows, or
+// releases them if they may run now.
+func (r *Repository) RescheduleWindowHeldJobs(ctx context.Context, scope, name string) error {
+	groups, err := r.q.ListWindowHeldJobGroups(ctx, db.ListWindowHeldJobGroupsParams{
+		Scope: scope,
+		Name:  name,
+	})
+	if err != nil {
+		return fmt.Errorf("could not list held jobs for %s %s: %w", scope, name, err)
+	}
+	for _, group := range groups {
+		now := time.Now()
+		openAt, err := windowOpensAt(ctx, &r.q, group.Type, group.Queue, now)
+		if err != nil {
+			return err
+		}
+		scheduledAt := now
+		if !openAt.IsZero() {
+			scheduledAt = openAt
+		}
+		_, err = r.q.RescheduleWindowHeldJobs(ctx, db.RescheduleWindowHeldJobsParams{
+			ScheduledAt: pgtype.Timestamptz{Time: scheduledAt, Valid: true},
+			WindowHeld:  !openAt.IsZero(),
+			Type:        group.Type,
+			Queue:       group.Queue,
+		})
+		if err != nil {
+			return fmt.Errorf("could not reschedule held %s jobs: %w", group.Type, err)
+		}
+	}
+	return nil
+}
+
+// RegisterWorker records a worker and its labels, and doubles as its
+// heartbeat.
+func (r *Repository) RegisterWorker(ctx context.Context, arg db.RegisterWorkerParams) (db.Worker, error) {
+	worker, err := r.q.RegisterWorker(ctx, arg)
+	if err != nil {
+		return db.Worker{}, fmt.Errorf("could not register worker %s: %w", arg.ID, err)
+	}
+	return worker, nil
+}
+
+// ListLiveWorkers lists the workers that sent a heartbeat within
+// WorkerStaleAfter.
+func (r *Repository) ListLiveWorkers(ctx context.Context) ([]db.Worker, error) {
+	return r.q.ListWorkers(ctx, pgtype.Timestamptz{Time: time.Now().Add(-WorkerStaleAfter), Valid: true})
+}
+
+// RecordHandlerOutcome adds one job result to its handler version's stats. If
+// the job type is under rollout and the canary has regressed, the rollout is
+// rolled back in the same transaction and returned with rolledBack set.
+func (r *Repository) RecordHandlerOutcome(ctx context.Context, jobType, version string, failed bool, latency time.Duration) (rollout db.HandlerRollout, rolledBack bool, err error) {
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.HandlerRollout{}, false, fmt.Errorf("could not begin handler outcome: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	arg := db.RecordHandlerVersionOutcomeParams{
+		JobType:   jobType,
+		Version:   version,
+		LatencyMs: latency.Milliseconds(),
+	}
+	if failed {
+		arg.Failures = 1
+	} else {
+		arg.Successes = 1
+	}
+	if _, err := qtx.RecordHandlerVersionOutcome(ctx, arg); err != nil {
+		return db.HandlerRollout{}, false, fmt.Errorf("could not record outcome of %s %s: %w", jobType, version, err)
+	}
+	rollout, err = qtx.GetHandlerRolloutForUpdate(ctx, jobType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.HandlerRollout{}, false, tx.Commit(ctx)
+	}
+	if err != nil {
+		return db.HandlerRollout{}, false, fmt.Errorf("could not get rollout of %s: %w", jobType, err)
+	}
+	stable, err := handlerVersionStat(ctx, qtx, jobType, rollout.StableVersion)
+	if err != nil {
+		return db.HandlerRollout{}, false, err
+	}
+	canary, err := handlerVersionStat(ctx, qtx, jobType, rollout.CanaryVersion)
+	if err != nil {
+		return db.HandlerRollout{}, false, err
+	}
+	if reason, regressed := rolloutRegression(rollout, stable, canary); regressed {
+		rollout, err = qtx.RollBackHandlerRollout(ctx, db.RollBackHandlerRolloutParams{
+			JobType: jobType,
+			Reason:  pgtype.Text{String: reason, Valid: true},
+		})
+		if err != nil {
+			return db.HandlerRollout{}, false, fmt.Errorf("could not roll back %s: %w", jobType, err)
+		}
+		rolledBack = true
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.HandlerRollout{}, false, fmt.Errorf("could not commit handler outcome: %w", err)
+	}
+	return rollout, rolledBack, nil
+}
+func handlerVersionStat(ctx context.Context, q *db.Queries, jobType, version string) (db.HandlerVersionStat, error) {
+	stat, err := q.GetHandlerVersionStat(ctx, db.GetHandlerVersionStatParams{
+		JobType: jobType,
+		Version: version,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.HandlerVersionStat{JobType: jobType, Version: version}, nil
+	}
+	if err != nil {
+		return db.HandlerVersionStat{}, fmt.Errorf("could not get stats of %s %s: %w", jobType, version, err)
+	}
+	return stat, nil
+}
+
+// StartHandlerRollout creates or replaces the rollout of a job type and
+// clears its version stats, so the comparison starts afresh.
+func (r *Repository) StartHandlerRollout(ctx context.Context, arg db.UpsertHandlerRolloutParams) (db.HandlerRollout, error) {
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.HandlerRollout{}, fmt.Errorf("could not begin rollout: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	if err := qtx.ResetHandlerVersionStats(ctx, arg.JobType); err != nil {
+		return db.HandlerRollout{}, fmt.Errorf("could not reset stats of %s: %w", arg.JobType, err)
+	}
+	rollout, err := qtx.UpsertHandlerRollout(ctx, arg)
+	if err != nil {
+		return db.HandlerRollout{}, fmt.Errorf("could not save rollout of %s: %w", arg.JobType, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.HandlerRollout{}, fmt.Errorf("could not commit rollout: %w", err)
+	}
+	return rollout, nil
+}
+func (r *Repository) ListHandlerRollouts(ctx context.Context) ([]db.HandlerRollout, error) {
+	return r.q.ListHandlerRollouts(ctx)
+}
+func (r *Repository) ListHandlerVersionStats(ctx context.Context) ([]db.HandlerVersionStat, error) {
+	return r.q.ListHandlerVersionStats(ctx)
+}
+func (r *Repository) RollBackHandlerRollout(ctx context.Context, arg db.RollBackHandlerRolloutParams) (db.HandlerRollout, error) {
+	rollout, err := r.q.RollBackHandlerRollout(ctx, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.HandlerRollout{}, ErrRolloutNotFound
+	}
+	if err != nil {
+		return db.HandlerRollout{}, fmt.Errorf("could not roll back rollout of %s: %w", arg.JobType, err)
+	}
+	return rollout, nil
+}
+func (r *Repository) DeleteHandlerRollout(ctx context.Context, jobType string) error {
+	return r.q.DeleteHandlerRollout(ctx, jobType)
+}
+
+// AuthenticateAdminToken looks up an unrevoked token of an active principal
+// by its hash.
+func (r *Repository) AuthenticateAdminToken(ctx context.Context, tokenHash string) (db.GetAdminTokenByHashRow, error) {
+	return r.q.GetAdminTokenByHash(ctx, tokenHash)
+}
+func (r *Repository) CountActiveAdminOwners(ctx context.Context) (int64, error) {
+	return r.q.CountActiveAdminOwners(ctx)
+}
+func (r *Repository) TouchAdminToken(ctx context.Context, id string) error {
+	return r.q.TouchAdminToken(ctx, id)
+}
+func (r *Repository) RecordAdminAction(ctx context.Context, arg db.RecordAdminActionParams) error {
+	return r.q.RecordAdminAction(ctx, arg)
+}
+func (r *Repository) ListAdminActions(ctx context.Context, arg db.ListAdminActionsParams) ([]db.AdminAuditLog, error) {
+	return r.q.ListAdminActions(ctx, arg)
+}
+func (r *Repository) ListAdminPrincipals(ctx context.Context) ([]db.AdminPrincipal, error) {
+	return r.q.ListAdminPrincipals(ctx)
+}
+
+// CreateAdminPrincipal creates a principal together with its first token.
+func (r *Repository) CreateAdminPrincipal(ctx context.Context, arg db.CreateAdminPrincipalParams, token db.CreateAdminTokenParams) (db.AdminPrincipal, error) {
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.AdminPrincipal{}, fmt.Errorf("could not begin creating admin principal: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	principal, err := qtx.CreateAdminPrincipal(ctx, arg)
+	if err != nil {
+		return db.AdminPrincipal{}, fmt.Errorf("could not create admin principal %s: %w", arg.Name, err)
+	}
+	token.PrincipalID = principal.ID
+	if _, err := qtx.CreateAdminToken(ctx, token); err != nil {
+		return db.AdminPrincipal{}, fmt.Errorf("could not create token of admin principal %s: %w", arg.Name, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.AdminPrincipal{}, fmt.Errorf("could not commit admin principal: %w", err)
+	}
+	return principal, nil
+}
+
+// UpdateAdminPrincipal changes the role of a principal or (de)activates it.
+// It returns ErrLastOwner rather than leave no active owner.
+func (r *Repository) UpdateAdminPrincipal(ctx context.Context, arg db.UpdateAdminPrincipalParams) (db.AdminPrincipal, error) {
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.AdminPrincipal{}, fmt.Errorf("could not begin updating admin principal: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	owners, err := qtx.LockActiveAdminOwners(ctx)
+	if err != nil {
+		return db.AdminPrincipal{}, fmt.Errorf("could not lock admin owners: %w", err)
+	}
+	principal, err := qtx.GetAdminPrincipalForUpdate(ctx, arg.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.AdminPrincipal{}, ErrAdminPrincipalNotFound
+	}
+	if err != nil {
+		return db.AdminPrincipal{}, fmt.Errorf("could not get admin principal %s: %w", arg.ID, err)
+	}
+	role, active := principal.Role, principal.IsActive
+	if arg.Role.Valid {
+		role = arg.Role.String
+	}
+	if arg.IsActive.Valid {
+		active = arg.IsActive.Bool
+	}
+	wasOwner := principal.IsActive && AdminRole(principal.Role) == RoleOwner
+	staysOwner := active && AdminRole(role) == RoleOwner
+	if wasOwner && !staysOwner && len(owners) <= 1 {
+		return db.AdminPrincipal{}, ErrLastOwner
+	}
+	updated, err := qtx.UpdateAdminPrincipal(ctx, arg)
+	if err != nil {
+		return db.AdminPrincipal{}, fmt.Errorf("could not update admin principal %s: %w", arg.ID, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.AdminPrincipal{}, fmt.Errorf("could not commit admin principal: %w", err)
+	}
+	return updated, nil
+}
+func (r *Repository) CreateAdminToken(ctx context.Context, arg db.CreateAdminTokenParams) (db.AdminToken, error) {
+	tx, err := r.dbconn.Begin(ctx)
+	if err != nil {
+		return db.AdminToken{}, fmt.Errorf("could not begin creating admin token: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	if _, err := qtx.GetAdminPrincipalForUpdate(ctx, arg.PrincipalID); errors.Is(err, pgx.ErrNoRows) {
+		return db.AdminToken{}, ErrAdminPrincipalNotFound
+	} else if err != nil {
+		return db.AdminToken{}, fmt.Errorf("could not get admin principal %s: %w", arg.PrincipalID, err)
+	}
+	token, err := qtx.CreateAdminToken(ctx, arg)
+	if err != nil {
+		return db.AdminToken{}, fmt.Errorf("could not create admin token: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.AdminToken{}, fmt.Errorf("could not commit admin token: %w", err)
+	}
+	return token, nil
+}
+func (r *Repository) ListAdminTokens(ctx context.Context, principalID string) ([]db.ListAdminTokensRow, error) {
+	return r.q.ListAdminTokens(ctx, principalID)
+}
+func (r *Repository) RevokeAdminToken(ctx context.Context, arg db.RevokeAdminTokenParams) error {
+	rows, err := r.q.RevokeAdminToken(ctx, arg)
+	if err != nil {
+		return fmt.Errorf("could not revoke admin token %s: %w", arg.ID, err)
+	}
+	if rows == 0 {
+		return ErrAdminTokenNotFound
+	}
+	return nil
 }
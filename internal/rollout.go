@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+)
+
+// RolloutState is the state stored in handler_rollouts.state.
+type RolloutState string
+
+const (
+	RolloutActive     RolloutState = "active"
+	RolloutRolledBack RolloutState = "rolled_back"
+)
+
+var ErrRolloutNotFound = errors.New("rollout not found")
+
+// VersionReport summarises how one handler version has done since its job
+// type's rollout started.
+type VersionReport struct {
+	Version      string  `json:"version"`
+	Successes    int64   `json:"successes"`
+	Failures     int64   `json:"failures"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+type RolloutReport struct {
+	db.HandlerRollout
+	Versions []VersionReport `json:"versions"`
+}
+
+func versionReport(s db.HandlerVersionStat) VersionReport {
+	report := VersionReport{
+		Version:   s.Version,
+		Successes: s.Successes,
+		Failures:  s.Failures,
+	}
+	if total := s.Successes + s.Failures; total > 0 {
+		report.ErrorRate = float64(s.Failures) / float64(total)
+		report.AvgLatencyMs = float64(s.TotalLatencyMs) / float64(total)
+	}
+	return report
+}
+
+// rolloutRegression compares the canary against the stable version once both
+// have run at least MinJobs jobs, and reports why the rollout should be
+// rolled back if the canary's error rate is worse by more than
+// MaxErrorRateIncrease.
+func rolloutRegression(r db.HandlerRollout, stable, canary db.HandlerVersionStat) (string, bool) {
+	if RolloutState(r.State) != RolloutActive {
+		return "", false
+	}
+	if stable.Successes+stable.Failures < int64(r.MinJobs) || canary.Successes+canary.Failures < int64(r.MinJobs) {
+		return "", false
+	}
+	stableRate := versionReport(stable).ErrorRate
+	canaryRate := versionReport(canary).ErrorRate
+	if canaryRate-stableRate <= r.MaxErrorRateIncrease {
+		return "", false
+	}
+	return fmt.Sprintf("error rate of %s is %.1f%% against %.1f%% for %s",
+		r.CanaryVersion, canaryRate*100, stableRate*100, r.StableVersion), true
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"testing"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+)
+
+func TestRolloutRegression(t *testing.T) {
+	rollout := db.HandlerRollout{
+		StableVersion:        "1",
+		CanaryVersion:        "2",
+		State:                string(RolloutActive),
+		MinJobs:              50,
+		MaxErrorRateIncrease: 0.05,
+	}
+	stable := db.HandlerVersionStat{Version: "1", Successes: 98, Failures: 2}
+	tests := []struct {
+		name    string
+		rollout db.HandlerRollout
+		canary  db.HandlerVersionStat
+		want    bool
+	}{
+		{
+			name:    "canary as good as stable",
+			rollout: rollout,
+			canary:  db.HandlerVersionStat{Version: "2", Successes: 97, Failures: 3},
+		},
+		{
+			name:    "canary regressed",
+			rollout: rollout,
+			canary:  db.HandlerVersionStat{Version: "2", Successes: 80, Failures: 20},
+			want:    true,
+		},
+		{
+			name:    "too few canary jobs to tell",
+			rollout: rollout,
+			canary:  db.HandlerVersionStat{Version: "2", Successes: 10, Failures: 20},
+		},
+		{
+			name: "already rolled back",
+			rollout: func() db.HandlerRollout {
+				r := rollout
+				r.State = string(RolloutRolledBack)
+				return r
+			}(),
+			canary: db.HandlerVersionStat{Version: "2", Successes: 80, Failures: 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, got := rolloutRegression(tt.rollout, stable, tt.canary)
+			if got != tt.want {
+				t.Fatalf("expected regression %v, got %v (%s)", tt.want, got, reason)
+			}
+			if got && reason == "" {
+				t.Fatal("expected a reason for the rollback")
+			}
+		})
+	}
+}
+func TestVersionReport(t *testing.T) {
+	report := versionReport(db.HandlerVersionStat{Version: "2", Successes: 3, Failures: 1, TotalLatencyMs: 400})
+	if report.ErrorRate != 0.25 || report.AvgLatencyMs != 100 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// Scope is a permission stored in api_keys.scopes.
+type Scope string
+
+const (
+	ScopeJobsWrite  Scope = "jobs:write"
+	ScopeJobsRead   Scope = "jobs:read"
+	ScopeJobsCancel Scope = "jobs:cancel"
+	// ScopeAdminKeys lets a key manage the API keys of its organization.
+	ScopeAdminKeys Scope = "admin:keys"
+)
+
+// DefaultScopes are given to keys created without scopes.
+var DefaultScopes = []string{string(ScopeJobsCancel), string(ScopeJobsRead), string(ScopeJobsWrite)}
+
+var knownScopes = []Scope{ScopeJobsWrite, ScopeJobsRead, ScopeJobsCancel, ScopeAdminKeys}
+
+// KeyPermissions is what an API key may do: its scopes, the job types and
+// queues it may submit to, and the networks it may be used from. An empty
+// allowlist allows everything.
+type KeyPermissions struct {
+	Scopes        []string `json:"scopes"`
+	AllowedTypes  []string `json:"allowed_types"`
+	AllowedQueues []string `json:"allowed_queues"`
+	AllowedCIDRs  []string `json:"allowed_cidrs"`
+}
+
+// NewKeyPermissions validates and normalizes the permissions of a key. nil
+// scopes mean DefaultScopes; an empty list is a key that can do nothing.
+func NewKeyPermissions(scopes, allowedTypes, allowedQueues []string) (KeyPermissions, error) {
+	var p KeyPermissions
+	var err error
+	if p.Scopes, err = normalizeScopes(scopes); err != nil {
+		return KeyPermissions{}, err
+	}
+	if p.AllowedTypes, err = NormalizeLabels(allowedTypes); err != nil {
+		return KeyPermissions{}, fmt.Errorf("invalid allowed_types: %w", err)
+	}
+	if p.AllowedQueues, err = NormalizeLabels(allowedQueues); err != nil {
+		return KeyPermissions{}, fmt.Errorf("invalid allowed_queues: %w", err)
+	}
+	return p, nil
+}
+func normalizeScopes(scopes []string) ([]string, error) {
+	if scopes == nil {
+		return DefaultScopes, nil
+	}
+	normalized := []string{}
+	for _, scope := range scopes {
+		if !slices.Contains(knownScopes, Scope(scope)) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func (p KeyPermissions) Has(scope Scope) bool {
+	return slices.Contains(p.Scopes, string(scope))
+}
+
+// Allows rejects a job whose type or queue is not on the allowlists.
+func (p KeyPermissions) Allows(jobType, queue string) error {
+	if len(p.AllowedTypes) > 0 && !slices.Contains(p.AllowedTypes, jobType) {
+		return fmt.Errorf("this api key may not submit %s jobs", jobType)
+	}
+	if len(p.AllowedQueues) > 0 && !slices.Contains(p.AllowedQueues, queue) {
+		return fmt.Errorf("this api key may not submit to the %s queue", queue)
+	}
+	return nil
+}
+
+// Covers checks that other grants nothing p does not, so a key with
+// admin:keys cannot hand out more than it has itself.
+func (p KeyPermissions) Covers(other KeyPermissions) error {
+	for _, scope := range other.Scopes {
+		if !p.Has(Scope(scope)) {
+			return fmt.Errorf("cannot grant scope %s", scope)
+		}
+	}
+	if err := coversList(p.AllowedTypes, other.AllowedTypes); err != nil {
+		return fmt.Errorf("allowed_types: %w", err)
+	}
+	if err := coversList(p.AllowedQueues, other.AllowedQueues); err != nil {
+		return fmt.Errorf("allowed_queues: %w", err)
+	}
+	if err := coversCIDRs(p.AllowedCIDRs, other.AllowedCIDRs); err != nil {
+		return fmt.Errorf("allowed_cidrs: %w", err)
+	}
+	return nil
+}
+func coversList(allowed, requested []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	if len(requested) == 0 {
+		return fmt.Errorf("must be limited to %v", allowed)
+	}
+	for _, item := range requested {
+		if !slices.Contains(allowed, item) {
+			return fmt.Errorf("cannot grant %s", item)
+		}
+	}
+	return nil
+}
+
+// KnownScopes keeps the scopes of claimed that this server knows, such as
+// those of a JWT, which may also carry scopes for other services.
+func KnownScopes(claimed []string) []string {
+	scopes := []string{}
+	for _, scope := range claimed {
+		if slices.Contains(knownScopes, Scope(scope)) && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNewKeyPermissions(t *testing.T) {
+	p, err := NewKeyPermissions(nil, nil, nil)
+	if err != nil || !slices.Equal(p.Scopes, DefaultScopes) {
+		t.Fatalf("expected the default scopes, got %+v, %v", p, err)
+	}
+	p, err = NewKeyPermissions([]string{"jobs:write", "admin:keys", "jobs:write"}, []string{"send_email"}, []string{" bulk "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"admin:keys", "jobs:write"}; !slices.Equal(p.Scopes, want) {
+		t.Fatalf("expected scopes %v, got %v", want, p.Scopes)
+	}
+	if !slices.Equal(p.AllowedQueues, []string{"bulk"}) {
+		t.Fatalf("expected the queues to be trimmed, got %v", p.AllowedQueues)
+	}
+	if p, err := NewKeyPermissions([]string{}, nil, nil); err != nil || len(p.Scopes) != 0 {
+		t.Fatalf("expected an empty list to stay empty, got %+v, %v", p, err)
+	}
+	if _, err := NewKeyPermissions([]string{"jobs:delete"}, nil, nil); err == nil {
+		t.Fatal("expected an unknown scope to be rejected")
+	}
+	if _, err := NewKeyPermissions(nil, []string{"send email"}, nil); err == nil {
+		t.Fatal("expected an invalid job type to be rejected")
+	}
+}
+
+func TestKeyPermissions_Allows(t *testing.T) {
+	marketing := KeyPermissions{AllowedTypes: []string{"send_email"}, AllowedQueues: []string{"bulk"}}
+	cases := []struct {
+		p       KeyPermissions
+		jobType string
+		queue   string
+		allowed bool
+	}{
+		{KeyPermissions{}, "resize_image", DefaultQueue, true},
+		{marketing, "send_email", "bulk", true},
+		{marketing, "resize_image", "bulk", false},
+		{marketing, "send_email", DefaultQueue, false},
+		{KeyPermissions{AllowedQueues: []string{"bulk"}}, "resize_image", "bulk", true},
+	}
+	for _, tc := range cases {
+		err := tc.p.Allows(tc.jobType, tc.queue)
+		if (err == nil) != tc.allowed {
+			t.Fatalf("%+v.Allows(%s, %s): got %v, want allowed=%v", tc.p, tc.jobType, tc.queue, err, tc.allowed)
+		}
+	}
+}
+
+func TestKeyPermissions_Covers(t *testing.T) {
+	admin := KeyPermissions{
+		Scopes:       []string{"admin:keys", "jobs:read", "jobs:write"},
+		AllowedTypes: []string{"send_email", "resize_image"},
+	}
+	cases := []struct {
+		key     KeyPermissions
+		covered bool
+	}{
+		{KeyPermissions{Scopes: []string{"jobs:write"}, AllowedTypes: []string{"send_email"}, AllowedQueues: []string{"bulk"}}, true},
+		{KeyPermissions{Scopes: []string{"jobs:cancel"}, AllowedTypes: []string{"send_email"}}, false},
+		{KeyPermissions{Scopes: []string{"jobs:write"}}, false},
+		{KeyPermissions{Scopes: []string{"jobs:write"}, AllowedTypes: []string{"delete_account"}}, false},
+	}
+	for _, tc := range cases {
+		err := admin.Covers(tc.key)
+		if (err == nil) != tc.covered {
+			t.Fatalf("Covers(%+v): got %v, want covered=%v", tc.key, err, tc.covered)
+		}
+	}
+}
+
+func TestKnownScopes(t *testing.T) {
+	got := KnownScopes([]string{"openid", "jobs:write", "profile", "jobs:read", "jobs:write"})
+	if want := []string{"jobs:read", "jobs:write"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got := KnownScopes(nil); len(got) != 0 {
+		t.Fatalf("expected no scopes, got %v", got)
+	}
+}
@@ -2,21 +2,78 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/franzego/distributed_task_queue/authutil"
 	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/franzego/distributed_task_queue/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Queue interface {
-	Enqueue(ctx context.Context, job db.Job) error
+	Enqueue(ctx context.Context, job db.Job, deferUntil time.Time) (db.Job, error)
 	Dequeue(ctx context.Context) (*db.Job, error)
-	GetJob(ctx context.Context, id string) (db.Job, error)
+	GetJob(ctx context.Context, id string, owner Owner) (db.Job, error)
+	CancelJob(ctx context.Context, id string, owner Owner) (db.Job, error)
+	SearchJobs(ctx context.Context, arg db.SearchJobsParams) ([]db.Job, string, error)
 	CreateAPIKey(ctx context.Context, arg db.CreateAPIKeyParams) (db.ApiKey, error)
-	ListAPIKeys(ctx context.Context) ([]db.ApiKey, error)
+	ListAPIKeys(ctx context.Context, organizationID string) ([]db.ApiKey, error)
+	GetAPIKey(ctx context.Context, id string) (db.ApiKey, error)
+	SetAPIKeyScopes(ctx context.Context, id string, permissions KeyPermissions) (db.ApiKey, error)
+	SetAPIKeyAllowedCIDRs(ctx context.Context, id string, allowedCIDRs []string) (db.ApiKey, error)
+	SetAPIKeyDeferOverLimit(ctx context.Context, id string, deferOverLimit bool) (db.ApiKey, error)
+	SetAPIKeyOrganization(ctx context.Context, id, organizationID string) (db.ApiKey, error)
+	AddClientIdentity(ctx context.Context, apiKeyID, identity string) (db.ApiKeyClientIdentity, error)
+	ListClientIdentities(ctx context.Context, apiKeyID string) ([]db.ApiKeyClientIdentity, error)
+	DeleteClientIdentity(ctx context.Context, apiKeyID, id string) error
+	RevokeAPIKey(ctx context.Context, id string) error
+	IssueSigningSecret(ctx context.Context, id string) (db.ApiKey, string, error)
+	RemoveSigningSecret(ctx context.Context, id string) error
+	SetAPIKeyExpiry(ctx context.Context, id string, expiresAt pgtype.Timestamptz) (db.ApiKey, error)
+	UpdateAPIKeyMetadata(ctx context.Context, arg db.UpdateAPIKeyMetadataParams) (db.ApiKey, error)
+	RotateAPIKey(ctx context.Context, id string, overlap time.Duration, expiresAt pgtype.Timestamptz, rotatedBy string) (db.ApiKey, string, error)
+	ListOrganizations(ctx context.Context) ([]db.Organization, error)
+	SetOrganization(ctx context.Context, arg db.UpsertOrganizationParams) (db.Organization, error)
+	DeleteOrganization(ctx context.Context, id string) error
+	ListAdminPrincipals(ctx context.Context) ([]db.AdminPrincipal, error)
+	CreateAdminPrincipal(ctx context.Context, name string, role AdminRole, createdBy string) (db.AdminPrincipal, string, error)
+	UpdateAdminPrincipal(ctx context.Context, arg db.UpdateAdminPrincipalParams) (db.AdminPrincipal, error)
+	CreateAdminToken(ctx context.Context, principalID string, expiresAt pgtype.Timestamptz) (db.AdminToken, string, error)
+	ListAdminTokens(ctx context.Context, principalID string) ([]db.ListAdminTokensRow, error)
+	RevokeAdminToken(ctx context.Context, principalID, tokenID string) error
+	ListAdminActions(ctx context.Context, principal string, limit int32) ([]db.AdminAuditLog, error)
+	Stats(ctx context.Context) (models.JobStats, error)
+	ListJobTypeLimits(ctx context.Context) ([]db.JobTypeLimit, error)
+	SetJobTypeLimit(ctx context.Context, arg db.UpsertJobTypeLimitParams) (db.JobTypeLimit, error)
+	DeleteJobTypeLimit(ctx context.Context, jobType string) error
+	ListCircuitBreakers(ctx context.Context) ([]db.CircuitBreaker, error)
+	ConfigureCircuitBreaker(ctx context.Context, arg db.ConfigureCircuitBreakerParams) (db.CircuitBreaker, error)
+	ResetCircuitBreaker(ctx context.Context, jobType string) (db.CircuitBreaker, error)
+	ListQuarantinedJobs(ctx context.Context, limit, offset int32) ([]db.Job, error)
+	ReleaseQuarantinedJob(ctx context.Context, id string) (db.Job, error)
+	ListTenants(ctx context.Context) ([]db.TenantSchedule, error)
+	SetTenantWeight(ctx context.Context, tenantID string, weight int32) (db.TenantSchedule, error)
+	ListBacklogLimits(ctx context.Context) ([]db.BacklogLimit, error)
+	SetBacklogLimit(ctx context.Context, arg db.UpsertBacklogLimitParams) (db.BacklogLimit, error)
+	DeleteBacklogLimit(ctx context.Context, scope BacklogScope, name string) error
+	ListExecutionWindows(ctx context.Context) ([]db.ExecutionWindow, error)
+	CreateExecutionWindow(ctx context.Context, arg db.CreateExecutionWindowParams) (db.ExecutionWindow, error)
+	DeleteExecutionWindow(ctx context.Context, id int64) (db.ExecutionWindow, error)
+	ListWorkers(ctx context.Context) ([]db.Worker, error)
+	ListRollouts(ctx context.Context) ([]RolloutReport, error)
+	StartRollout(ctx context.Context, arg db.UpsertHandlerRolloutParams) (db.HandlerRollout, error)
+	RollBackRollout(ctx context.Context, jobType, reason string) (db.HandlerRollout, error)
+	DeleteRollout(ctx context.Context, jobType string) error
 }
 
 type Service struct {
 	// Q Queue
-	r *Repository
+	r       *Repository
+	shedder *LoadShedder
 }
 
 func NewService(r *Repository) *Service {
@@ -24,50 +81,583 @@ func NewService(r *Repository) *Service {
 		return nil
 	}
 	return &Service{
-		r: r,
+		r:       r,
+		shedder: NewLoadShedder(r, maxDBLatencyFromEnv()),
 	}
 }
 
 // The enqueue function is the one that actually creates a job in the queue(db).
-func (s *Service) Enqueue(ctx context.Context, job db.Job) error {
+// Jobs with a coalesce key may be folded into an existing pending job, in which
+// case that job is returned instead. While the queue is overloaded the job is
+// rejected with an *OverloadedError. A job submitted outside its execution
+// windows, or deferred by the rate limiter, is scheduled for later.
+// deferUntil is when the rate limiter deferred the job to, zero if it did not.
+func (s *Service) Enqueue(ctx context.Context, job db.Job, deferUntil time.Time) (db.Job, error) {
+	if err := s.shedder.Admit(ctx, job); err != nil {
+		return db.Job{}, err
+	}
+	start := time.Now()
+	defer func() { s.shedder.Observe(time.Since(start)) }()
+	if job.CoalesceKey.Valid {
+		return s.r.EnqueueCoalescedJob(ctx, db.CreateCoalescedJobParams{
+			ID:             job.ID,
+			Type:           job.Type,
+			Payload:        job.Payload,
+			MaxAttempts:    job.MaxAttempts,
+			ExpiresAt:      job.ExpiresAt,
+			GroupKey:       job.GroupKey,
+			ScheduledAt:    job.ScheduledAt,
+			CoalesceKey:    job.CoalesceKey,
+			CoalesceMode:   job.CoalesceMode,
+			CoalesceUntil:  job.CoalesceUntil,
+			ApiKeyID:       job.ApiKeyID,
+			Queue:          job.Queue,
+			Requires:       job.Requires,
+			Tags:           job.Tags,
+			OrganizationID: job.OrganizationID,
+		}, pgtype.Timestamptz{Time: deferUntil, Valid: !deferUntil.IsZero()})
+	}
+	runAt := time.Now()
+	if job.ScheduledAt.Valid && job.ScheduledAt.Time.After(runAt) {
+		runAt = job.ScheduledAt.Time
+	}
+	scheduledAt := job.ScheduledAt
+	openAt, err := s.r.WindowOpensAt(ctx, job.Type, job.Queue, runAt)
+	if err != nil {
+		return db.Job{}, err
+	}
+	if !openAt.IsZero() {
+		scheduledAt = pgtype.Timestamptz{Time: openAt, Valid: true}
+	}
 	arg := db.CreateJobParams{
-		ID:          job.ID,
-		Type:        job.Type,
-		Payload:     job.Payload,
-		Status:      job.Status,
-		MaxAttempts: job.MaxAttempts,
+		ID:             job.ID,
+		Type:           job.Type,
+		Payload:        job.Payload,
+		Status:         job.Status,
+		MaxAttempts:    job.MaxAttempts,
+		ExpiresAt:      job.ExpiresAt,
+		GroupKey:       job.GroupKey,
+		ApiKeyID:       job.ApiKeyID,
+		Queue:          job.Queue,
+		ScheduledAt:    scheduledAt,
+		WindowHeld:     !openAt.IsZero(),
+		Requires:       job.Requires,
+		Tags:           job.Tags,
+		OrganizationID: job.OrganizationID,
 	}
-	_, err := s.r.CreateJob(ctx, arg)
+	created, err := s.r.CreateJob(ctx, arg)
 	if err != nil {
-		return err
+		return db.Job{}, err
 	}
-	return nil
+	return created, nil
 }
 func (s *Service) Dequeue(ctx context.Context) (*db.Job, error) {
-	job, err := s.r.DequeueJob(ctx)
+	job, err := s.r.DequeueJob(ctx, DefaultDequeueOptions())
 	if err != nil {
 		return nil, err
 	}
 	return &job, err
 }
-func (s *Service) GetJob(ctx context.Context, id string) (db.Job, error) {
+
+// GetJob returns ErrJobNotFound for a job owner cannot see.
+func (s *Service) GetJob(ctx context.Context, id string, owner Owner) (db.Job, error) {
 	job, err := s.r.GetJob(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Job{}, ErrJobNotFound
+	}
+	if err != nil {
+		return db.Job{}, err
+	}
+	if !owner.Owns(job) {
+		return db.Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+func (s *Service) CancelJob(ctx context.Context, id string, owner Owner) (db.Job, error) {
+	job, err := s.r.CancelJob(ctx, id, owner, "cancelled by request")
 	if err != nil {
 		return db.Job{}, err
 	}
 	return job, nil
 }
+
+// SearchJobs returns one page of jobs and the cursor of the next page, which
+// is empty on the last page.
+func (s *Service) SearchJobs(ctx context.Context, arg db.SearchJobsParams) ([]db.Job, string, error) {
+	pageSize := int(arg.PageSize)
+	// one extra job tells whether there is another page
+	arg.PageSize++
+	jobs, err := s.r.SearchJobs(ctx, arg)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(jobs) <= pageSize {
+		return jobs, "", nil
+	}
+	jobs = jobs[:pageSize]
+	return jobs, encodeJobCursor(jobs[pageSize-1]), nil
+}
+
+// CreateAPIKey returns ErrOrganizationNotFound if the key is given an
+// organization that does not exist.
 func (s *Service) CreateAPIKey(ctx context.Context, arg db.CreateAPIKeyParams) (db.ApiKey, error) {
+	if arg.OrganizationID.Valid {
+		if _, err := s.r.GetOrganization(ctx, arg.OrganizationID.String); err != nil {
+			return db.ApiKey{}, err
+		}
+	}
 	key, err := s.r.CreateAPIKey(ctx, arg)
 	if err != nil {
 		return db.ApiKey{}, err
 	}
 	return key, nil
 }
-func (s *Service) ListAPIKeys(ctx context.Context) ([]db.ApiKey, error) {
-	keys, err := s.r.ListAPIKeys(ctx)
+
+// ListAPIKeys lists the keys of an organization, or every key if
+// organizationID is empty.
+func (s *Service) ListAPIKeys(ctx context.Context, organizationID string) ([]db.ApiKey, error) {
+	keys, err := s.r.ListAPIKeys(ctx, optionalText(organizationID))
 	if err != nil {
 		return nil, err
 	}
 	return keys, nil
 }
+
+func (s *Service) SetAPIKeyDeferOverLimit(ctx context.Context, id string, deferOverLimit bool) (db.ApiKey, error) {
+	key, err := s.r.SetAPIKeyDeferOverLimit(ctx, db.SetAPIKeyDeferOverLimitParams{
+		ID:             id,
+		DeferOverLimit: deferOverLimit,
+	})
+	if err != nil {
+		return db.ApiKey{}, err
+	}
+	return key, nil
+}
+
+func (s *Service) GetAPIKey(ctx context.Context, id string) (db.ApiKey, error) {
+	return s.r.GetAPIKey(ctx, id)
+}
+func (s *Service) SetAPIKeyScopes(ctx context.Context, id string, permissions KeyPermissions) (db.ApiKey, error) {
+	key, err := s.r.SetAPIKeyScopes(ctx, db.SetAPIKeyScopesParams{
+		ID:            id,
+		Scopes:        permissions.Scopes,
+		AllowedTypes:  permissions.AllowedTypes,
+		AllowedQueues: permissions.AllowedQueues,
+	})
+	if err != nil {
+		return db.ApiKey{}, err
+	}
+	return key, nil
+}
+func (s *Service) SetAPIKeyAllowedCIDRs(ctx context.Context, id string, allowedCIDRs []string) (db.ApiKey, error) {
+	return s.r.SetAPIKeyAllowedCIDRs(ctx, db.SetAPIKeyAllowedCIDRsParams{
+		ID:           id,
+		AllowedCidrs: allowedCIDRs,
+	})
+}
+func (s *Service) RevokeAPIKey(ctx context.Context, id string) error {
+	return s.r.RevokeAPIKey(ctx, id)
+}
+
+// IssueSigningSecret gives key id a new secret to sign requests with,
+// replacing any it had, and returns it. The secret is only shown this once.
+func (s *Service) IssueSigningSecret(ctx context.Context, id string) (db.ApiKey, string, error) {
+	secret, err := authutil.KeyGenerator(signingSecretPrefix)
+	if err != nil {
+		return db.ApiKey{}, "", fmt.Errorf("could not generate signing secret: %w", err)
+	}
+	key, err := s.r.SetAPIKeySigningSecret(ctx, db.SetAPIKeySigningSecretParams{
+		ID:            id,
+		SigningSecret: pgtype.Text{String: secret, Valid: true},
+	})
+	if err != nil {
+		return db.ApiKey{}, "", err
+	}
+	return key, secret, nil
+}
+
+// RemoveSigningSecret stops key id from accepting signed requests.
+func (s *Service) RemoveSigningSecret(ctx context.Context, id string) error {
+	_, err := s.r.SetAPIKeySigningSecret(ctx, db.SetAPIKeySigningSecretParams{ID: id})
+	return err
+}
+
+// SetAPIKeyExpiry sets, extends or, with an invalid expiresAt, clears the
+// expiry of an active key.
+func (s *Service) SetAPIKeyExpiry(ctx context.Context, id string, expiresAt pgtype.Timestamptz) (db.ApiKey, error) {
+	key, err := s.r.SetAPIKeyExpiry(ctx, db.SetAPIKeyExpiryParams{
+		ID:        id,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return db.ApiKey{}, err
+	}
+	return key, nil
+}
+func (s *Service) UpdateAPIKeyMetadata(ctx context.Context, arg db.UpdateAPIKeyMetadataParams) (db.ApiKey, error) {
+	key, err := s.r.UpdateAPIKeyMetadata(ctx, arg)
+	if err != nil {
+		return db.ApiKey{}, err
+	}
+	return key, nil
+}
+
+// RotateAPIKey issues a new key with the prefix and settings of key id and
+// keeps key id working for overlap, so both work while clients switch over.
+// It returns the new key and its plaintext, which is not stored.
+func (s *Service) RotateAPIKey(ctx context.Context, id string, overlap time.Duration, expiresAt pgtype.Timestamptz, rotatedBy string) (db.ApiKey, string, error) {
+	old, err := s.r.GetAPIKey(ctx, id)
+	if err != nil {
+		return db.ApiKey{}, "", err
+	}
+	prefix := authutil.PrefixOf(old.KeyPrefix)
+	if prefix == "" {
+		prefix = legacyKeyPrefix
+	}
+	plaintext, err := authutil.KeyGenerator(prefix)
+	if err != nil {
+		return db.ApiKey{}, "", fmt.Errorf("could not generate api key: %w", err)
+	}
+	key, err := s.r.RotateAPIKey(ctx, id, db.CreateAPIKeyParams{
+		ID:        uuid.New().String(),
+		KeyHash:   authutil.HashApiKeys(plaintext),
+		KeyPrefix: authutil.KeyPrefix(plaintext),
+		CreatedBy: pgtype.Text{String: rotatedBy, Valid: rotatedBy != ""},
+		ExpiresAt: expiresAt,
+	}, time.Now().Add(overlap))
+	if err != nil {
+		return db.ApiKey{}, "", err
+	}
+	return key, plaintext, nil
+}
+
+// AddClientIdentity lets client certificates with identity authenticate as
+// key apiKeyID.
+func (s *Service) AddClientIdentity(ctx context.Context, apiKeyID, identity string) (db.ApiKeyClientIdentity, error) {
+	if _, err := s.r.GetAPIKey(ctx, apiKeyID); err != nil {
+		return db.ApiKeyClientIdentity{}, err
+	}
+	return s.r.AddClientIdentity(ctx, db.AddClientIdentityParams{
+		ID:       uuid.New().String(),
+		ApiKeyID: apiKeyID,
+		Identity: identity,
+	})
+}
+func (s *Service) ListClientIdentities(ctx context.Context, apiKeyID string) ([]db.ApiKeyClientIdentity, error) {
+	if _, err := s.r.GetAPIKey(ctx, apiKeyID); err != nil {
+		return nil, err
+	}
+	return s.r.ListClientIdentities(ctx, apiKeyID)
+}
+func (s *Service) DeleteClientIdentity(ctx context.Context, apiKeyID, id string) error {
+	return s.r.DeleteClientIdentity(ctx, apiKeyID, id)
+}
+
+// SetAPIKeyOrganization moves a key to another organization, or out of its
+// organization if organizationID is empty. Jobs the key already submitted
+// stay with the organization they were submitted under, and those submitted
+// outside any organization join the new one.
+func (s *Service) SetAPIKeyOrganization(ctx context.Context, id, organizationID string) (db.ApiKey, error) {
+	if organizationID != "" {
+		if _, err := s.r.GetOrganization(ctx, organizationID); err != nil {
+			return db.ApiKey{}, err
+		}
+	}
+	key, err := s.r.SetAPIKeyOrganization(ctx, db.SetAPIKeyOrganizationParams{
+		ID:             id,
+		OrganizationID: pgtype.Text{String: organizationID, Valid: organizationID != ""},
+	})
+	if err != nil {
+		return db.ApiKey{}, err
+	}
+	return key, nil
+}
+func (s *Service) ListOrganizations(ctx context.Context) ([]db.Organization, error) {
+	orgs, err := s.r.ListOrganizations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+func (s *Service) SetOrganization(ctx context.Context, arg db.UpsertOrganizationParams) (db.Organization, error) {
+	org, err := s.r.UpsertOrganization(ctx, arg)
+	if err != nil {
+		return db.Organization{}, err
+	}
+	return org, nil
+}
+func (s *Service) DeleteOrganization(ctx context.Context, id string) error {
+	return s.r.DeleteOrganization(ctx, id)
+}
+
+// Stats reports job counts per status. ExpiredLastHour going up while pending
+// grows usually means workers cannot keep up.
+func (s *Service) Stats(ctx context.Context) (models.JobStats, error) {
+	counts, expired, err := s.r.JobStats(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		return models.JobStats{}, err
+	}
+	return models.JobStats{
+		ByStatus:        counts,
+		ExpiredLastHour: expired,
+	}, nil
+}
+func (s *Service) ListJobTypeLimits(ctx context.Context) ([]db.JobTypeLimit, error) {
+	limits, err := s.r.ListJobTypeLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+func (s *Service) SetJobTypeLimit(ctx context.Context, arg db.UpsertJobTypeLimitParams) (db.JobTypeLimit, error) {
+	limit, err := s.r.UpsertJobTypeLimit(ctx, arg)
+	if err != nil {
+		return db.JobTypeLimit{}, err
+	}
+	return limit, nil
+}
+func (s *Service) DeleteJobTypeLimit(ctx context.Context, jobType string) error {
+	return s.r.DeleteJobTypeLimit(ctx, jobType)
+}
+func (s *Service) ListCircuitBreakers(ctx context.Context) ([]db.CircuitBreaker, error) {
+	breakers, err := s.r.ListCircuitBreakers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return breakers, nil
+}
+func (s *Service) ConfigureCircuitBreaker(ctx context.Context, arg db.ConfigureCircuitBreakerParams) (db.CircuitBreaker, error) {
+	breaker, err := s.r.ConfigureCircuitBreaker(ctx, arg)
+	if err != nil {
+		return db.CircuitBreaker{}, err
+	}
+	return breaker, nil
+}
+func (s *Service) ResetCircuitBreaker(ctx context.Context, jobType string) (db.CircuitBreaker, error) {
+	breaker, err := s.r.ResetCircuitBreaker(ctx, jobType)
+	if err != nil {
+		return db.CircuitBreaker{}, err
+	}
+	return breaker, nil
+}
+func (s *Service) ListQuarantinedJobs(ctx context.Context, limit, offset int32) ([]db.Job, error) {
+	jobs, err := s.r.ListJobs(ctx, db.ListJobsParams{
+		Status: string(StatusQuarantined),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+func (s *Service) ReleaseQuarantinedJob(ctx context.Context, id string) (db.Job, error) {
+	job, err := s.r.ReleaseQuarantinedJob(ctx, id)
+	if err != nil {
+		return db.Job{}, err
+	}
+	return job, nil
+}
+func (s *Service) ListTenants(ctx context.Context) ([]db.TenantSchedule, error) {
+	tenants, err := s.r.ListTenantSchedule(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+func (s *Service) SetTenantWeight(ctx context.Context, tenantID string, weight int32) (db.TenantSchedule, error) {
+	tenant, err := s.r.SetTenantWeight(ctx, db.SetTenantWeightParams{
+		TenantID: tenantID,
+		Weight:   weight,
+	})
+	if err != nil {
+		return db.TenantSchedule{}, err
+	}
+	return tenant, nil
+}
+func (s *Service) ListBacklogLimits(ctx context.Context) ([]db.BacklogLimit, error) {
+	limits, err := s.r.ListBacklogLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+func (s *Service) SetBacklogLimit(ctx context.Context, arg db.UpsertBacklogLimitParams) (db.BacklogLimit, error) {
+	limit, err := s.r.UpsertBacklogLimit(ctx, arg)
+	if err != nil {
+		return db.BacklogLimit{}, err
+	}
+	return limit, nil
+}
+func (s *Service) DeleteBacklogLimit(ctx context.Context, scope BacklogScope, name string) error {
+	return s.r.DeleteBacklogLimit(ctx, db.DeleteBacklogLimitParams{
+		Scope: string(scope),
+		Name:  name,
+	})
+}
+func (s *Service) ListExecutionWindows(ctx context.Context) ([]db.ExecutionWindow, error) {
+	windows, err := s.r.ListExecutionWindows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return windows, nil
+}
+
+// CreateExecutionWindow adds a window and moves jobs already held for the same
+// job type or queue to their next opening.
+func (s *Service) CreateExecutionWindow(ctx context.Context, arg db.CreateExecutionWindowParams) (db.ExecutionWindow, error) {
+	window, err := s.r.CreateExecutionWindow(ctx, arg)
+	if err != nil {
+		return db.ExecutionWindow{}, err
+	}
+	if err := s.r.RescheduleWindowHeldJobs(ctx, window.Scope, window.Name); err != nil {
+		return db.ExecutionWindow{}, err
+	}
+	return window, nil
+}
+func (s *Service) DeleteExecutionWindow(ctx context.Context, id int64) (db.ExecutionWindow, error) {
+	window, err := s.r.DeleteExecutionWindow(ctx, id)
+	if err != nil {
+		return db.ExecutionWindow{}, err
+	}
+	if err := s.r.RescheduleWindowHeldJobs(ctx, window.Scope, window.Name); err != nil {
+		return db.ExecutionWindow{}, err
+	}
+	return window, nil
+}
+func (s *Service) ListWorkers(ctx context.Context) ([]db.Worker, error) {
+	workers, err := s.r.ListLiveWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
+
+// ListRollouts reports every rollout with the stats of its versions, so their
+// error rates and latencies can be compared side by side.
+func (s *Service) ListRollouts(ctx context.Context) ([]RolloutReport, error) {
+	rollouts, err := s.r.ListHandlerRollouts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.r.ListHandlerVersionStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]RolloutReport, 0, len(rollouts))
+	for _, rollout := range rollouts {
+		report := RolloutReport{HandlerRollout: rollout, Versions: []VersionReport{}}
+		for _, stat := range stats {
+			if stat.JobType == rollout.JobType {
+				report.Versions = append(report.Versions, versionReport(stat))
+			}
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+func (s *Service) StartRollout(ctx context.Context, arg db.UpsertHandlerRolloutParams) (db.HandlerRollout, error) {
+	rollout, err := s.r.StartHandlerRollout(ctx, arg)
+	if err != nil {
+		return db.HandlerRollout{}, err
+	}
+	return rollout, nil
+}
+func (s *Service) RollBackRollout(ctx context.Context, jobType, reason string) (db.HandlerRollout, error) {
+	rollout, err := s.r.RollBackHandlerRollout(ctx, db.RollBackHandlerRolloutParams{
+		JobType: jobType,
+		Reason:  pgtype.Text{String: reason, Valid: reason != ""},
+	})
+	if err != nil {
+		return db.HandlerRollout{}, err
+	}
+	return rollout, nil
+}
+func (s *Service) DeleteRollout(ctx context.Context, jobType string) error {
+	return s.r.DeleteHandlerRollout(ctx, jobType)
+}
+func (s *Service) ListAdminPrincipals(ctx context.Context) ([]db.AdminPrincipal, error) {
+	principals, err := s.r.ListAdminPrincipals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return principals, nil
+}
+
+// CreateAdminPrincipal creates a principal and its first token, and returns
+// the token's plaintext, which is not stored.
+func (s *Service) CreateAdminPrincipal(ctx context.Context, name string, role AdminRole, createdBy string) (db.AdminPrincipal, string, error) {
+	token, plaintext, err := newAdminToken("")
+	if err != nil {
+		return db.AdminPrincipal{}, "", err
+	}
+	principal, err := s.r.CreateAdminPrincipal(ctx, db.CreateAdminPrincipalParams{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Role:      string(role),
+		CreatedBy: optionalText(createdBy),
+	}, token)
+	if err != nil {
+		return db.AdminPrincipal{}, "", err
+	}
+	return principal, plaintext, nil
+}
+func (s *Service) UpdateAdminPrincipal(ctx context.Context, arg db.UpdateAdminPrincipalParams) (db.AdminPrincipal, error) {
+	principal, err := s.r.UpdateAdminPrincipal(ctx, arg)
+	if err != nil {
+		return db.AdminPrincipal{}, err
+	}
+	return principal, nil
+}
+
+// CreateAdminToken adds a token to a principal. Its other tokens keep
+// working until they are revoked, so clients can switch over first.
+func (s *Service) CreateAdminToken(ctx context.Context, principalID string, expiresAt pgtype.Timestamptz) (db.AdminToken, string, error) {
+	arg, plaintext, err := newAdminToken(principalID)
+	if err != nil {
+		return db.AdminToken{}, "", err
+	}
+	arg.ExpiresAt = expiresAt
+	token, err := s.r.CreateAdminToken(ctx, arg)
+	if err != nil {
+		return db.AdminToken{}, "", err
+	}
+	return token, plaintext, nil
+}
+func (s *Service) ListAdminTokens(ctx context.Context, principalID string) ([]db.ListAdminTokensRow, error) {
+	tokens, err := s.r.ListAdminTokens(ctx, principalID)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+func (s *Service) RevokeAdminToken(ctx context.Context, principalID, tokenID string) error {
+	return s.r.RevokeAdminToken(ctx, db.RevokeAdminTokenParams{
+		ID:          tokenID,
+		PrincipalID: principalID,
+	})
+}
+func (s *Service) ListAdminActions(ctx context.Context, principal string, limit int32) ([]db.AdminAuditLog, error) {
+	actions, err := s.r.ListAdminActions(ctx, db.ListAdminActionsParams{
+		Principal: optionalText(principal),
+		PageSize:  limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// newAdminToken generates an admin token. Only its hash and prefix are kept.
+func newAdminToken(principalID string) (db.CreateAdminTokenParams, string, error) {
+	plaintext, err := authutil.KeyGenerator(adminTokenPrefix)
+	if err != nil {
+		return db.CreateAdminTokenParams{}, "", fmt.Errorf("could not generate admin token: %w", err)
+	}
+	return db.CreateAdminTokenParams{
+		ID:          uuid.New().String(),
+		PrincipalID: principalID,
+		TokenHash:   authutil.HashApiKeys(plaintext),
+		TokenPrefix: authutil.KeyPrefix(plaintext),
+	}, plaintext, nil
+}
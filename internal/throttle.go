@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/franzego/distributed_task_queue/internal/ratelimit"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// concurrencyRetryDelay is how long a job that hit its type's concurrency cap
+// waits before it becomes eligible again.
+const concurrencyRetryDelay = 2 * time.Second
+
+// ErrJobThrottled is returned by DequeueJob when the claimed job was put back
+// because its type is over its cluster-wide limits, its circuit breaker is
+// not closed or it is outside its execution windows. Its attempts are
+// untouched.
+var ErrJobThrottled = errors.New("job type is throttled")
+
+// ThrottledError is the ErrJobThrottled returned by DequeueJob. Workers should
+// leave JobType out of their claims until Until, rather than claim and put
+// back its jobs one after another.
+type ThrottledError struct {
+	JobType string
+	// Until is zero when only the claimed job was held back, e.g. for the
+	// execution window of its queue.
+	Until  time.Time
+	reason string
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%v: %s %s", ErrJobThrottled, e.JobType, e.reason)
+}
+func (e *ThrottledError) Unwrap() error {
+	return ErrJobThrottled
+}
+
+// admitJob checks the claimed job against its type's limits while holding the
+// limit row lock, so every worker sees the same slots and tokens, and then
+// against its circuit breaker. It returns how long the job should be
+// deferred, zero if it may run now.
+func admitJob(ctx context.Context, q *db.Queries, job db.Job) (time.Duration, error) {
+	delay, err := admitLimits(ctx, q, job)
+	if err != nil || delay > 0 {
+		return delay, err
+	}
+	// a type without limits may still have a breaker
+	return admitBreaker(ctx, q, job)
+}
+
+// admitLimits runs inside the dequeue transaction, see admitJob.
+func admitLimits(ctx context.Context, q *db.Queries, job db.Job) (time.Duration, error) {
+	limit, err := q.GetJobTypeLimitForUpdate(ctx, job.Type)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not get limits for job type %s: %w", job.Type, err)
+	}
+	if limit.MaxConcurrent.Valid {
+		running, err := q.CountRunningJobsOfType(ctx, db.CountRunningJobsOfTypeParams{
+			Type: job.Type,
+			ID:   job.ID,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("could not count running %s jobs: %w", job.Type, err)
+		}
+		if running >= int64(limit.MaxConcurrent.Int32) {
+			return concurrencyRetryDelay, nil
+		}
+	}
+	if limit.RatePerSecond.Valid {
+		bucket := &ratelimit.TokenBucket{
+			Tokens:         limit.Tokens,
+			Capacity:       int(limit.Burst),
+			LastRefillTime: limit.RefilledAt.Time,
+			RefillRate:     limit.RatePerSecond.Float64,
+		}
+		if !bucket.TryConsume() {
+			return max(bucket.Wait(), time.Millisecond), nil
+		}
+		err := q.UpdateJobTypeTokens(ctx, db.UpdateJobTypeTokensParams{
+			JobType:    job.Type,
+			Tokens:     bucket.Tokens,
+			RefilledAt: pgtype.Timestamptz{Time: bucket.LastRefillTime, Valid: true},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("could not update tokens for job type %s: %w", job.Type, err)
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// admitDB serves the queries of admitJob for a job type without a
+// job_type_limits row.
+type admitDB struct {
+	breaker  *db.CircuitBreaker
+	executed []string
+}
+
+func (d *admitDB) Exec(_ context.Context, sql string, _ ...interface{}) (pgconn.CommandTag, error) {
+	d.executed = append(d.executed, queryName(sql))
+	return pgconn.CommandTag{}, nil
+}
+func (d *admitDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return nil, pgx.ErrNoRows
+}
+func (d *admitDB) QueryRow(_ context.Context, sql string, _ ...interface{}) pgx.Row {
+	if queryName(sql) == "GetCircuitBreakerForUpdate" && d.breaker != nil {
+		b := *d.breaker
+		return fakeRow{values: []any{
+			b.JobType, b.State, b.Failures, b.Successes, b.WindowStartedAt, b.OpenedAt,
+			b.OpenUntil, b.ProbeUntil, b.FailureRateThreshold, b.MinRequests,
+			b.WindowSeconds, b.OpenSeconds, b.UpdatedAt,
+		}}
+	}
+	return fakeRow{}
+}
+
+type fakeRow struct {
+	values []any
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.values == nil {
+		return pgx.ErrNoRows
+	}
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r.values[i]))
+	}
+	return nil
+}
+func queryName(sql string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(sql, "-- name: "), " ")
+	return name
+}
+
+func TestAdmitJob_BreakerWithoutLimits(t *testing.T) {
+	now := time.Now()
+	open := openBreaker(newTestBreaker(now), now)
+	cooled := openBreaker(newTestBreaker(now), now.Add(-time.Minute))
+
+	tests := []struct {
+		name      string
+		breaker   *db.CircuitBreaker
+		wantDefer bool
+		wantExec  []string
+	}{
+		{"no breaker", nil, false, nil},
+		{"open breaker defers", &open, true, nil},
+		{"cooled breaker lets a probe through", &cooled, false, []string{"UpdateCircuitBreaker"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &admitDB{breaker: tt.breaker}
+			job := db.Job{
+				Type:           "send_email",
+				LeaseExpiresAt: pgtype.Timestamptz{Time: now.Add(time.Minute), Valid: true},
+			}
+			delay, err := admitJob(context.Background(), db.New(d), job)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if (delay > 0) != tt.wantDefer {
+				t.Fatalf("expected deferral %v, got delay %s", tt.wantDefer, delay)
+			}
+			if !reflect.DeepEqual(d.executed, tt.wantExec) {
+				t.Fatalf("expected statements %v, got %v", tt.wantExec, d.executed)
+			}
+		})
+	}
+}
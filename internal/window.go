@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	// execution windows name IANA timezones, which may be missing on the host
+	_ "time/tzdata"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+)
+
+// WindowKind is the kind stored in execution_windows.kind.
+type WindowKind string
+
+const (
+	WindowAllow    WindowKind = "allow"
+	WindowBlackout WindowKind = "blackout"
+)
+
+// WindowScope is the scope stored in execution_windows.scope.
+type WindowScope string
+
+const (
+	WindowScopeType  WindowScope = "type"
+	WindowScopeQueue WindowScope = "queue"
+)
+
+var ErrExecutionWindowNotFound = errors.New("execution window not found")
+
+// maxWindowSearch bounds how far ahead nextRunTime looks for an opening.
+const maxWindowSearch = 366 * 24 * time.Hour
+
+// nextRunTime returns the earliest time at or after t at which a job governed
+// by windows may run: inside one of the allow windows, if there are any, and
+// outside every blackout.
+func nextRunTime(windows []db.ExecutionWindow, t time.Time) time.Time {
+	var allow, blackouts []db.ExecutionWindow
+	for _, w := range windows {
+		switch WindowKind(w.Kind) {
+		case WindowAllow:
+			allow = append(allow, w)
+		case WindowBlackout:
+			blackouts = append(blackouts, w)
+		}
+	}
+	limit := t.Add(maxWindowSearch)
+	for t.Before(limit) {
+		if end, ok := blackoutEnd(blackouts, t); ok {
+			t = end
+			continue
+		}
+		if len(allow) == 0 {
+			return t
+		}
+		next := limit
+		for _, w := range allow {
+			if open := nextOpening(w, t, limit); open.Before(next) {
+				next = open
+			}
+		}
+		if next.Equal(t) {
+			return t
+		}
+		t = next
+	}
+	return limit
+}
+
+// blackoutEnd reports whether t falls in a blackout and, if so, when the
+// blackout ends.
+func blackoutEnd(blackouts []db.ExecutionWindow, t time.Time) (time.Time, bool) {
+	for _, b := range blackouts {
+		if !t.Before(b.StartsAt.Time) && t.Before(b.EndsAt.Time) {
+			return b.EndsAt.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextOpening returns t if t is inside the weekly window w, otherwise the
+// next time w opens, or limit if it never does.
+func nextOpening(w db.ExecutionWindow, t, limit time.Time) time.Time {
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start := time.Duration(w.StartTime.Microseconds) * time.Microsecond
+	end := time.Duration(w.EndTime.Microseconds) * time.Microsecond
+	local := t.In(loc)
+	// start a day early for a window that opened yesterday and runs past midnight
+	for d := -1; d <= 7; d++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+d, 0, 0, 0, 0, loc)
+		if !onWeekday(w.Weekdays, day.Weekday()) {
+			continue
+		}
+		open := atClock(day, start)
+		close := atClock(day, end)
+		if end <= start {
+			close = atClock(day.AddDate(0, 0, 1), end)
+		}
+		if !t.Before(open) && t.Before(close) {
+			return t
+		}
+		if !open.Before(t) {
+			return open
+		}
+	}
+	return limit
+}
+
+func onWeekday(weekdays []int32, day time.Weekday) bool {
+	if len(weekdays) == 0 {
+		return true
+	}
+	for _, d := range weekdays {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+	return false
+}
+
+// atClock is day at the wall clock time of day, so DST changes are honoured.
+func atClock(day time.Time, clock time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(),
+		int(clock/time.Hour), int(clock%time.Hour/time.Minute), int(clock%time.Minute/time.Second), 0, day.Location())
+}
+
+// windowOpensAt returns when a job of jobType in queue may next run, or the
+// zero time if it may run at now.
+func windowOpensAt(ctx context.Context, q *db.Queries, jobType, queue string, now time.Time) (time.Time, error) {
+	windows, err := q.ListExecutionWindowsForJob(ctx, db.ListExecutionWindowsForJobParams{
+		JobType: jobType,
+		Queue:   queue,
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not list execution windows for %s: %w", jobType, err)
+	}
+	if next := nextRunTime(windows, now); next.After(now) {
+		return next, nil
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func clock(hour, minute int) pgtype.Time {
+	return pgtype.Time{Microseconds: int64(hour*60+minute) * int64(time.Minute/time.Microsecond), Valid: true}
+}
+func businessHours(timezone string) db.ExecutionWindow {
+	return db.ExecutionWindow{
+		Kind:      string(WindowAllow),
+		Timezone:  timezone,
+		Weekdays:  []int32{1, 2, 3, 4, 5},
+		StartTime: clock(9, 0),
+		EndTime:   clock(18, 0),
+	}
+}
+func TestNextRunTime_BusinessHours(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows := []db.ExecutionWindow{businessHours("Europe/Berlin")}
+	tests := []struct {
+		name string
+		at   time.Time
+		want time.Time
+	}{
+		{
+			name: "inside the window",
+			at:   time.Date(2024, 3, 6, 10, 30, 0, 0, berlin),
+			want: time.Date(2024, 3, 6, 10, 30, 0, 0, berlin),
+		},
+		{
+			name: "before opening",
+			at:   time.Date(2024, 3, 6, 7, 0, 0, 0, berlin),
+			want: time.Date(2024, 3, 6, 9, 0, 0, 0, berlin),
+		},
+		{
+			name: "after closing",
+			at:   time.Date(2024, 3, 6, 18, 0, 0, 0, berlin),
+			want: time.Date(2024, 3, 7, 9, 0, 0, 0, berlin),
+		},
+		{
+			name: "friday evening waits for monday",
+			at:   time.Date(2024, 3, 8, 19, 0, 0, 0, berlin),
+			want: time.Date(2024, 3, 11, 9, 0, 0, 0, berlin),
+		},
+		{
+			name: "given in another timezone",
+			at:   time.Date(2024, 3, 6, 6, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 3, 6, 9, 0, 0, 0, berlin),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextRunTime(windows, tt.at); !got.Equal(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+func TestNextRunTime_Overnight(t *testing.T) {
+	windows := []db.ExecutionWindow{{
+		Kind:      string(WindowAllow),
+		Timezone:  "UTC",
+		StartTime: clock(22, 0),
+		EndTime:   clock(6, 0),
+	}}
+	late := time.Date(2024, 3, 6, 2, 0, 0, 0, time.UTC)
+	if got := nextRunTime(windows, late); !got.Equal(late) {
+		t.Fatalf("expected a window opened yesterday to still be open, got %v", got)
+	}
+	noon := time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC)
+	if got, want := nextRunTime(windows, noon), time.Date(2024, 3, 6, 22, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+func TestNextRunTime_Blackout(t *testing.T) {
+	start := time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	blackout := db.ExecutionWindow{
+		Kind:     string(WindowBlackout),
+		StartsAt: pgtype.Timestamptz{Time: start, Valid: true},
+		EndsAt:   pgtype.Timestamptz{Time: start.Add(2 * time.Hour), Valid: true},
+	}
+	at := start.Add(30 * time.Minute)
+	if got := nextRunTime([]db.ExecutionWindow{blackout}, at); !got.Equal(start.Add(2 * time.Hour)) {
+		t.Fatalf("expected the job to wait out the blackout, got %v", got)
+	}
+	// a blackout reaching past closing time pushes the job to the next day
+	windows := []db.ExecutionWindow{businessHours("UTC"), {
+		Kind:     string(WindowBlackout),
+		StartsAt: pgtype.Timestamptz{Time: start, Valid: true},
+		EndsAt:   pgtype.Timestamptz{Time: start.Add(10 * time.Hour), Valid: true},
+	}}
+	if got, want := nextRunTime(windows, at), time.Date(2024, 3, 7, 9, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+func TestNextRunTime_NoWindows(t *testing.T) {
+	now := time.Now()
+	if got := nextRunTime(nil, now); !got.Equal(now) {
+		t.Fatalf("expected a job without windows to run now, got %v", got)
+	}
+}
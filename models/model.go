@@ -1,10 +1,36 @@
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 type JobRequest struct {
-	Type    string          `json:"type"`
-	Payload json.RawMessage `json:"payload"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	ExpiresAt *time.Time      `json:"expires_at"`
+	// GroupKey serialises jobs that share it: they run one at a time, in
+	// the order they were submitted.
+	GroupKey string           `json:"group_key"`
+	Coalesce *CoalesceOptions `json:"coalesce"`
+	// Queue defaults to "default".
+	Queue string `json:"queue"`
+	// Requires lists the labels a worker must have to run the job.
+	Requires []string `json:"requires"`
+	// Tags are free-form labels to find the job by in GET /jobs.
+	Tags []string `json:"tags"`
+}
+
+// CoalesceOptions debounces submissions that share Key. They collapse into a
+// single pending job that runs DelaySeconds after the latest submission, but
+// no later than WindowSeconds after the first one.
+type CoalesceOptions struct {
+	Key           string `json:"key"`
+	DelaySeconds  int    `json:"delay_seconds"`
+	WindowSeconds int    `json:"window_seconds"`
+	// Mode is "replace" (keep the latest payload, the default) or "merge"
+	// (shallow-merge each payload into the pending one).
+	Mode string `json:"mode"`
 }
 type ErrorResponse struct {
 	Message string `json:"message"`
@@ -13,4 +39,158 @@ type ErrorResponse struct {
 type SuccessMessage struct {
 	Message string `json:"message"`
 	ID      string `json:"id"`
+	// ScheduledAt is set when the job was accepted for a later time.
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+}
+
+// RateLimitModeRequest switches an API key between rejecting over-limit
+// POST /jobs requests with 429 and accepting them for a later time.
+type RateLimitModeRequest struct {
+	DeferOverLimit bool `json:"defer_over_limit"`
+}
+
+// APIKeyExpiryRequest sets or extends the expiry of an API key. A nil
+// ExpiresAt makes the key never expire.
+type APIKeyExpiryRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyMetadataRequest edits an API key. Fields left nil are kept.
+type APIKeyMetadataRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// APIKeyScopesRequest replaces the permissions of an API key. Nil Scopes
+// restore the default scopes; empty allowlists allow every job type or queue.
+type APIKeyScopesRequest struct {
+	Scopes        []string `json:"scopes"`
+	AllowedTypes  []string `json:"allowed_types"`
+	AllowedQueues []string `json:"allowed_queues"`
+}
+
+// APIKeyAllowedCIDRsRequest replaces the networks an API key may be used
+// from. An empty list allows all.
+type APIKeyAllowedCIDRsRequest struct {
+	AllowedCIDRs []string `json:"allowed_cidrs"`
+}
+
+// ClientIdentityRequest maps a client certificate identity, written
+// kind:value, to an API key.
+type ClientIdentityRequest struct {
+	Identity string `json:"identity" binding:"required"`
+}
+
+// RotateAPIKeyRequest replaces an API key. The old key keeps working for
+// OverlapSeconds, 24 hours if nil, and the new one expires at ExpiresAt, if
+// set.
+type RotateAPIKeyRequest struct {
+	OverlapSeconds *int64     `json:"overlap_seconds"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+}
+
+// AdminPrincipalRequest creates an admin principal with Role "viewer",
+// "operator" or "owner".
+type AdminPrincipalRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// UpdateAdminPrincipalRequest changes the role of an admin principal, or
+// deactivates it with IsActive false. Nil fields are kept.
+type UpdateAdminPrincipalRequest struct {
+	Role     *string `json:"role"`
+	IsActive *bool   `json:"is_active"`
+}
+
+// AdminTokenRequest issues another token to an admin principal. A nil
+// ExpiresAt never expires.
+type AdminTokenRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// OrganizationRequest creates or updates an organization. RatePerSecond and
+// Burst size the rate limit its keys share; nil uses the server default.
+// DeferOverLimit puts every key of the organization in defer mode.
+type OrganizationRequest struct {
+	Name           string   `json:"name"`
+	RatePerSecond  *float64 `json:"rate_per_second"`
+	Burst          int32    `json:"burst"`
+	DeferOverLimit bool     `json:"defer_over_limit"`
+}
+
+// APIKeyOrganizationRequest moves an API key to another organization. An
+// empty OrganizationID takes the key out of its organization.
+type APIKeyOrganizationRequest struct {
+	OrganizationID string `json:"organization_id"`
+}
+
+type JobStats struct {
+	ByStatus        map[string]int64 `json:"by_status"`
+	ExpiredLastHour int64            `json:"expired_last_hour"`
+}
+
+// JobTypeLimitRequest sets cluster-wide limits for one job type. A nil field
+// leaves that dimension unlimited.
+type JobTypeLimitRequest struct {
+	MaxConcurrent *int32   `json:"max_concurrent"`
+	RatePerSecond *float64 `json:"rate_per_second"`
+	Burst         int32    `json:"burst"`
+}
+
+// CircuitBreakerRequest configures when a job type's breaker opens: once
+// FailureRateThreshold of at least MinRequests results within WindowSeconds
+// failed. It then stays open for OpenSeconds before a probe is let through.
+type CircuitBreakerRequest struct {
+	FailureRateThreshold float64 `json:"failure_rate_threshold"`
+	MinRequests          int32   `json:"min_requests"`
+	WindowSeconds        int32   `json:"window_seconds"`
+	OpenSeconds          int32   `json:"open_seconds"`
+}
+
+// TenantWeightRequest sets a tenant's share of worker capacity relative to
+// other tenants; the default weight is 1.
+type TenantWeightRequest struct {
+	Weight int32 `json:"weight"`
+}
+
+// BacklogLimitRequest caps the pending jobs of a queue, job type or API key.
+// Scope "global" caps all pending jobs and takes no name.
+type BacklogLimitRequest struct {
+	Scope      string `json:"scope"`
+	Name       string `json:"name"`
+	MaxPending int32  `json:"max_pending"`
+}
+
+// ExecutionWindowRequest restricts when the jobs of a job type or queue may
+// run. An "allow" window repeats weekly on Weekdays (0 is Sunday, empty means
+// every day) from Start to End, e.g. "09:00" to "18:00", in Timezone. A
+// "blackout" window forbids running from StartsAt to EndsAt.
+type ExecutionWindowRequest struct {
+	Scope       string     `json:"scope"`
+	Name        string     `json:"name"`
+	Kind        string     `json:"kind"`
+	Timezone    string     `json:"timezone"`
+	Weekdays    []int32    `json:"weekdays"`
+	Start       string     `json:"start"`
+	End         string     `json:"end"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	Description string     `json:"description"`
+}
+
+// RolloutRequest routes CanaryPercent of a job type's jobs to workers running
+// CanaryVersion of its handler and the rest to StableVersion. Once both have
+// run MinJobs jobs, the rollout is rolled back automatically if the canary's
+// error rate is above the stable one by more than MaxErrorRateIncrease.
+type RolloutRequest struct {
+	StableVersion        string   `json:"stable_version"`
+	CanaryVersion        string   `json:"canary_version"`
+	CanaryPercent        int32    `json:"canary_percent"`
+	MinJobs              int32    `json:"min_jobs"`
+	MaxErrorRateIncrease *float64 `json:"max_error_rate_increase"`
+}
+
+type RollbackRequest struct {
+	Reason string `json:"reason"`
 }
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	db "github.com/franzego/distributed_task_queue/db/sqlc"
+)
+
+// alertTimeout bounds each quarantine webhook POST.
+const alertTimeout = 5 * time.Second
+
+type quarantineAlert struct {
+	Event        string `json:"event"`
+	JobID        string `json:"job_id"`
+	Type         string `json:"type"`
+	LostAttempts int32  `json:"lost_attempts"`
+	Error        string `json:"error"`
+}
+
+// alertQuarantined tells admins about newly quarantined jobs: always in the
+// log, and as a JSON POST to QUARANTINE_WEBHOOK_URL when it is set. The POST
+// is sent in the background so a slow webhook cannot stall the worker loop.
+func (w *Worker) alertQuarantined(jobs ...db.Job) {
+	for _, job := range jobs {
+		log.Printf("ALERT: job %s of type %s quarantined after %d lost attempts: %s",
+			job.ID, job.Type, job.LostAttempts, job.ErrorMessage.String)
+		if w.alertWebhook == "" {
+			continue
+		}
+		alert := quarantineAlert{
+			Event:        "job_quarantined",
+			JobID:        job.ID,
+			Type:         job.Type,
+			LostAttempts: job.LostAttempts,
+			Error:        job.ErrorMessage.String,
+		}
+		go func() {
+			if err := w.postAlert(alert); err != nil {
+				log.Printf("Could not send quarantine alert for job %s: %v", alert.JobID, err)
+			}
+		}()
+	}
+}
+func (w *Worker) postAlert(alert quarantineAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.alertWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
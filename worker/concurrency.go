@@ -0,0 +1,209 @@
+package worker
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historySize is how many limit changes are kept per job type.
+const historySize = 50
+
+type ConcurrencyConfig struct {
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// MaxTotal caps the jobs running at once across all types.
+	MaxTotal int
+	// TargetLatency is the handler latency above which a type backs off.
+	TargetLatency time.Duration
+	// Backoff is the factor a limit is multiplied by when it backs off.
+	Backoff float64
+}
+
+func DefaultConcurrencyConfig() ConcurrencyConfig {
+	return ConcurrencyConfig{
+		InitialLimit:  1,
+		MinLimit:      1,
+		MaxLimit:      16,
+		MaxTotal:      32,
+		TargetLatency: 2 * time.Second,
+		Backoff:       0.5,
+	}
+}
+
+// Outcome is how a job finished, as far as the concurrency controller cares.
+type Outcome int
+
+const (
+	OutcomeSuccess Outcome = iota
+	// OutcomeRetriable is a failure that may be caused by load, e.g. a
+	// timeout or a 5xx from the email provider.
+	OutcomeRetriable
+	// OutcomePermanent is a failure unrelated to load, e.g. a bad payload.
+	OutcomePermanent
+)
+
+type LimitChange struct {
+	At     time.Time `json:"at"`
+	Limit  int       `json:"limit"`
+	Reason string    `json:"reason"`
+}
+
+type TypeConcurrency struct {
+	JobType   string        `json:"job_type"`
+	Limit     int           `json:"limit"`
+	Inflight  int           `json:"inflight"`
+	Latency   time.Duration `json:"latency_ns"`
+	Increases int           `json:"increases"`
+	Decreases int           `json:"decreases"`
+	History   []LimitChange `json:"history"`
+}
+
+type aimdLimit struct {
+	limit        float64
+	inflight     int
+	latency      time.Duration // moving average
+	lastDecrease time.Time
+	increases    int
+	decreases    int
+	history      []LimitChange
+}
+
+// AdaptiveConcurrency keeps an AIMD concurrency limit per job type: the limit
+// grows by roughly one slot per limit's worth of fast successes, and is cut
+// by Backoff as soon as latency goes over target or retriable errors show up.
+type AdaptiveConcurrency struct {
+	cfg      ConcurrencyConfig
+	mu       sync.Mutex
+	types    map[string]*aimdLimit
+	total    int
+	released chan struct{}
+}
+
+func NewAdaptiveConcurrency(cfg ConcurrencyConfig) *AdaptiveConcurrency {
+	return &AdaptiveConcurrency{
+		cfg:      cfg,
+		types:    make(map[string]*aimdLimit),
+		released: make(chan struct{}, 1),
+	}
+}
+
+func (a *AdaptiveConcurrency) get(jobType string) *aimdLimit {
+	l, exists := a.types[jobType]
+	if !exists {
+		l = &aimdLimit{limit: float64(a.cfg.InitialLimit)}
+		a.types[jobType] = l
+	}
+	return l
+}
+
+// Saturated reports whether the worker is at MaxTotal, and otherwise which
+// job types are at their own limit and should not be dequeued.
+func (a *AdaptiveConcurrency) Saturated() (bool, []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.total >= a.cfg.MaxTotal {
+		return true, nil
+	}
+	saturated := []string{}
+	for jobType, l := range a.types {
+		if l.inflight >= int(l.limit) {
+			saturated = append(saturated, jobType)
+		}
+	}
+	sort.Strings(saturated)
+	return false, saturated
+}
+
+func (a *AdaptiveConcurrency) Acquire(jobType string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.get(jobType).inflight++
+	a.total++
+}
+
+// Release frees the slot taken by Acquire and adjusts the type's limit from
+// the job's latency and outcome.
+func (a *AdaptiveConcurrency) Release(jobType string, latency time.Duration, outcome Outcome) {
+	a.mu.Lock()
+	l := a.get(jobType)
+	l.inflight--
+	a.total--
+	a.adjust(l, latency, outcome, time.Now())
+	a.mu.Unlock()
+	select {
+	case a.released <- struct{}{}:
+	default:
+	}
+}
+
+// Released is signalled whenever a slot frees up.
+func (a *AdaptiveConcurrency) Released() <-chan struct{} {
+	return a.released
+}
+
+func (a *AdaptiveConcurrency) adjust(l *aimdLimit, latency time.Duration, outcome Outcome, now time.Time) {
+	if l.latency == 0 {
+		l.latency = latency
+	} else {
+		l.latency = (l.latency*4 + latency) / 5
+	}
+	before := int(l.limit)
+	switch {
+	case outcome == OutcomePermanent:
+		return
+	case outcome == OutcomeRetriable || latency > a.cfg.TargetLatency:
+		// results of jobs started before the last cut would cut again for
+		// the same overload, so wait out one target latency first
+		if now.Sub(l.lastDecrease) < a.cfg.TargetLatency {
+			return
+		}
+		l.limit = math.Max(float64(a.cfg.MinLimit), l.limit*a.cfg.Backoff)
+		l.lastDecrease = now
+		if int(l.limit) != before {
+			l.decreases++
+			reason := "latency above target"
+			if outcome == OutcomeRetriable {
+				reason = "retriable error"
+			}
+			l.record(now, reason)
+		}
+	default:
+		l.limit = math.Min(float64(a.cfg.MaxLimit), l.limit+1/l.limit)
+		if int(l.limit) != before {
+			l.increases++
+			l.record(now, "healthy")
+		}
+	}
+}
+
+func (l *aimdLimit) record(now time.Time, reason string) {
+	l.history = append(l.history, LimitChange{At: now, Limit: int(l.limit), Reason: reason})
+	if len(l.history) > historySize {
+		l.history = l.history[len(l.history)-historySize:]
+	}
+}
+
+// Snapshot returns the current limits and their history, sorted by job type.
+func (a *AdaptiveConcurrency) Snapshot() []TypeConcurrency {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	snapshot := make([]TypeConcurrency, 0, len(a.types))
+	for jobType, l := range a.types {
+		snapshot = append(snapshot, TypeConcurrency{
+			JobType:   jobType,
+			Limit:     int(l.limit),
+			Inflight:  l.inflight,
+			Latency:   l.latency,
+			Increases: l.increases,
+			Decreases: l.decreases,
+			History:   append([]LimitChange(nil), l.history...),
+		})
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].JobType < snapshot[j].JobType
+	})
+	return snapshot
+}
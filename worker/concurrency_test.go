@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func testConfig() ConcurrencyConfig {
+	return ConcurrencyConfig{
+		InitialLimit:  1,
+		MinLimit:      1,
+		MaxLimit:      4,
+		MaxTotal:      8,
+		TargetLatency: 100 * time.Millisecond,
+		Backoff:       0.5,
+	}
+}
+
+func TestAdaptiveConcurrency_GrowsWhileHealthy(t *testing.T) {
+	a := NewAdaptiveConcurrency(testConfig())
+	for i := 0; i < 20; i++ {
+		a.Acquire("send_email")
+		a.Release("send_email", 10*time.Millisecond, OutcomeSuccess)
+	}
+	snapshot := a.Snapshot()
+	if snapshot[0].Limit != 4 {
+		t.Fatalf("expected limit to grow to the max of 4, got %d", snapshot[0].Limit)
+	}
+	if len(snapshot[0].History) != 3 {
+		t.Fatalf("expected 3 recorded increases, got %d", len(snapshot[0].History))
+	}
+}
+func TestAdaptiveConcurrency_BacksOff(t *testing.T) {
+	a := NewAdaptiveConcurrency(testConfig())
+	for i := 0; i < 20; i++ {
+		a.Acquire("send_email")
+		a.Release("send_email", 10*time.Millisecond, OutcomeSuccess)
+	}
+	a.Acquire("send_email")
+	a.Release("send_email", 10*time.Millisecond, OutcomeRetriable)
+	if limit := a.Snapshot()[0].Limit; limit != 2 {
+		t.Fatalf("expected limit to halve to 2, got %d", limit)
+	}
+	// a slow job within the cool-down must not cut again
+	a.Acquire("send_email")
+	a.Release("send_email", time.Second, OutcomeSuccess)
+	if limit := a.Snapshot()[0].Limit; limit != 2 {
+		t.Fatalf("expected limit to stay at 2 during cool-down, got %d", limit)
+	}
+}
+func TestAdaptiveConcurrency_IgnoresPermanentFailures(t *testing.T) {
+	a := NewAdaptiveConcurrency(testConfig())
+	a.Acquire("send_email")
+	a.Acquire("send_email")
+	a.Release("send_email", 10*time.Millisecond, OutcomePermanent)
+	snapshot := a.Snapshot()
+	if snapshot[0].Limit != 1 || snapshot[0].Inflight != 1 {
+		t.Fatalf("expected limit 1 with 1 in flight, got %+v", snapshot[0])
+	}
+}
+func TestAdaptiveConcurrency_Saturated(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxTotal = 2
+	a := NewAdaptiveConcurrency(cfg)
+	a.Acquire("send_email")
+	full, saturated := a.Saturated()
+	if full || len(saturated) != 1 || saturated[0] != "send_email" {
+		t.Fatalf("expected send_email to be saturated, got full=%v %v", full, saturated)
+	}
+	a.Acquire("logs")
+	if full, _ := a.Saturated(); !full {
+		t.Fatal("expected worker to be full at MaxTotal")
+	}
+}
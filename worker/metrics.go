@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// MetricsHandler serves the adaptive concurrency state: Prometheus text on
+// /metrics and the full limit history as JSON on /concurrency.
+func (w *Worker) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		snapshot := w.concurrency.Snapshot()
+		fmt.Fprintln(rw, "# HELP worker_concurrency_limit Current adaptive concurrency limit per job type.")
+		fmt.Fprintln(rw, "# TYPE worker_concurrency_limit gauge")
+		for _, t := range snapshot {
+			fmt.Fprintf(rw, "worker_concurrency_limit{job_type=%q} %d\n", t.JobType, t.Limit)
+		}
+		fmt.Fprintln(rw, "# HELP worker_concurrency_inflight Jobs currently running per job type.")
+		fmt.Fprintln(rw, "# TYPE worker_concurrency_inflight gauge")
+		for _, t := range snapshot {
+			fmt.Fprintf(rw, "worker_concurrency_inflight{job_type=%q} %d\n", t.JobType, t.Inflight)
+		}
+		fmt.Fprintln(rw, "# HELP worker_handler_latency_seconds Moving average of handler latency per job type.")
+		fmt.Fprintln(rw, "# TYPE worker_handler_latency_seconds gauge")
+		for _, t := range snapshot {
+			fmt.Fprintf(rw, "worker_handler_latency_seconds{job_type=%q} %g\n", t.JobType, t.Latency.Seconds())
+		}
+		fmt.Fprintln(rw, "# HELP worker_concurrency_limit_changes_total Adaptive limit changes per job type.")
+		fmt.Fprintln(rw, "# TYPE worker_concurrency_limit_changes_total counter")
+		for _, t := range snapshot {
+			fmt.Fprintf(rw, "worker_concurrency_limit_changes_total{job_type=%q,direction=\"increase\"} %d\n", t.JobType, t.Increases)
+			fmt.Fprintf(rw, "worker_concurrency_limit_changes_total{job_type=%q,direction=\"decrease\"} %d\n", t.JobType, t.Decreases)
+		}
+	})
+	mux.HandleFunc("/concurrency", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(w.concurrency.Snapshot())
+	})
+	return mux
+}
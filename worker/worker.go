@@ -2,44 +2,123 @@ package worker
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"runtime/debug"
 	"time"
 
 	db "github.com/franzego/distributed_task_queue/db/sqlc"
 	"github.com/franzego/distributed_task_queue/internal"
 	"github.com/franzego/distributed_task_queue/internal/handler"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+// heartbeatInterval is how often a worker refreshes its registration.
+const heartbeatInterval = 30 * time.Second
+
 type Worker struct {
-	r *internal.Repository
-	e *handler.EmailHandler
+	r            *internal.Repository
+	e            *handler.EmailHandler
+	concurrency  *AdaptiveConcurrency
+	alertWebhook string
+	id           string
+	hostname     string
+	// labels advertise what this worker can do, e.g. "smtp-relay" or
+	// "high-mem"; it only claims jobs whose requires it covers.
+	labels []string
+	// handlerVersions maps each job type this worker can run to the version
+	// of its handler.
+	handlerVersions map[string]string
+	// throttled holds the job types the last dequeues deferred, and until
+	// when; they are not claimed again before then.
+	throttled     map[string]time.Time
+	lastHeartbeat time.Time
 }
 
-func NewWorkerService(r *internal.Repository, e *handler.EmailHandler) *Worker {
+// NewWorkerService reads the worker's settings from the environment. It
+// fails if WORKER_LABELS holds a label that jobs could never require.
+func NewWorkerService(r *internal.Repository, e *handler.EmailHandler) (*Worker, error) {
 	if r == nil {
-		return nil
+		return nil, errors.New("worker needs a repository")
 	}
-	return &Worker{
-		r: r,
-		e: e,
+	labels, err := internal.ParseLabels(os.Getenv("WORKER_LABELS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WORKER_LABELS: %w", err)
 	}
+	handlerVersions := map[string]string{}
+	if e != nil {
+		handlerVersions["send_email"] = e.Version
+	}
+	hostname, _ := os.Hostname()
+	return &Worker{
+		r:               r,
+		e:               e,
+		concurrency:     NewAdaptiveConcurrency(DefaultConcurrencyConfig()),
+		alertWebhook:    os.Getenv("QUARANTINE_WEBHOOK_URL"),
+		id:              uuid.New().String(),
+		hostname:        hostname,
+		labels:          labels,
+		handlerVersions: handlerVersions,
+		throttled:       make(map[string]time.Time),
+	}, nil
 }
 
+// WorkerFunction dequeues jobs and runs each one in its own goroutine, as
+// long as the adaptive concurrency limit of the job's type allows it.
 func (w *Worker) WorkerFunction() error {
-	log.Print("Worker has started")
+	log.Printf("Worker %s has started with labels %v and handler versions %v", w.id, w.labels, w.handlerVersions)
 	for {
+		w.heartbeat()
+		full, saturated := w.concurrency.Saturated()
+		if full {
+			// wake up for heartbeats even while every slot stays busy
+			select {
+			case <-w.concurrency.Released():
+			case <-time.After(heartbeatInterval):
+			}
+			continue
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		// we dequeue
-		job, err := w.r.DequeueJob(ctx)
+		quarantined, err := w.r.QuarantineLostJobs(ctx, internal.DefaultQuarantineThreshold)
+		if err != nil {
+			log.Print(err)
+		}
+		w.alertQuarantined(quarantined...)
+		// we dequeue, skipping the types we have no capacity for
+		opts := internal.DefaultDequeueOptions()
+		opts.ExcludedTypes = append(w.throttledTypes(time.Now()), saturated...)
+		opts.Labels = w.labels
+		opts.HandlerVersions = w.handlerVersions
+		job, err := w.r.DequeueJob(ctx, opts)
 		// cancel the context for this iteration immediately after dequeue returns
 		cancel()
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
 			log.Println("No Available Jobs. Waiting...")
-			time.Sleep(20 * time.Second)
+			// a finishing job may free capacity for a saturated type, and a
+			// throttled type may be claimed again before the poll is due
+			wait := 20 * time.Second
+			for _, until := range w.throttled {
+				wait = min(wait, time.Until(until))
+			}
+			select {
+			case <-time.After(wait):
+			case <-w.concurrency.Released():
+			}
+			continue
+		}
+		var throttled *internal.ThrottledError
+		if errors.As(err, &throttled) {
+			// the job went back to pending without using an attempt; the rest
+			// of its type would only be put back too
+			log.Print(err)
+			if !throttled.Until.IsZero() {
+				w.throttled[throttled.JobType] = throttled.Until
+			}
 			continue
 		}
 		if err != nil {
@@ -47,21 +126,62 @@ func (w *Worker) WorkerFunction() error {
 			time.Sleep(2 * time.Second)
 			continue
 		}
-		log.Printf("Processing job %s of type %s", job.ID, job.Type)
-		err = w.ProcessJobs(job)
-		if err != nil {
-			log.Printf("Job %s has failed: %v", job.ID, err)
-			w.JobFailed(job, err)
-		} else {
-			log.Printf("Job %s has completed successfully", job.ID)
-			w.CompletedJob(job)
-		}
+		w.concurrency.Acquire(job.Type)
+		go w.runJob(job)
+	}
 
+}
+func (w *Worker) runJob(job db.Job) {
+	log.Printf("Processing job %s of type %s", job.ID, job.Type)
+	start := time.Now()
+	crashed, err := w.processSafely(job)
+	latency := time.Since(start)
+	w.recordVersionOutcome(job, err, crashed, latency)
+	if crashed {
+		w.concurrency.Release(job.Type, latency, OutcomePermanent)
+		w.JobCrashed(job, err)
+		return
+	}
+	w.concurrency.Release(job.Type, latency, outcomeOf(err))
+	w.recordBreakerOutcome(job, err)
+	if err != nil {
+		log.Printf("Job %s has failed: %v", job.ID, err)
+		w.JobFailed(job, err)
+	} else if err := w.CompletedJob(job); err != nil {
+		log.Print(err)
+	} else {
+		log.Printf("Job %s has completed successfully", job.ID)
 	}
+}
 
+// processSafely runs ProcessJobs, turning a handler panic into an error so
+// one poison job cannot take down every other job running on this worker.
+func (w *Worker) processSafely(job db.Job) (crashed bool, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Job %s panicked: %v\n%s", job.ID, p, debug.Stack())
+			crashed, err = true, fmt.Errorf("handler panicked: %v", p)
+		}
+	}()
+	return false, w.ProcessJobs(job)
+}
+func outcomeOf(err error) Outcome {
+	var retriable *handler.RetriableError
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.As(err, &retriable), errors.Is(err, context.DeadlineExceeded):
+		return OutcomeRetriable
+	default:
+		return OutcomePermanent
+	}
 }
 func (w *Worker) ProcessJobs(job db.Job) error {
-	ctx := context.Background()
+	// the handler must not outlive the lease, otherwise another worker may
+	// already be running the same job, nor the job's own expiry
+	ctx, cancel := context.WithDeadline(context.Background(), jobDeadline(job))
+	defer cancel()
+	ctx = handler.WithJobID(ctx, job.ID)
 	switch job.Type {
 	case "send_email":
 		return w.e.HandleMail(ctx, job.Payload)
@@ -74,28 +194,154 @@ func (w *Worker) ProcessJobs(job db.Job) error {
 	}
 }
 
+// JobFailed marks the job failed, or expired if it ran past its expires_at.
 func (w *Worker) JobFailed(job db.Job, jobErr error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	attempts := job.Attempts + 1
+	status := internal.StatusFailed
+	scheduledAt := time.Now()
+	if job.ExpiresAt.Valid && !scheduledAt.Before(job.ExpiresAt.Time) {
+		status = internal.StatusExpired
+		jobErr = fmt.Errorf("job expired while running: %w", jobErr)
+	}
 	arg := db.FailJobParams{
 		ID:       job.ID,
-		Status:   "failed",
-		Attempts: job.Attempts + 1,
+		Status:   string(status),
+		Attempts: attempts,
 		ErrorMessage: pgtype.Text{
 			String: jobErr.Error(),
+			Valid:  true,
 		},
-		ScheduledAt: pgtype.Timestamptz{Time: time.Now()},
+		ScheduledAt: pgtype.Timestamptz{Time: scheduledAt, Valid: true},
+		LeaseToken:  job.LeaseToken,
 	}
 	err := w.r.FailJob(ctx, arg)
+	if errors.Is(err, internal.ErrStaleLease) {
+		log.Printf("Job %s was reclaimed by another worker, discarding failure", job.ID)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to mark %s as failed. %v", job.ID, err)
 	}
 }
+
+// JobCrashed records an attempt that ended without an outcome. The job goes
+// back to pending until it has crashed DefaultQuarantineThreshold times, and
+// is quarantined after that.
+func (w *Worker) JobCrashed(job db.Job, crashErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	crashed, err := w.r.CrashJob(ctx, db.CrashJobParams{
+		QuarantineThreshold: internal.DefaultQuarantineThreshold,
+		ErrorMessage:        pgtype.Text{String: crashErr.Error(), Valid: true},
+		ID:                  job.ID,
+		LeaseToken:          job.LeaseToken,
+	})
+	if err != nil {
+		log.Printf("Failed to record crash of job %s. %v", job.ID, err)
+		return
+	}
+	if crashed.Status == string(internal.StatusQuarantined) {
+		w.alertQuarantined(crashed)
+	}
+}
 func (w *Worker) CompletedJob(job db.Job) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := w.r.CompletedJob(ctx, job.ID); err != nil {
-		return fmt.Errorf("error marking job %s as completed %v", job.ID, err)
+	if err := w.r.CompletedJob(ctx, job.ID, job.LeaseToken); err != nil {
+		return fmt.Errorf("error marking job %s as completed %w", job.ID, err)
 	}
 	return nil
 }
+
+// recordBreakerOutcome reports the result to the job type's circuit breaker.
+// Only retriable errors count as failures: a bad payload says nothing about
+// whether the dependency behind the handler is healthy.
+func (w *Worker) recordBreakerOutcome(job db.Job, jobErr error) {
+	var retriable *handler.RetriableError
+	if jobErr != nil && !errors.As(jobErr, &retriable) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	breaker, err := w.r.RecordBreakerOutcome(ctx, job.Type, jobErr != nil)
+	if err != nil {
+		log.Printf("Could not record circuit breaker outcome for %s: %v", job.Type, err)
+		return
+	}
+	if breaker.State == string(internal.BreakerOpen) {
+		log.Printf("Circuit breaker for %s is open until %s", job.Type, breaker.OpenUntil.Time)
+	}
+}
+
+// jobDeadline is the earlier of the lease expiry and the job's expires_at.
+func jobDeadline(job db.Job) time.Time {
+	deadline := job.LeaseExpiresAt.Time
+	if job.ExpiresAt.Valid && job.ExpiresAt.Time.Before(deadline) {
+		deadline = job.ExpiresAt.Time
+	}
+	return deadline
+}
+
+// throttledTypes lists the job types still throttled at now and forgets the
+// others.
+func (w *Worker) throttledTypes(now time.Time) []string {
+	types := []string{}
+	for jobType, until := range w.throttled {
+		if now.Before(until) {
+			types = append(types, jobType)
+		} else {
+			delete(w.throttled, jobType)
+		}
+	}
+	return types
+}
+
+// heartbeat registers the worker, at most once per heartbeatInterval.
+func (w *Worker) heartbeat() {
+	if time.Since(w.lastHeartbeat) < heartbeatInterval {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	versions, err := json.Marshal(w.handlerVersions)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	_, err = w.r.RegisterWorker(ctx, db.RegisterWorkerParams{
+		ID:              w.id,
+		Hostname:        w.hostname,
+		Labels:          w.labels,
+		HandlerVersions: versions,
+	})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	w.lastHeartbeat = time.Now()
+}
+
+// recordVersionOutcome adds the result to the stats of the handler version
+// that ran the job, which may roll back the job type's canary. A crash counts
+// as a failure, but a permanent error such as a bad payload is left out, as
+// every version would fail on it.
+func (w *Worker) recordVersionOutcome(job db.Job, jobErr error, crashed bool, latency time.Duration) {
+	if !job.HandlerVersion.Valid {
+		return
+	}
+	if !crashed && outcomeOf(jobErr) == OutcomePermanent {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rollout, rolledBack, err := w.r.RecordHandlerOutcome(ctx, job.Type, job.HandlerVersion.String, jobErr != nil, latency)
+	if err != nil {
+		log.Printf("Could not record outcome of %s handler %s: %v", job.Type, job.HandlerVersion.String, err)
+		return
+	}
+	if rolledBack {
+		log.Printf("Rolled back %s handler %s to %s: %s", job.Type, rollout.CanaryVersion, rollout.StableVersion, rollout.Reason.String)
+	}
+}